@@ -3,53 +3,265 @@ package commands
 import (
 	"dotman/services"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/spf13/cobra"
 )
 
 func NewAddCommand(dotman *services.DotmanService, fs *services.FileService) *cobra.Command {
+	var encrypt bool
+	var entry services.Entry
 	cmd := &cobra.Command{
-		Use:   "add [file]",
-		Short: "Add a file from $HOME into the repo",
-		Args:  cobra.ExactArgs(1),
+		Use:   "add <path>...",
+		Short: "Add files or directories from $HOME into the repo",
+		Long: `Copy files or whole directories from $HOME into the repo.
+
+Relative paths are resolved against $HOME. Glob patterns (quoted so the shell
+does not expand them) are supported. Directories are imported recursively and
+tracked as a whole, so files that later appear under them are offered by submit.
+Paths matched by .dotmanignore are skipped.
+
+Files outside $HOME are stored under the repo root that maps onto them:
+/etc/hosts goes to root/etc/hosts, and other roots can be declared in the repo
+manifest (dotman.json).
+
+With --encrypt the files are stored in the repo encrypted (AES-256-GCM). The
+key is read from $DOTMAN_PASSPHRASE, or else from a key file outside the repo
+(~/.config/dotman/key by default) that is created on first use and must be
+copied to your other machines by hand.
+
+--mode, --os, --arch, --host, --tag, --template and --target record an entry
+for each path in the repo manifest (dotman.json): apply then writes it with
+that mode, only on matching machines, to a different path, or renders it as a
+template.
+
+Examples:
+  dotman add ~/.zshrc ~/.gitconfig
+  dotman add ~/.config/nvim
+  dotman add '.config/fish/*.fish'
+  dotman add --encrypt ~/.netrc
+  dotman add --os linux --mode 0600 ~/.config/systemd/user/backup.service`,
+		Args: cobra.MinimumNArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
-			file := args[0]
 			dotmanDir, err := dotman.IsInitialized()
 			if err != nil {
 				fmt.Fprintln(cmd.ErrOrStderr(), err)
 				return
 			}
-			fs := services.NewFileService()
-			homeDir := fs.HomeDir()
-			srcPath := file
-			if !fs.IsAbs(srcPath) {
-				srcPath = fs.Join(homeDir, srcPath)
+			if err := dotman.RequireWritable("add"); err != nil {
+				fmt.Fprintln(cmd.ErrOrStderr(), err)
+				return
 			}
-			info, err := fs.Stat(srcPath)
+			manifest, err := dotman.Manifest()
 			if err != nil {
-				fmt.Fprintf(cmd.ErrOrStderr(), "[ERROR] Source file does not exist: %s\n", srcPath)
+				fmt.Fprintln(cmd.ErrOrStderr(), err)
 				return
 			}
-			if info.IsDir() {
-				fmt.Fprintf(cmd.ErrOrStderr(), "[ERROR] Directories are not supported yet: %s\n", srcPath)
+			ignore, err := dotman.IgnoreMatcher()
+			if err != nil {
+				fmt.Fprintln(cmd.ErrOrStderr(), err)
 				return
 			}
-			relPath, err := fs.Rel(homeDir, srcPath)
+			content, err := dotman.Content()
 			if err != nil {
-				fmt.Fprintf(cmd.ErrOrStderr(), "[ERROR] Failed to compute relative path: %v\n", err)
+				fmt.Fprintln(cmd.ErrOrStderr(), err)
 				return
 			}
-			destPath := fs.Join(dotmanDir, "home", relPath)
-			if err := fs.MkdirAll(fs.Join(destPath, ".."), 0755); err != nil {
-				fmt.Fprintf(cmd.ErrOrStderr(), "[ERROR] Failed to create destination directory: %v\n", err)
-				return
+			homeDir := fs.HomeDir()
+			repoHome := fs.Join(dotmanDir, "home")
+
+			recordEntry := entry.Mode != "" || entry.Target != "" || len(entry.OS) > 0 || len(entry.Arch) > 0 || len(entry.Host) > 0 || len(entry.Tags) > 0 || entry.Template
+			if recordEntry {
+				entry.Encrypted = encrypt
+				check := entry
+				check.Path = "file"
+				if err := check.Validate(); err != nil {
+					fmt.Fprintf(cmd.ErrOrStderr(), "[ERROR] %v\n", err)
+					return
+				}
 			}
-			if err := fs.CopyFile(srcPath, destPath, info.Mode()); err != nil {
-				fmt.Fprintf(cmd.ErrOrStderr(), "[ERROR] Failed to copy file: %v\n", err)
-				return
+
+			var enc *services.EncryptionService
+			if encrypt {
+				enc = dotman.Encryption()
+				created, err := enc.EnsureKey()
+				if err != nil {
+					fmt.Fprintf(cmd.ErrOrStderr(), "[ERROR] Failed to create encryption key: %v\n", err)
+					return
+				}
+				if created {
+					fmt.Fprintf(cmd.OutOrStdout(), "[INFO] Created encryption key %s. Back it up and copy it to your other machines; it is not stored in the repo.\n", enc.KeyPath())
+				}
+			}
+
+			manifestChanged := false
+			for _, arg := range args {
+				srcPaths, err := expandAddArg(fs, homeDir, arg)
+				if err != nil {
+					fmt.Fprintf(cmd.ErrOrStderr(), "[ERROR] %v\n", err)
+					continue
+				}
+				for _, srcPath := range srcPaths {
+					info, err := fs.Stat(srcPath)
+					if err != nil {
+						fmt.Fprintf(cmd.ErrOrStderr(), "[ERROR] Source file does not exist: %s\n", srcPath)
+						continue
+					}
+					relPath, ok := trackedRel(manifest, homeDir, srcPath)
+					if !ok {
+						fmt.Fprintf(cmd.ErrOrStderr(), "[ERROR] Path is not inside $HOME or a configured root: %s\n", srcPath)
+						continue
+					}
+					if rule := ignore.Match(relPath, info.IsDir()); rule != nil && !rule.Negate {
+						fmt.Fprintf(cmd.ErrOrStderr(), "[WARN] Skipping %s (ignored by %s)\n", srcPath, rule)
+						continue
+					}
+					var added bool
+					if !info.IsDir() {
+						added = addFile(cmd.OutOrStdout(), cmd.ErrOrStderr(), fs, content, enc, relPath, srcPath, manifest.RepoPath(repoHome, relPath), info)
+					} else if added = addDirectory(cmd.OutOrStdout(), cmd.ErrOrStderr(), fs, content, enc, ignore, relPath, srcPath, manifest.RepoPath(repoHome, relPath)); added {
+						if manifest.TrackDirectory(relPath) {
+							manifestChanged = true
+						}
+					}
+					if added && recordEntry {
+						e := entry
+						e.Path = filepath.ToSlash(relPath)
+						if err := manifest.SetEntry(e); err != nil {
+							fmt.Fprintf(cmd.ErrOrStderr(), "[ERROR] Failed to record %s in %s: %v\n", relPath, services.ManifestFileName, err)
+							continue
+						}
+						manifestChanged = true
+					}
+				}
+			}
+			if manifestChanged {
+				if err := manifest.Save(); err != nil {
+					fmt.Fprintf(cmd.ErrOrStderr(), "[ERROR] Failed to update %s: %v\n", manifest.Path(), err)
+					return
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "[INFO] Updated %s\n", manifest.Path())
 			}
-			fmt.Fprintf(cmd.OutOrStdout(), "[INFO] Added %s to repo as %s\n", srcPath, destPath)
 		},
 	}
+	cmd.Flags().BoolVar(&encrypt, "encrypt", false, "Store the files encrypted in the repo")
+	cmd.Flags().StringVar(&entry.Mode, "mode", "", "Permissions to apply the files with, e.g. 0600")
+	cmd.Flags().StringSliceVar(&entry.OS, "os", nil, "Only manage the files on these operating systems (e.g. linux,darwin)")
+	cmd.Flags().StringSliceVar(&entry.Arch, "arch", nil, "Only manage the files on these architectures (e.g. amd64,arm64)")
+	cmd.Flags().StringSliceVar(&entry.Host, "host", nil, "Only manage the files on these hosts (short host names)")
+	cmd.Flags().StringSliceVar(&entry.Tags, "tag", nil, "Tag the files in the manifest (see apply --tag)")
+	cmd.Flags().BoolVar(&entry.Template, "template", false, "Render the files as Go templates on apply")
+	cmd.Flags().StringVar(&entry.Target, "target", "", "Apply the files to this path instead (absolute, or relative to $HOME)")
 	return cmd
 }
+
+// expandAddArg resolves an add argument to absolute paths: "~" is expanded,
+// relative paths are taken from homeDir and glob patterns are expanded.
+func expandAddArg(fs *services.FileService, homeDir, arg string) ([]string, error) {
+	path := fs.ExpandHome(arg)
+	if !fs.IsAbs(path) {
+		path = fs.Join(homeDir, path)
+	}
+	if !strings.ContainsAny(path, "*?[") {
+		return []string{path}, nil
+	}
+	matches, err := filepath.Glob(path)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid pattern %s: %v", arg, err)
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("No files match %s", arg)
+	}
+	return matches, nil
+}
+
+// addFile copies a single file (rel, its name in the repo) into the repo,
+// preserving its mode. When enc is non-nil the file is stored encrypted with
+// 0600 permissions; otherwise it passes through content, so marked secret
+// values are replaced by placeholders.
+func addFile(stdout, stderr io.Writer, fs *services.FileService, content *services.ContentService, enc *services.EncryptionService, rel, srcPath, destPath string, info os.FileInfo) bool {
+	if !info.Mode().IsRegular() {
+		fmt.Fprintf(stderr, "[WARN] Skipping %s (not a regular file)\n", srcPath)
+		return false
+	}
+	if err := fs.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+		fmt.Fprintf(stderr, "[ERROR] Failed to create destination directory: %v\n", err)
+		return false
+	}
+	plaintext, err := os.ReadFile(srcPath)
+	if err != nil {
+		fmt.Fprintf(stderr, "[ERROR] Failed to read file: %v\n", err)
+		return false
+	}
+	if enc != nil {
+		blob, err := enc.Encrypt(plaintext)
+		if err != nil {
+			fmt.Fprintf(stderr, "[ERROR] Failed to encrypt %s: %v\n", srcPath, err)
+			return false
+		}
+		if err := fs.WriteFile(destPath, blob, 0600); err != nil {
+			fmt.Fprintf(stderr, "[ERROR] Failed to write file: %v\n", err)
+			return false
+		}
+		fmt.Fprintf(stdout, "[INFO] Added %s to repo encrypted as %s\n", srcPath, destPath)
+		return true
+	}
+	data, err := content.RepoContent(rel, plaintext, destPath)
+	if err != nil {
+		fmt.Fprintf(stderr, "[ERROR] Failed to convert %s for the repo: %v\n", srcPath, err)
+		return false
+	}
+	if err := fs.WriteFile(destPath, data, info.Mode().Perm()); err != nil {
+		fmt.Fprintf(stderr, "[ERROR] Failed to copy file: %v\n", err)
+		return false
+	}
+	if err := content.Persist(); err != nil {
+		fmt.Fprintf(stderr, "[ERROR] Failed to save the secret store: %v\n", err)
+		return false
+	}
+	fmt.Fprintf(stdout, "[INFO] Added %s to repo as %s\n", srcPath, destPath)
+	return true
+}
+
+// addDirectory recursively copies a directory tree (dirRel, its name in the
+// repo) into the repo, preserving file and directory modes and skipping
+// ignored paths. It reports whether the tree was imported.
+func addDirectory(stdout, stderr io.Writer, fs *services.FileService, content *services.ContentService, enc *services.EncryptionService, ignore *services.IgnoreMatcher, dirRel, srcDir, destDir string) bool {
+	count := 0
+	err := filepath.Walk(srcDir, func(path string, info os.FileInfo, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		rel, err := fs.Rel(srcDir, path)
+		if err != nil {
+			return err
+		}
+		dest := fs.Join(destDir, rel)
+		fileRel := fs.Join(dirRel, rel)
+		if path != srcDir && ignore.Ignored(fileRel, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() {
+			if err := fs.MkdirAll(dest, info.Mode().Perm()); err != nil {
+				return err
+			}
+			return os.Chmod(dest, info.Mode().Perm())
+		}
+		if addFile(io.Discard, stderr, fs, content, enc, fileRel, path, dest, info) {
+			count++
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Fprintf(stderr, "[ERROR] Failed to add directory %s: %v\n", srcDir, err)
+		return false
+	}
+	fmt.Fprintf(stdout, "[INFO] Added %s (%d file(s)) to repo as %s\n", srcDir, count, destDir)
+	return true
+}
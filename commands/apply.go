@@ -2,11 +2,13 @@ package commands
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
+	"time"
 
 	"dotman/diffview"
 	"dotman/services"
@@ -16,148 +18,302 @@ import (
 	"github.com/spf13/cobra"
 )
 
-func NewApplyCommand(dotman *services.DotmanService, git *services.GitService, fs *services.FileService) *cobra.Command {
+func NewApplyCommand(dotman *services.DotmanService, git services.Git, fs *services.FileService, state *services.StateService, backups *services.BackupService) *cobra.Command {
 	var dryRun bool
 	var noPull bool
+	var patch bool
+	var showSecrets bool
+	var yes bool
+	var tags []string
 	cmd := &cobra.Command{
 		Use:   "apply",
 		Short: "Apply dotfiles to your home directory",
+		Long: `Copy files that changed in the repo into your home directory.
+
+After reviewing the diffs, answer 'y' to apply everything or 's' to pick
+individual files. With --patch each change is offered hunk by hunk, like
+'git add -p'; hunks that are not applied stay in your home copy as local
+changes that 'dotman submit' will offer to send back.
+
+Encrypted files are decrypted, and secret placeholders filled in from the
+local secret store, into $HOME with 0600 permissions. Their contents are never
+shown in diffs unless --show-secrets is given.
+
+Entries in the repo manifest (dotman.json) can restrict files to some
+operating systems, architectures or hosts, write them to a different target
+(per machine if needed) or with a fixed mode. With --tag only entries carrying
+that tag are applied. Files under root/ are applied to the matching path under
+/, and other roots to the path the manifest or 'dotman config set root.<name>'
+gives; targets dotman cannot write, e.g. in /etc without sudo, are reported
+and skipped before anything is written.
+
+With --yes nothing is asked: new and repo-changed files are applied, and files
+changed on both sides are left alone. This suits scripts and read-only
+machines (see 'dotman init --read-only').`,
 		Run: func(cmd *cobra.Command, args []string) {
-			if _, err := dotman.IsInitialized(); err != nil {
-				fmt.Fprintln(os.Stderr, err)
-				os.Exit(1)
-			}
-			repoHome, err := dotman.GetHomeDir()
-			if err != nil {
-				fmt.Fprintln(os.Stderr, err)
-				os.Exit(1)
-			}
+			exitOnError(runApply(cmd, dotman, git, fs, state, backups, dryRun, noPull, patch, showSecrets, yes, tags))
+		},
+	}
+	cmd.Flags().BoolVarP(&dryRun, "dry-run", "d", false, "Run apply without making changes")
+	cmd.Flags().BoolVar(&noPull, "no-pull", false, "Skip git pull before applying changes")
+	cmd.Flags().BoolVarP(&patch, "patch", "p", false, "Choose the changes to apply hunk by hunk")
+	cmd.Flags().BoolVarP(&yes, "yes", "y", false, "Apply without prompting, leaving files changed on both sides alone")
+	cmd.Flags().BoolVar(&showSecrets, "show-secrets", false, "Show the decrypted contents of encrypted files in diffs")
+	cmd.Flags().StringArrayVar(&tags, "tag", nil, "Only apply manifest entries carrying this tag (repeatable)")
+	return cmd
+}
 
-			if !noPull {
-				if dryRun {
-					fmt.Println("[apply] Dry run: would pull with rebase from remote.")
-				} else {
-					pullOut, err := git.PullRebase(repoHome)
-					if err != nil {
-						fmt.Fprintf(os.Stderr, "[apply] Pull failed: %v\n%s", err, pullOut)
-						os.Exit(1)
-					}
-					fmt.Println("[apply] Pulled latest changes from remote.")
+func runApply(cmd *cobra.Command, dotman *services.DotmanService, git services.Git, fs *services.FileService, state *services.StateService, backups *services.BackupService, dryRun, noPull, patch, showSecrets, yes bool, tags []string) error {
+	repoDir, err := dotman.IsInitialized()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return errReported
+	}
+	if yes && patch {
+		fmt.Fprintln(os.Stderr, "[apply] --yes cannot be combined with --patch.")
+		return errReported
+	}
+	repoHome, err := dotman.GetHomeDir()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return errReported
+	}
+
+	if !noPull {
+		if dryRun {
+			fmt.Println("[apply] Dry run: would pull with rebase from remote.")
+		} else {
+			_, err := git.PullRebase(repoHome)
+			switch {
+			case errors.Is(err, services.ErrNoUpstream):
+				fmt.Println("[apply] The repo has no upstream branch to pull from; applying it as is.")
+			case errors.Is(err, services.ErrRebaseConflict) && yes:
+				// Nobody is there to resolve it; leave the repo as it was.
+				if abortErr := git.RebaseAbort(repoDir); abortErr != nil {
+					reportGitError("apply", "Aborting the rebase", abortErr)
 				}
+				fmt.Fprintln(os.Stderr, "[apply] The pull conflicts with local commits and was undone. Run 'dotman apply' without --yes to resolve it.")
+				return errReported
+			case errors.Is(err, services.ErrRebaseConflict):
+				if !resolveRebase(bufio.NewScanner(cmd.InOrStdin()), git, "apply", repoDir, showSecrets) {
+					return errReported
+				}
+			case err != nil:
+				reportGitError("apply", "Pull", err)
+				return errReported
+			default:
+				fmt.Println("[apply] Pulled latest changes from remote.")
 			}
+		}
+	}
 
-			userHome := fs.HomeDir()
+	userHome := fs.HomeDir()
 
-			toUpdate, toCreate, err := fs.CompareFiles(repoHome, userHome)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "[apply] Error scanning files: %v\n", err)
-				os.Exit(1)
-			}
+	if err := state.Load(); err != nil {
+		fmt.Fprintf(os.Stderr, "[apply] Failed to load sync state: %v\n", err)
+		return errReported
+	}
+	content, err := dotman.Content()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return errReported
+	}
+	changed, toCreate, inSync, err := scanFiles(dotman, fs, state, content, repoHome, userHome, tags)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[apply] Error scanning files: %v\n", err)
+		return errReported
+	}
+	changed = skipUnreadable("apply", changed)
+	backup := backups.Begin("apply")
+	if !dryRun {
+		recordInSync(state, inSync, "apply")
+		defer saveState(state, "apply")
+		defer persistContent(content, "apply")
+		defer finishBackup(dotman, backups, backup, "apply")
+	}
 
-			if len(toCreate) > 0 {
-				fmt.Println("[apply] The following files are missing and will be created:")
-				for _, info := range toCreate {
-					fmt.Printf("  - %s\n", info.RelPath)
-				}
-			}
+	// Only repo-side changes are offered; local edits are left for submit.
+	var toUpdate, localOnly, conflicts []types.FileDiff
+	for _, info := range changed {
+		switch info.Direction {
+		case types.RepoChanged:
+			toUpdate = append(toUpdate, info)
+		case types.HomeChanged:
+			localOnly = append(localOnly, info)
+		case types.BothChanged:
+			conflicts = append(conflicts, info)
+		}
+	}
 
-			if len(toUpdate) > 0 {
-				fmt.Println("[apply] The following files are different and can be updated:")
-				for _, info := range toUpdate {
-					fmt.Printf("  - %s\n    repo: %s (%s)\n    user: %s (%s)\n", info.RelPath, info.RepoHash, info.RepoDate, info.UserHash, info.UserDate)
-				}
-			}
+	// Targets outside $HOME may need permissions dotman lacks; they are
+	// reported and skipped up front instead of failing partway through.
+	toCreate = skipUnwritable(fs, toCreate)
+	toUpdate = skipUnwritable(fs, toUpdate)
+	conflicts = skipUnwritable(fs, conflicts)
 
-			if len(toCreate) == 0 && len(toUpdate) == 0 {
-				fmt.Println("[apply] No files to apply.")
-				return
+	if len(localOnly) > 0 && dotman.ReadOnly() {
+		fmt.Println("[apply] The following files only changed in your home directory and are left alone:")
+		for _, info := range localOnly {
+			fmt.Printf("  - %s\n", info.RelPath)
+		}
+	} else if len(localOnly) > 0 {
+		fmt.Println("[apply] The following files only changed in your home directory and are left alone (use 'dotman submit'):")
+		for _, info := range localOnly {
+			fmt.Printf("  - %s\n", info.RelPath)
+		}
+	}
+
+	scan := bufio.NewScanner(cmd.InOrStdin())
+	if len(conflicts) > 0 {
+		fmt.Println("[apply] The following files changed on both sides:")
+		for _, info := range conflicts {
+			fmt.Printf("  - %s\n    repo: %s (%s)\n    user: %s (%s)\n", info.RelPath, info.RepoHash, info.RepoDate, info.UserHash, info.UserDate)
+		}
+		if yes {
+			fmt.Println("[apply] Leaving them alone; run 'dotman apply' without --yes to resolve them.")
+		} else if dryRun {
+			fmt.Println("[apply] Dry run: would ask how to resolve each conflicting file.")
+		} else {
+			resolveConflicts(scan, "apply", git, fs, state, content, backup, conflicts, userHome, showSecrets)
+		}
+	}
+
+	if len(toCreate) > 0 {
+		fmt.Println("[apply] The following files are missing and will be created:")
+		for _, info := range toCreate {
+			fmt.Printf("  - %s\n", info.RelPath)
+		}
+	}
+
+	if len(toUpdate) > 0 {
+		fmt.Println("[apply] The following files are different and can be updated:")
+		for _, info := range toUpdate {
+			fmt.Printf("  - %s\n    repo: %s (%s)\n    user: %s (%s)\n", info.RelPath, info.RepoHash, info.RepoDate, info.UserHash, info.UserDate)
+		}
+	}
+
+	if len(toCreate) == 0 && len(toUpdate) == 0 {
+		if len(conflicts) == 0 {
+			fmt.Println("[apply] No files to apply.")
+		}
+		return nil
+	}
+
+	fileSet := make(map[string]types.FileDiff)
+	for _, info := range toCreate {
+		fileSet[info.RelPath] = info
+	}
+	for _, info := range toUpdate {
+		fileSet[info.RelPath] = info
+	}
+	var allRelPaths []string
+	for rel := range fileSet {
+		allRelPaths = append(allRelPaths, rel)
+	}
+	sort.Strings(allRelPaths)
+
+	if patch {
+		if dryRun {
+			fmt.Println("[apply] Dry run: would offer each change hunk by hunk.")
+			return nil
+		}
+		created, createQuit := patchFiles(scan, fs, state, content, backup, toCreate, userHome, showSecrets)
+		updated := 0
+		if !createQuit {
+			updated, _ = patchFiles(scan, fs, state, content, backup, toUpdate, userHome, showSecrets)
+		}
+		fmt.Printf("[apply] Applied %d new file(s), updated %d file(s) in home directory.\n", created, updated)
+		return nil
+	}
+
+	applyAll := func() {
+		if dryRun {
+			fmt.Println("[apply] Dry run: would copy the following files:")
+			for _, info := range toCreate {
+				fmt.Printf("  - %s\n", info.RelPath)
 			}
+			for _, info := range toUpdate {
+				fmt.Printf("  - %s\n", info.RelPath)
+			}
+			return
+		}
+		applyFiles(fs, state, content, backup, toCreate, userHome)
+		applyFiles(fs, state, content, backup, toUpdate, userHome)
+		fmt.Printf("[apply] Applied %d new file(s), updated %d file(s) in home directory.\n", len(toCreate), len(toUpdate))
+	}
+	if yes {
+		applyAll()
+		return nil
+	}
 
-			// Show diffs up-front (similar to submit) so the user can review changes before applying.
-			{
-				fileSet := make(map[string]struct{})
-				for _, info := range toCreate {
-					fileSet[info.RelPath] = struct{}{}
-				}
-				for _, info := range toUpdate {
-					fileSet[info.RelPath] = struct{}{}
-				}
-				var allRelPaths []string
-				for rel := range fileSet {
-					allRelPaths = append(allRelPaths, rel)
-				}
-				sort.Strings(allRelPaths)
-
-				renderer := diffview.NewRenderer()
-				renderer.Theme.LeftTitle = "dotfiles"
-				renderer.Theme.RightTitle = "home dir"
-				fmt.Println()
-				for _, rel := range allRelPaths {
-					panels, err := renderer.RenderFiles([]diffview.FilePair{{
-						Label:     rel,
-						LeftPath:  filepath.Join(repoHome, rel),
-						RightPath: filepath.Join(userHome, rel),
-					}}, true)
-					if err != nil {
-						fmt.Fprintf(os.Stderr, "[apply] Failed to display diff viewer for %s: %v\n", rel, err)
-						os.Exit(1)
-					}
-					for _, p := range panels {
-						fmt.Println(p)
-						if strings.Contains(p, "\n") {
-							fmt.Println()
-						}
-					}
+	// Show diffs up-front (similar to submit) so the user can review changes before applying.
+	{
+
+		renderer := diffview.NewRenderer()
+		renderer.ShowSensitive = showSecrets
+		renderer.Theme.LeftTitle = "dotfiles"
+		renderer.Theme.RightTitle = "home dir"
+		fmt.Println()
+		for _, rel := range allRelPaths {
+			panels, err := renderer.RenderFiles([]diffview.FilePair{repoDiffPair(content, rel, fileSet[rel].RepoPath, fileSet[rel].HomePath)}, true)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "[apply] Failed to display diff viewer for %s: %v\n", rel, err)
+				return errReported
+			}
+			for _, p := range panels {
+				fmt.Println(p)
+				if strings.Contains(p, "\n") {
+					fmt.Println()
 				}
 			}
+		}
+	}
 
-			for {
-				fmt.Print("Apply these changes to your home directory? [y/N/d]: ")
-				scan := bufio.NewScanner(os.Stdin)
-				if !scan.Scan() {
-					fmt.Println("[apply] Aborted.")
-					return
-				}
-				resp := strings.ToLower(strings.TrimSpace(scan.Text()))
-				switch resp {
-				case "y", "yes":
-					if dryRun {
-						fmt.Println("[apply] Dry run: would copy the following files:")
-						for _, info := range toCreate {
-							fmt.Printf("  - %s\n", info.RelPath)
-						}
-						for _, info := range toUpdate {
-							fmt.Printf("  - %s\n", info.RelPath)
-						}
-						return
-					}
-
-					applyFiles(fs, toCreate, repoHome, userHome)
-					applyFiles(fs, toUpdate, repoHome, userHome)
-					fmt.Printf("[apply] Applied %d new file(s), updated %d file(s) in home directory.\n", len(toCreate), len(toUpdate))
-					return
-				case "n", "no", "":
-					fmt.Println("[apply] Aborted.")
-					return
-				case "d", "diff":
-					showDifferences(toUpdate, repoHome, userHome)
-					continue // re-prompt
-				default:
-					fmt.Println("[apply] Please enter 'y', 'n', or 'd'.")
-				}
+	for {
+		fmt.Print("Apply these changes to your home directory? [y/N/d/s]: ")
+		if !scan.Scan() {
+			fmt.Println("[apply] Aborted.")
+			return nil
+		}
+		resp := strings.ToLower(strings.TrimSpace(scan.Text()))
+		switch resp {
+		case "s", "select":
+			selectedPaths, proceed, err := startSelectionWizard("apply", allRelPaths)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "[apply] Failed to select files: %v\n", err)
+				return errReported
 			}
-		},
+			if !proceed {
+				fmt.Println("[apply] No files selected. Aborting.")
+				return nil
+			}
+			toCreate = filterDiffs(toCreate, selectedPaths)
+			toUpdate = filterDiffs(toUpdate, selectedPaths)
+			fallthrough
+		case "y", "yes":
+			applyAll()
+			return nil
+		case "n", "no", "":
+			fmt.Println("[apply] Aborted.")
+			return nil
+		case "d", "diff":
+			showDifferences(content, toUpdate, userHome, showSecrets)
+			continue // re-prompt
+		default:
+			fmt.Println("[apply] Please enter 'y', 'n', 'd' or 's'.")
+		}
 	}
-	cmd.Flags().BoolVarP(&dryRun, "dry-run", "d", false, "Run apply without making changes")
-	cmd.Flags().BoolVar(&noPull, "no-pull", false, "Skip git pull before applying changes")
-	return cmd
 }
 
-func showDifferences(files []types.FileDiff, repoHome, userHome string) {
+func showDifferences(content *services.ContentService, files []types.FileDiff, userHome string, showSecrets bool) {
 	for _, info := range files {
-		repoPath := filepath.Join(repoHome, info.RelPath)
-		userPath := filepath.Join(userHome, info.RelPath)
-		repoContent, err1 := os.ReadFile(repoPath)
+		if info.Sensitive && !showSecrets {
+			fmt.Printf("\n[diff] %s holds secrets; contents hidden (use --show-secrets)\n", info.RelPath)
+			continue
+		}
+		repoPath := info.RepoPath
+		userPath := info.HomePath
+		repoContent, _, err1 := content.HomeContent(info.RelPath, repoPath)
 		userContent, err2 := os.ReadFile(userPath)
 		if err1 != nil || err2 != nil {
 			fmt.Printf("[diff] Error reading files for %s\n", info.RelPath)
@@ -190,14 +346,18 @@ func showDifferences(files []types.FileDiff, repoHome, userHome string) {
 	}
 }
 
-func applyFiles(fs *services.FileService, files []types.FileDiff, repoHome, userHome string) {
+func applyFiles(fs *services.FileService, state *services.StateService, content *services.ContentService, backup *services.BackupSet, files []types.FileDiff, userHome string) {
 	for _, info := range files {
-		src := filepath.Join(repoHome, info.RelPath)
-		dst := filepath.Join(userHome, info.RelPath)
+		src := info.RepoPath
+		dst := info.HomePath
 
 		action := "create"
-		if _, err := os.Stat(dst); err != nil {
+		if _, err := os.Stat(dst); err == nil {
 			action = "update"
+			if err := backup.Save(userHome, homeRel(userHome, info), "overwrite"); err != nil {
+				fmt.Fprintf(os.Stderr, "[apply] Failed to back up %s, leaving it untouched: %v\n", info.RelPath, err)
+				continue
+			}
 		}
 
 		repoStat, err := os.Stat(src)
@@ -209,10 +369,175 @@ func applyFiles(fs *services.FileService, files []types.FileDiff, repoHome, user
 			fmt.Fprintf(os.Stderr, "[apply] Failed to create directory for %s: %v\n", dst, err)
 			continue
 		}
-		if err := fs.CopyFile(src, dst, repoStat.Mode()); err != nil {
+		data, _, err := content.HomeContent(info.RelPath, src)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[apply] Failed to read %s: %v\n", src, err)
+			continue
+		}
+		if err := fs.WriteFile(dst, data, homeMode(info, repoStat.Mode())); err != nil {
 			fmt.Fprintf(os.Stderr, "[apply] Failed to %s %s: %v\n", action, info.RelPath, err)
 			continue
 		}
+		if err := state.RecordContent(info.RelPath, data); err != nil {
+			fmt.Fprintf(os.Stderr, "[apply] Failed to record sync state for %s: %v\n", info.RelPath, err)
+		}
 		fmt.Printf("[apply] %s %s\n", action, info.RelPath)
 	}
 }
+
+// patchFiles offers the changes to each file hunk by hunk and writes only the
+// accepted ones into the home copy. When some hunks are declined the repo
+// version is recorded as the sync base, so the lines kept in $HOME count as
+// local changes rather than pending repo updates. It returns how many files
+// were written and whether the user quit.
+func patchFiles(scan *bufio.Scanner, fs *services.FileService, state *services.StateService, content *services.ContentService, backup *services.BackupSet, files []types.FileDiff, userHome string, showSecrets bool) (int, bool) {
+	written := 0
+	for _, info := range files {
+		src := info.RepoPath
+		dst := info.HomePath
+		repoContent, _, err := content.HomeContent(info.RelPath, src)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[apply] Failed to read %s: %v\n", src, err)
+			continue
+		}
+		homeContent, err := os.ReadFile(dst)
+		if err != nil && !os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "[apply] Failed to read %s: %v\n", dst, err)
+			continue
+		}
+		a := services.SplitLines(string(homeContent))
+		b := services.SplitLines(string(repoContent))
+		hunks := services.ComputeHunks(a, b, 3)
+		if len(hunks) == 0 {
+			continue
+		}
+
+		if info.Sensitive && !showSecrets {
+			// Hunks would print decrypted lines, so offer the file as a whole.
+			if confirm(scan, fmt.Sprintf("\n[apply] %s holds secrets; contents hidden. Apply it? [y/N]: ", info.RelPath)) {
+				applyFiles(fs, state, content, backup, []types.FileDiff{info}, userHome)
+				written++
+			}
+			continue
+		}
+
+		fmt.Printf("\n[apply] %s\n", info.RelPath)
+		accept, quit := selectHunks(scan, hunks, "Apply this hunk to your home file", nil)
+		switch n := countAccepted(accept); {
+		case n == len(hunks):
+			applyFiles(fs, state, content, backup, []types.FileDiff{info}, userHome)
+			written++
+		case n > 0:
+			homeStat, err := os.Stat(dst)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "[apply] Failed to stat %s: %v\n", dst, err)
+				break
+			}
+			if err := backup.Save(userHome, homeRel(userHome, info), "overwrite"); err != nil {
+				fmt.Fprintf(os.Stderr, "[apply] Failed to back up %s, leaving it untouched: %v\n", info.RelPath, err)
+				break
+			}
+			patched := strings.Join(services.ApplyHunks(a, b, hunks, accept), "")
+			if err := fs.WriteFile(dst, []byte(patched), homeMode(info, homeStat.Mode().Perm())); err != nil {
+				fmt.Fprintf(os.Stderr, "[apply] Failed to update %s: %v\n", info.RelPath, err)
+				break
+			}
+			if err := state.RecordContent(info.RelPath, repoContent); err != nil {
+				fmt.Fprintf(os.Stderr, "[apply] Failed to record sync state for %s: %v\n", info.RelPath, err)
+			}
+			fmt.Printf("[apply] patched %s (%d of %d hunks)\n", info.RelPath, n, len(hunks))
+			written++
+		}
+		if quit {
+			return written, true
+		}
+	}
+	return written, false
+}
+
+// skipUnwritable drops the files whose home copy cannot be written, telling
+// the user which ones and why.
+func skipUnwritable(fs *services.FileService, files []types.FileDiff) []types.FileDiff {
+	var out []types.FileDiff
+	for _, info := range files {
+		if err := fs.CheckWritable(info.HomePath); err != nil {
+			reason := err.Error()
+			if errors.Is(err, os.ErrPermission) {
+				reason = "no permission"
+			}
+			fmt.Fprintf(os.Stderr, "[apply] Skipping %s: cannot write %s (%s)\n", info.RelPath, info.HomePath, reason)
+			continue
+		}
+		out = append(out, info)
+	}
+	return out
+}
+
+// filterDiffs keeps the entries of files whose path is in paths.
+func filterDiffs(files []types.FileDiff, paths []string) []types.FileDiff {
+	keep := make(map[string]struct{}, len(paths))
+	for _, p := range paths {
+		keep[p] = struct{}{}
+	}
+	var out []types.FileDiff
+	for _, info := range files {
+		if _, ok := keep[info.RelPath]; ok {
+			out = append(out, info)
+		}
+	}
+	return out
+}
+
+// saveState persists the sync records, reporting rather than failing on error
+// since the files themselves have already been written.
+func saveState(state *services.StateService, prefix string) {
+	if err := state.Save(); err != nil {
+		fmt.Fprintf(os.Stderr, "[%s] Failed to save sync state: %v\n", prefix, err)
+	}
+}
+
+// recordInSync records the files a scan found identical on both sides as the
+// sync base; only commands that write call it, so scans stay read-only.
+func recordInSync(state *services.StateService, inSync []types.FileDiff, prefix string) {
+	if err := state.RecordInSync(inSync); err != nil {
+		fmt.Fprintf(os.Stderr, "[%s] Failed to record sync state: %v\n", prefix, err)
+	}
+}
+
+// persistContent saves what the content filters picked up while files were
+// converted, such as secret values, once a command has written them.
+func persistContent(content *services.ContentService, prefix string) {
+	if err := content.Persist(); err != nil {
+		fmt.Fprintf(os.Stderr, "[%s] Failed to save the secret store: %v\n", prefix, err)
+	}
+}
+
+// finishBackup reports where overwritten files were saved and applies the
+// configured retention policy to older backup sets.
+func finishBackup(dotman *services.DotmanService, backups *services.BackupService, backup *services.BackupSet, prefix string) {
+	if backup.Empty() {
+		return
+	}
+	fmt.Printf("[%s] Backed up %d file(s) to %s (restore with 'dotman restore %s').\n", prefix, len(backup.Manifest.Files), backup.Dir, backup.ID)
+	pruneBackups(dotman, backups, prefix)
+}
+
+// pruneBackups removes backup sets that fall outside backup.keep / backup.max_age.
+func pruneBackups(dotman *services.DotmanService, backups *services.BackupService, prefix string) {
+	keep := services.DefaultBackupKeep
+	if val, err := dotman.Config.Get("backup.keep"); err == nil {
+		keep = val.(int)
+	}
+	var maxAge time.Duration
+	if val, err := dotman.Config.Get("backup.max_age"); err == nil {
+		maxAge, _ = services.ParseAge(val.(string))
+	}
+	removed, err := backups.Prune(keep, maxAge)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[%s] Failed to prune old backups: %v\n", prefix, err)
+		return
+	}
+	if len(removed) > 0 {
+		fmt.Printf("[%s] Pruned %d old backup set(s).\n", prefix, len(removed))
+	}
+}
@@ -0,0 +1,95 @@
+package commands
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"dotman/services"
+	"dotman/services/gittest"
+)
+
+func TestRunApply(t *testing.T) {
+	tests := []struct {
+		name    string
+		setup   func(*gittest.Fake)
+		noPull  bool
+		wantErr bool
+		// applied is set when the repo's .zshrc should reach $HOME.
+		applied bool
+		calls   map[string]int
+		// rebasing is set when the rebase should be left in progress.
+		rebasing bool
+	}{
+		{
+			name:    "pulled",
+			applied: true,
+			calls:   map[string]int{"PullRebase": 1},
+		},
+		{
+			name:    "no_pull",
+			noPull:  true,
+			applied: true,
+			calls:   map[string]int{"PullRebase": 0},
+		},
+		{
+			name:    "no_upstream",
+			setup:   func(g *gittest.Fake) { g.Fail("PullRebase", services.ErrNoUpstream) },
+			applied: true,
+		},
+		{
+			name:    "pull_auth_failed",
+			setup:   func(g *gittest.Fake) { g.Fail("PullRebase", services.ErrAuthFailed) },
+			wantErr: true,
+		},
+		{
+			name:    "rebase_conflict_aborted",
+			setup:   func(g *gittest.Fake) { g.Fail("PullRebase", services.ErrRebaseConflict) },
+			wantErr: true,
+			calls:   map[string]int{"RebaseAbort": 1},
+		},
+		{
+			name: "rebase_abort_failed",
+			setup: func(g *gittest.Fake) {
+				g.Fail("PullRebase", services.ErrRebaseConflict)
+				g.Fail("RebaseAbort", errors.New("could not abort"))
+			},
+			wantErr:  true,
+			calls:    map[string]int{"RebaseAbort": 1},
+			rebasing: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t, nil)
+			if err := os.WriteFile(filepath.Join(env.repo, "home", ".zshrc"), []byte("export EDITOR=vim\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if tt.setup != nil {
+				tt.setup(env.git)
+			}
+			// --yes, so nothing is asked.
+			err := runApply(env.command(""), env.dotman, env.git, env.fs, env.state, env.backups, false, tt.noPull, false, false, true, nil)
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("runApply() = %v, want error %v; calls %q", err, tt.wantErr, env.git.Calls)
+			}
+			if err != nil && !errors.Is(err, errReported) {
+				t.Errorf("runApply() = %v, want errReported", err)
+			}
+			_, statErr := os.Stat(filepath.Join(env.home, ".zshrc"))
+			if applied := statErr == nil; applied != tt.applied {
+				t.Errorf(".zshrc applied = %v, want %v", applied, tt.applied)
+			}
+			for method, n := range tt.calls {
+				if got := env.git.Called(method); got != n {
+					t.Errorf("%s called %d times, want %d; calls %q", method, got, n, env.git.Calls)
+				}
+			}
+			if env.git.Rebasing != tt.rebasing {
+				t.Errorf("Rebasing = %v, want %v", env.git.Rebasing, tt.rebasing)
+			}
+		})
+	}
+}
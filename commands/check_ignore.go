@@ -0,0 +1,61 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"dotman/services"
+
+	"github.com/spf13/cobra"
+)
+
+func NewCheckIgnoreCommand(dotman *services.DotmanService, fs *services.FileService) *cobra.Command {
+	return &cobra.Command{
+		Use:   "check-ignore <path>...",
+		Short: "Explain which ignore rule matches a path",
+		Long: `Report, for each path, the ignore rule that excludes it and where that rule
+is defined (built-in, the repo's .dotmanignore or the "ignore" setting in
+your user config). Paths are taken relative to $HOME.
+
+Exits 0 if at least one path is ignored and 1 otherwise.`,
+		Args: cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			ignore, err := dotman.IgnoreMatcher()
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			homeDir := fs.HomeDir()
+			anyIgnored := false
+			for _, arg := range args {
+				path := fs.ExpandHome(arg)
+				if !fs.IsAbs(path) {
+					path = fs.Join(homeDir, path)
+				}
+				rel, err := fs.Rel(homeDir, path)
+				if err != nil || strings.HasPrefix(rel, "..") {
+					fmt.Fprintf(os.Stderr, "[ERROR] Path is not inside $HOME: %s\n", arg)
+					continue
+				}
+				isDir := strings.HasSuffix(arg, "/")
+				if info, err := fs.Stat(path); err == nil {
+					isDir = info.IsDir()
+				}
+				rule := ignore.Match(rel, isDir)
+				if rule == nil {
+					continue
+				}
+				if rule.Negate {
+					fmt.Printf("%s\t%s (not ignored)\n", rule, rel)
+					continue
+				}
+				anyIgnored = true
+				fmt.Printf("%s\t%s\n", rule, rel)
+			}
+			if !anyIgnored {
+				os.Exit(1)
+			}
+		},
+	}
+}
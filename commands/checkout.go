@@ -0,0 +1,158 @@
+package commands
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"dotman/diffview"
+	"dotman/services"
+	"dotman/types"
+
+	"github.com/spf13/cobra"
+)
+
+func NewCheckoutCommand(dotman *services.DotmanService, git services.Git, fs *services.FileService, backups *services.BackupService) *cobra.Command {
+	var dryRun bool
+	var yes bool
+	var showSecrets bool
+	cmd := &cobra.Command{
+		Use:   "checkout <commit> <path>",
+		Short: "Restore a tracked file in $HOME from an older commit",
+		Long: `Write the version of a tracked file from an older commit of the dotfiles
+repo into $HOME, after showing how it differs from your current copy. The
+current copy is backed up first and can be brought back with 'dotman restore'.
+
+Only your home copy changes: the repo and its branch stay where they are, so
+the old version shows up as a local change. Only 'dotman submit' or
+'dotman sync' act on it, by committing it to the repo; 'dotman apply' leaves
+it alone. Find commits with 'dotman log <path>'.
+
+Examples:
+  dotman checkout HEAD~3 ~/.zshrc
+  dotman checkout 1a2b3c4 .config/nvim/init.lua`,
+		Args: cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			repoDir, err := dotman.IsInitialized()
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			manifest, err := dotman.Manifest()
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			layers, err := dotman.HomeLayers()
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			content, err := dotman.Content()
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			userHome := fs.HomeDir()
+			rel, ok := argRel(fs, manifest, userHome, args[1])
+			if !ok {
+				fmt.Fprintf(os.Stderr, "[checkout] Path is not inside $HOME or a configured root: %s\n", args[1])
+				os.Exit(1)
+			}
+			rev, err := git.ResolveRev(repoDir, args[0])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "[checkout] %v\n", err)
+				os.Exit(1)
+			}
+
+			var stored []byte
+			found := false
+			for _, p := range repoCandidates(manifest, layers, repoDir, rel) {
+				if stored, found, err = git.ShowFile(repoDir, rev, p); err != nil {
+					reportGitError("checkout", "Reading "+p, err)
+					os.Exit(1)
+				}
+				if found {
+					break
+				}
+			}
+			if !found {
+				fmt.Fprintf(os.Stderr, "[checkout] %s does not exist in %s.\n", rel, args[0])
+				os.Exit(1)
+			}
+			data, _, err := content.HomeData(rel, stored)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "[checkout] Failed to convert %s: %v\n", rel, err)
+				os.Exit(1)
+			}
+
+			info := types.FileDiff{
+				RelPath:   rel,
+				HomePath:  manifest.HomePath(userHome, rel),
+				Sensitive: content.SensitiveData(rel, stored),
+			}
+			if e := manifest.Entry(rel); e != nil {
+				info.Mode = e.FileMode()
+			}
+			mode := homeMode(info, 0644)
+			if current, err := os.ReadFile(info.HomePath); err == nil {
+				if bytes.Equal(current, data) {
+					fmt.Printf("[checkout] %s already matches %s.\n", info.HomePath, args[0])
+					return
+				}
+				if stat, err := os.Stat(info.HomePath); err == nil {
+					mode = homeMode(info, stat.Mode().Perm())
+				}
+			}
+
+			renderer := diffview.NewRenderer()
+			renderer.ShowSensitive = showSecrets
+			renderer.Theme.LeftTitle = args[0]
+			renderer.Theme.RightTitle = "home"
+			panels, err := renderer.RenderFiles([]diffview.FilePair{{Label: rel, LeftContent: data, RightPath: info.HomePath, Sensitive: info.Sensitive}}, true)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "[checkout] Failed to display diff viewer for %s: %v\n", rel, err)
+			}
+			for _, p := range panels {
+				fmt.Println(p)
+			}
+
+			if dryRun {
+				fmt.Printf("[checkout] Dry run: would write %s from %s.\n", info.HomePath, args[0])
+				return
+			}
+			if err := fs.CheckWritable(info.HomePath); err != nil {
+				fmt.Fprintf(os.Stderr, "[checkout] Cannot write %s: %v\n", info.HomePath, err)
+				os.Exit(1)
+			}
+			if !yes && !confirm(bufio.NewScanner(os.Stdin), fmt.Sprintf("Replace %s with the version from %s? [y/N]: ", info.HomePath, args[0])) {
+				fmt.Println("[checkout] Cancelled.")
+				return
+			}
+
+			backup := backups.Begin("checkout")
+			defer finishBackup(dotman, backups, backup, "checkout")
+			if _, err := os.Stat(info.HomePath); err == nil {
+				if err := backup.Save(userHome, homeRel(userHome, info), "overwrite"); err != nil {
+					fmt.Fprintf(os.Stderr, "[checkout] Failed to back up %s, leaving it untouched: %v\n", info.HomePath, err)
+					return
+				}
+			}
+			if err := fs.MkdirAll(filepath.Dir(info.HomePath), 0755); err != nil {
+				fmt.Fprintf(os.Stderr, "[checkout] Failed to create directory for %s: %v\n", info.HomePath, err)
+				return
+			}
+			if err := fs.WriteFile(info.HomePath, data, mode); err != nil {
+				fmt.Fprintf(os.Stderr, "[checkout] Failed to write %s: %v\n", info.HomePath, err)
+				return
+			}
+			fmt.Printf("[checkout] Wrote %s from %s.\n", info.HomePath, args[0])
+		},
+	}
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Preview the change without writing it")
+	cmd.Flags().BoolVarP(&yes, "yes", "y", false, "Do not ask before replacing the home copy")
+	cmd.Flags().BoolVar(&showSecrets, "show-secrets", false, "Show the decrypted contents of encrypted files")
+	return cmd
+}
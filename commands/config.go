@@ -12,6 +12,7 @@ func NewConfigCommand(cfg *services.ConfigService) *cobra.Command {
 		Use:   "config",
 		Short: "Get or set dotman configuration",
 		Long:  `Get or set values in ~/.dotman.json using dot notation.`,
+		Annotations: map[string]string{AllowDuringRebase: ""},
 	}
 
 	getCmd := &cobra.Command{
@@ -0,0 +1,173 @@
+package commands
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"dotman/services"
+	"dotman/types"
+)
+
+// resolveConflicts walks every file that changed on both sides and asks how to
+// resolve it. Every resolution records the repo version as the new sync base,
+// so a home copy that still differs afterwards is picked up by submit; a merge
+// that leaves conflict markers records nothing and the file stays changed on
+// both sides. Home copies are saved to backup before they are overwritten.
+func resolveConflicts(scan *bufio.Scanner, prefix string, git services.Git, fs *services.FileService, state *services.StateService, content *services.ContentService, backup *services.BackupSet, conflicts []types.FileDiff, userHome string, showSecrets bool) {
+	for _, info := range conflicts {
+		repoPath := info.RepoPath
+		userPath := info.HomePath
+		repoData, _, err := content.HomeContent(info.RelPath, repoPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[%s] Failed to read %s: %v\n", prefix, repoPath, err)
+			continue
+		}
+		fmt.Printf("\n[%s] %s changed in both the repo and your home directory.\n", prefix, info.RelPath)
+	prompt:
+		for {
+			fmt.Print("Resolve: [h] keep home, [r] take repo, [m] merge, [e] merge and edit, [d] diff, [s] skip: ")
+			if !scan.Scan() {
+				fmt.Printf("[%s] Aborted.\n", prefix)
+				return
+			}
+			resp := strings.ToLower(strings.TrimSpace(scan.Text()))
+			switch resp {
+			case "h", "home":
+				if prefix == "sync" {
+					// sync commits the kept home copy itself.
+					fmt.Printf("[%s] Kept home version of %s.\n", prefix, info.RelPath)
+				} else {
+					fmt.Printf("[%s] Kept home version of %s (submit it with 'dotman submit').\n", prefix, info.RelPath)
+				}
+			case "r", "repo":
+				stat, err := os.Stat(repoPath)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "[%s] Failed to stat input file %s: %v\n", prefix, repoPath, err)
+					continue
+				}
+				if err := backup.Save(userHome, homeRel(userHome, info), "overwrite"); err != nil {
+					fmt.Fprintf(os.Stderr, "[%s] Failed to back up %s: %v\n", prefix, info.RelPath, err)
+					continue
+				}
+				if err := fs.WriteFile(userPath, repoData, homeMode(info, stat.Mode())); err != nil {
+					fmt.Fprintf(os.Stderr, "[%s] Failed to update %s: %v\n", prefix, info.RelPath, err)
+					continue
+				}
+				fmt.Printf("[%s] Took repo version of %s.\n", prefix, info.RelPath)
+			case "m", "merge", "e", "edit":
+				edit := resp == "e" || resp == "edit"
+				if err := backup.Save(userHome, homeRel(userHome, info), "overwrite"); err != nil {
+					fmt.Fprintf(os.Stderr, "[%s] Failed to back up %s: %v\n", prefix, info.RelPath, err)
+					continue
+				}
+				resolved, err := mergeIntoHome(prefix, git, fs, state, info.RelPath, repoData, userPath, edit)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "[%s] Failed to merge %s: %v\n", prefix, info.RelPath, err)
+					continue
+				}
+				if !resolved {
+					// Without a new base the file stays changed on both
+					// sides, so neither submit nor sync commits the markers.
+					fmt.Printf("[%s] %s still has conflict markers; fix them and run 'dotman %s' again.\n", prefix, info.RelPath, prefix)
+					break prompt
+				}
+			case "d", "diff":
+				showDifferences(content, []types.FileDiff{info}, userHome, showSecrets)
+				continue
+			case "s", "skip", "":
+				fmt.Printf("[%s] Skipped %s.\n", prefix, info.RelPath)
+				break prompt
+			default:
+				fmt.Printf("[%s] Please enter 'h', 'r', 'm', 'e', 'd' or 's'.\n", prefix)
+				continue
+			}
+			if err := state.RecordContent(info.RelPath, repoData); err != nil {
+				fmt.Fprintf(os.Stderr, "[%s] Failed to record sync state for %s: %v\n", prefix, info.RelPath, err)
+			}
+			break
+		}
+	}
+}
+
+// mergeIntoHome three-way merges the home and repo copies of rel against the
+// last synced base and writes the result, conflict markers included, over the
+// home copy. Without a recorded base the merge falls back to an empty one, so
+// the whole file ends up as a single conflict. It reports whether the home
+// copy is free of conflict markers afterwards, including after editing.
+func mergeIntoHome(prefix string, git services.Git, fs *services.FileService, state *services.StateService, rel string, repoData []byte, userPath string, edit bool) (bool, error) {
+	base, ok := state.BasePath(rel)
+	if !ok {
+		empty, err := os.CreateTemp("", "dotman-base-*")
+		if err != nil {
+			return false, err
+		}
+		empty.Close()
+		defer os.Remove(empty.Name())
+		base = empty.Name()
+		fmt.Printf("[%s] No sync base recorded for %s; merging against an empty file.\n", prefix, rel)
+	}
+
+	// The repo side goes through a temp file since it may be a rendered
+	// template rather than the file in the repo.
+	repoFile, err := os.CreateTemp("", "dotman-repo-*")
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(repoFile.Name())
+	_, err = repoFile.Write(repoData)
+	if closeErr := repoFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return false, err
+	}
+
+	merged, conflicts, err := git.MergeFile(userPath, base, repoFile.Name(), [3]string{"home", "base", "repo"})
+	if err != nil {
+		return false, err
+	}
+	stat, err := os.Stat(userPath)
+	if err != nil {
+		return false, err
+	}
+	if err := fs.WriteFile(userPath, merged, stat.Mode()); err != nil {
+		return false, err
+	}
+	if conflicts > 0 {
+		fmt.Printf("[%s] Merged %s with %d conflict(s) marked in %s.\n", prefix, rel, conflicts, userPath)
+	} else {
+		fmt.Printf("[%s] Merged %s cleanly.\n", prefix, rel)
+	}
+	if !edit {
+		return conflicts == 0, nil
+	}
+	if err := openEditor(userPath); err != nil {
+		return false, err
+	}
+	edited, err := os.ReadFile(userPath)
+	if err != nil {
+		return false, err
+	}
+	return !hasConflictMarkers(edited), nil
+}
+
+// openEditor opens path in $VISUAL or $EDITOR (falling back to vi) and waits
+// for it to exit.
+func openEditor(path string) error {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+	args := append(strings.Fields(editor), path)
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
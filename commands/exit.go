@@ -0,0 +1,17 @@
+package commands
+
+import (
+	"errors"
+	"os"
+)
+
+// errReported is returned by a command's run function once it has printed why
+// it failed, so the command only has to exit with status 1.
+var errReported = errors.New("command failed")
+
+// exitOnError exits with status 1 when a run function failed.
+func exitOnError(err error) {
+	if err != nil {
+		os.Exit(1)
+	}
+}
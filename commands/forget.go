@@ -0,0 +1,185 @@
+package commands
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"dotman/services"
+	"dotman/types"
+
+	"github.com/spf13/cobra"
+)
+
+func NewForgetCommand(dotman *services.DotmanService, git services.Git, fs *services.FileService, state *services.StateService, backups *services.BackupService) *cobra.Command {
+	var deleteHome bool
+	var yes bool
+	var dryRun bool
+	var verbose bool
+
+	cmd := &cobra.Command{
+		Use:     "forget <path>...",
+		Aliases: []string{"rm"},
+		Short:   "Stop tracking files or directories",
+		Long: `Remove files or whole directories from the repo and commit the removal.
+
+The copies in $HOME are left in place unless --delete is given, in which case
+they are backed up and removed after confirmation. Relative paths are resolved
+against $HOME; files applied outside it, such as /etc/hosts from root/, can be
+given by their full path.
+
+Examples:
+  dotman forget ~/.bash_profile
+  dotman rm --delete ~/.config/old-tool`,
+		Args: cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			repoDir, err := dotman.IsInitialized()
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			if err := dotman.RequireWritable("forget"); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			manifest, err := dotman.Manifest()
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			if err := state.Load(); err != nil {
+				fmt.Fprintf(os.Stderr, "[forget] Failed to load sync state: %v\n", err)
+				os.Exit(1)
+			}
+			homeDir := fs.HomeDir()
+			repoHome := filepath.Join(repoDir, "home")
+
+			var rels []string
+			for _, arg := range args {
+				path := fs.ExpandHome(arg)
+				if !fs.IsAbs(path) {
+					path = fs.Join(homeDir, path)
+				}
+				rel, ok := trackedRel(manifest, homeDir, path)
+				if !ok {
+					fmt.Fprintf(os.Stderr, "[forget] Path is not inside $HOME or a configured root: %s\n", arg)
+					os.Exit(1)
+				}
+				if err := fs.Exists(manifest.RepoPath(repoHome, rel)); err != nil {
+					fmt.Fprintf(os.Stderr, "[forget] Not tracked: %s\n", rel)
+					os.Exit(1)
+				}
+				rels = append(rels, rel)
+			}
+
+			if dryRun {
+				fmt.Println("[forget] Dry run: would stop tracking the following paths:")
+				for _, rel := range rels {
+					fmt.Printf("  - %s\n", rel)
+				}
+				if deleteHome {
+					fmt.Println("[forget] Dry run: would back up and delete the home copies.")
+				}
+				return
+			}
+
+			git.SetVerbose(verbose)
+			stagePaths := make([]string, 0, len(rels))
+			for _, rel := range rels {
+				stagePaths = append(stagePaths, mustRepoRel(repoDir, manifest.RepoPath(repoHome, rel)))
+			}
+			if err := git.Remove(repoDir, stagePaths); err != nil {
+				reportGitError("forget", "Removing files", err)
+				os.Exit(1)
+			}
+			// Targets are looked up before the entries are removed.
+			targets := make(map[string]string, len(rels))
+			for _, rel := range rels {
+				targets[rel] = manifest.HomePath(homeDir, rel)
+			}
+			manifestChanged := false
+			for _, rel := range rels {
+				// Files added but never committed are not removed by git rm.
+				if err := os.RemoveAll(manifest.RepoPath(repoHome, rel)); err != nil {
+					fmt.Fprintf(os.Stderr, "[forget] Failed to remove %s from repo: %v\n", rel, err)
+					os.Exit(1)
+				}
+				if manifest.UntrackDirectory(rel) {
+					manifestChanged = true
+				}
+				if manifest.RemoveEntries(rel) {
+					manifestChanged = true
+				}
+				state.ForgetTree(rel)
+			}
+			if manifestChanged {
+				if err := manifest.Save(); err != nil {
+					fmt.Fprintf(os.Stderr, "[forget] Failed to update %s: %v\n", manifest.Path(), err)
+					os.Exit(1)
+				}
+				if err := git.Add(repoDir, []string{services.ManifestFileName}); err != nil {
+					reportGitError("forget", "Staging "+services.ManifestFileName, err)
+					os.Exit(1)
+				}
+			}
+			// Paths that were added but never committed leave nothing to commit.
+			if err := git.Commit(repoDir, forgetCommitMessage(rels)); err != nil && !errors.Is(err, services.ErrNothingToCommit) {
+				reportGitError("forget", "Commit", err)
+				os.Exit(1)
+			}
+			saveState(state, "forget")
+			fmt.Printf("[forget] Stopped tracking %d path(s).\n", len(rels))
+
+			if !deleteHome {
+				return
+			}
+			scan := bufio.NewScanner(os.Stdin)
+			backup := backups.Begin("forget")
+			defer finishBackup(dotman, backups, backup, "forget")
+			for _, rel := range rels {
+				userPath := targets[rel]
+				if err := fs.Exists(userPath); err != nil {
+					continue
+				}
+				if !yes && !confirm(scan, fmt.Sprintf("Delete %s from your home directory? [y/N]: ", userPath)) {
+					fmt.Printf("[forget] Kept %s\n", userPath)
+					continue
+				}
+				if err := backup.Save(homeDir, homeRel(homeDir, types.FileDiff{RelPath: rel, HomePath: userPath}), "delete"); err != nil {
+					fmt.Fprintf(os.Stderr, "[forget] Failed to back up %s, not deleting it: %v\n", userPath, err)
+					continue
+				}
+				if err := os.RemoveAll(userPath); err != nil {
+					fmt.Fprintf(os.Stderr, "[forget] Failed to delete %s: %v\n", userPath, err)
+					continue
+				}
+				fmt.Printf("[forget] Deleted %s\n", userPath)
+			}
+		},
+	}
+	cmd.Flags().BoolVar(&deleteHome, "delete", false, "Also delete the copies in $HOME (after a backup)")
+	cmd.Flags().BoolVarP(&yes, "yes", "y", false, "Do not ask before deleting home copies")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Preview what would be removed")
+	cmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "Show verbose git output")
+	return cmd
+}
+
+func forgetCommitMessage(rels []string) string {
+	if len(rels) <= 3 {
+		return "Stop tracking " + strings.Join(rels, ", ")
+	}
+	return fmt.Sprintf("Stop tracking %s and %d more", strings.Join(rels[:2], ", "), len(rels)-2)
+}
+
+// confirm asks a yes/no question, defaulting to no.
+func confirm(scan *bufio.Scanner, question string) bool {
+	fmt.Print(question)
+	if !scan.Scan() {
+		return false
+	}
+	resp := strings.ToLower(strings.TrimSpace(scan.Text()))
+	return resp == "y" || resp == "yes"
+}
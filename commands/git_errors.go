@@ -0,0 +1,37 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"dotman/services"
+)
+
+// reportGitError prints a failed git step for command, followed by a hint on
+// what to do when the failure is one dotman recognises.
+func reportGitError(command, step string, err error) {
+	fmt.Fprintf(os.Stderr, "[%s] %s failed: %v\n", command, step, err)
+	if hint := gitHint(err); hint != "" {
+		fmt.Fprintf(os.Stderr, "[%s] %s\n", command, hint)
+	}
+}
+
+// gitHint suggests a way out of a recognised git failure, or returns "".
+func gitHint(err error) string {
+	switch {
+	case errors.Is(err, services.ErrNotARepo):
+		return "The dotfile path is not a git repository. Point dotman at your clone with 'dotman init'."
+	case errors.Is(err, services.ErrNoUpstream):
+		return "The current branch has no upstream. Set one in the repo with 'git push -u origin <branch>'."
+	case errors.Is(err, services.ErrAuthFailed):
+		return "Git could not authenticate with the remote. Check your credentials or SSH key."
+	case errors.Is(err, services.ErrPushRejected):
+		return "The remote has commits this machine does not have yet. Run 'dotman publish' to pull them before pushing."
+	case errors.Is(err, services.ErrRebaseConflict):
+		return "The pull stopped on conflicting commits. Run 'dotman resolve' to finish it, or 'dotman resolve --abort' to undo it."
+	case errors.Is(err, services.ErrReadOnly):
+		return "This machine uses the dotfiles repo read-only. Run 'dotman config set read_only false' to allow changes."
+	}
+	return ""
+}
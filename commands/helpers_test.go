@@ -0,0 +1,77 @@
+package commands
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"dotman/services"
+	"dotman/services/gittest"
+
+	"github.com/spf13/cobra"
+)
+
+// testEnv is a dotman setup in temporary directories: a repo with a home/
+// tree, a $HOME, and config and state kept apart from the real ones. Git is
+// a gittest.Fake, so nothing is run.
+type testEnv struct {
+	repo    string
+	home    string
+	dotman  *services.DotmanService
+	git     *gittest.Fake
+	fs      *services.FileService
+	state   *services.StateService
+	backups *services.BackupService
+}
+
+func newTestEnv(t *testing.T, config map[string]string) *testEnv {
+	t.Helper()
+	dir := t.TempDir()
+	env := &testEnv{repo: filepath.Join(dir, "repo"), home: filepath.Join(dir, "home")}
+	for _, d := range []string{filepath.Join(env.repo, "home"), env.home} {
+		if err := os.MkdirAll(d, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("HOME", env.home)
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(dir, "config"))
+	t.Setenv("XDG_STATE_HOME", filepath.Join(dir, "state"))
+
+	cfg := services.NewConfigServiceAt(filepath.Join(dir, "dotman.json"))
+	if err := cfg.Set("dotfile.path", env.repo); err != nil {
+		t.Fatal(err)
+	}
+	for key, value := range config {
+		if err := cfg.Set(key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := cfg.Save(); err != nil {
+		t.Fatal(err)
+	}
+	env.dotman = &services.DotmanService{Config: cfg}
+	env.git = gittest.NewFake()
+	env.fs = services.NewFileService()
+	env.state = services.NewStateService()
+	env.backups = services.NewBackupService()
+	return env
+}
+
+// command returns a command to run with, reading input as its stdin.
+func (env *testEnv) command(input string) *cobra.Command {
+	cmd := &cobra.Command{}
+	cmd.SetIn(strings.NewReader(input))
+	return cmd
+}
+
+// writeTestFile writes content to path, creating its directory.
+func writeTestFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -4,21 +4,30 @@ import (
 	"dotman/services"
 	"fmt"
 	"os"
+	"strconv"
 	"github.com/spf13/cobra"
 )
 
-func NewInitCommand(dotman *services.DotmanService, git *services.GitService, cfg *services.ConfigService) *cobra.Command {
+func NewInitCommand(dotman *services.DotmanService, git services.Git, cfg *services.ConfigService) *cobra.Command {
 	fs := services.NewFileService()
+	var readOnly bool
 	cmd := &cobra.Command{
 		Use:   "init [repourl] <folderpath>",
 		Short: "Initialize dotman repository",
 		Long: ` 
 Initialize dotman in a folder, optionally cloning a repo.
 
+With --read-only the machine only consumes the repo, as on CI runners, shared
+workstations or devcontainers: the clone is shallow, 'apply' works as usual,
+and 'add', 'submit', 'forget' and 'publish' are refused so nothing is ever
+committed or pushed. Change it later with 'dotman config set read_only'.
+
 Examples:
   dotman init ~/dotfiles
-  dotman init https://github.com/user/dotfiles.git ~/dotfiles`,
+  dotman init https://github.com/user/dotfiles.git ~/dotfiles
+  dotman init --read-only https://github.com/user/dotfiles.git ~/dotfiles`,
 		Args: cobra.RangeArgs(1, 2),
+		Annotations: map[string]string{AllowDuringRebase: ""},
 		Run: func(cmd *cobra.Command, args []string) {
 			_ = cfg.Load()
 			if len(args) == 1 {
@@ -31,14 +40,19 @@ Examples:
 					absPath = abs
 				}
 				cfg.Set("dotfile.path", absPath)
+				cfg.Set("read_only", strconv.FormatBool(readOnly))
 				_ = cfg.Save()
 				fmt.Println("Initialized dotman in existing repo.")
 			} else if len(args) == 2 {
 				// dotman init <repourl> <folderpath>
 				fmt.Printf("Cloning %s into %s...\n", args[0], args[1])
 				target := fs.ExpandHome(args[1])
-				if err := git.CloneRepo(args[0], target, false, false); err != nil {
+				// A read-only machine never needs history beyond the latest commit.
+				if err := git.CloneRepo(args[0], target, readOnly, false); err != nil {
 					fmt.Fprintf(os.Stderr, "Git clone failed: %v\n", err)
+					if hint := gitHint(err); hint != "" {
+						fmt.Fprintln(os.Stderr, hint)
+					}
 					os.Exit(1)
 				}
 				dotman := services.NewDotmanService()
@@ -48,13 +62,18 @@ Examples:
 					absPath = abs
 				}
 				cfg.Set("dotfile.path", absPath)
+				cfg.Set("read_only", strconv.FormatBool(readOnly))
 				_ = cfg.Save()
 				fmt.Println("Initialized dotman in cloned repo.")
 				// TODO: initialize config/repo in args[1]
 			} else {
 				fmt.Println("Usage: dotman init [repourl] <folderpath>")
 			}
+			if readOnly {
+				fmt.Println("Read-only mode: dotman will apply from this repo but never commit or push.")
+			}
 		},
 	}
+	cmd.Flags().BoolVar(&readOnly, "read-only", false, "Consume the repo without ever committing or pushing (shallow clone)")
 	return cmd
 }
@@ -0,0 +1,128 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+
+	"dotman/diffview"
+	"dotman/services"
+
+	"github.com/spf13/cobra"
+)
+
+func NewLogCommand(dotman *services.DotmanService, git services.Git, fs *services.FileService) *cobra.Command {
+	var oneline bool
+	var limit int
+	var showSecrets bool
+	cmd := &cobra.Command{
+		Use:   "log <path>",
+		Short: "Show the history of a tracked file",
+		Long: `List the commits that changed a tracked file, newest first, each followed
+by a side-by-side view of what it changed. Host and profile overlays of the
+file are included. The path is resolved like for 'dotman add'.
+
+Files are shown as they would be applied to $HOME; encrypted files stay hidden
+unless --show-secrets is given. Bring back an older version with
+'dotman checkout <commit> <path>'.
+
+Examples:
+  dotman log ~/.tmux.conf
+  dotman log --oneline -n 5 .zshrc`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			repoDir, err := dotman.IsInitialized()
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			manifest, err := dotman.Manifest()
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			layers, err := dotman.HomeLayers()
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			content, err := dotman.Content()
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			rel, ok := argRel(fs, manifest, fs.HomeDir(), args[0])
+			if !ok {
+				fmt.Fprintf(os.Stderr, "[log] Path is not inside $HOME or a configured root: %s\n", args[0])
+				os.Exit(1)
+			}
+
+			commits, err := git.Log(repoDir, repoCandidates(manifest, layers, repoDir, rel), limit)
+			if err != nil {
+				reportGitError("log", "Reading history", err)
+				os.Exit(1)
+			}
+			if len(commits) == 0 {
+				fmt.Printf("[log] No commits touch %s.\n", rel)
+				return
+			}
+
+			renderer := diffview.NewRenderer()
+			renderer.ShowSensitive = showSecrets
+			for _, c := range commits {
+				fmt.Printf("%s%s%s %s %s  %s\n", colorYellow, c.Short, colorReset, c.Date.Format("2006-01-02"), c.Author, c.Subject)
+				if oneline {
+					continue
+				}
+				for _, f := range c.Files {
+					pair, err := historyPair(git, content, repoDir, rel, c, f)
+					if err != nil {
+						fmt.Fprintf(os.Stderr, "[log] Failed to read %s at %s: %v\n", f, c.Short, err)
+						continue
+					}
+					renderer.Theme.LeftTitle = c.Short + "^"
+					renderer.Theme.RightTitle = c.Short
+					panels, err := renderer.RenderFiles([]diffview.FilePair{pair}, true)
+					if err != nil {
+						fmt.Fprintf(os.Stderr, "[log] Failed to display diff viewer for %s: %v\n", f, err)
+						continue
+					}
+					for _, p := range panels {
+						fmt.Println(p)
+					}
+				}
+				fmt.Println()
+			}
+		},
+	}
+	cmd.Flags().BoolVar(&oneline, "oneline", false, "Only list the commits, without their changes")
+	cmd.Flags().IntVarP(&limit, "max-count", "n", 0, "Show at most this many commits")
+	cmd.Flags().BoolVar(&showSecrets, "show-secrets", false, "Show the decrypted contents of encrypted files")
+	return cmd
+}
+
+// historyPair builds the diff viewer pair for the change commit c made to the
+// repo path f (a copy of rel), both sides in their home form. A side where the
+// file did not exist is left empty, so it is shown as added or removed.
+func historyPair(git services.Git, content *services.ContentService, repoDir, rel string, c services.Commit, f string) (diffview.FilePair, error) {
+	pair := diffview.FilePair{Label: f}
+	for i, rev := range []string{c.Hash + "^", c.Hash} {
+		data, ok, err := git.ShowFile(repoDir, rev, f)
+		if err != nil {
+			return pair, err
+		}
+		if !ok {
+			continue
+		}
+		pair.Sensitive = pair.Sensitive || content.SensitiveData(rel, data)
+		home, _, err := content.HomeData(rel, data)
+		if err != nil {
+			return pair, err
+		}
+		if i == 0 {
+			pair.LeftContent = home
+		} else {
+			pair.RightContent = home
+		}
+	}
+	return pair, nil
+}
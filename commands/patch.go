@@ -0,0 +1,125 @@
+package commands
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"dotman/services"
+)
+
+const hunkHelp = `y - take this hunk
+n - do not take this hunk
+q - quit; do not take this hunk or any of the remaining ones
+a - take this hunk and all later hunks in the file
+d - do not take this hunk or any of the later hunks in the file
+? - print help`
+
+const hunkEditHelp = `
+e - edit the lines this hunk puts in place, then take it`
+
+// selectHunks walks hunks one at a time like `git add -p` and returns which
+// ones were accepted. quit is true when the user asked to stop altogether.
+// When edit is non-nil an extra "e" choice lets the user rewrite a hunk; the
+// result is stored in the hunk's Edited field.
+func selectHunks(scan *bufio.Scanner, hunks []services.Hunk, question string, edit func(services.Hunk) ([]string, error)) (accept []bool, quit bool) {
+	choices, help := "y,n,q,a,d,?", hunkHelp
+	if edit != nil {
+		choices, help = "y,n,q,a,d,e,?", hunkHelp+hunkEditHelp
+	}
+	accept = make([]bool, len(hunks))
+	for i := 0; i < len(hunks); i++ {
+		printHunk(hunks[i])
+		for {
+			fmt.Printf("(%d/%d) %s [%s]? ", i+1, len(hunks), question, choices)
+			if !scan.Scan() {
+				return accept, true
+			}
+			resp := strings.ToLower(strings.TrimSpace(scan.Text()))
+			switch {
+			case resp == "e" && edit != nil:
+				lines, err := edit(hunks[i])
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Failed to edit hunk: %v\n", err)
+					continue
+				}
+				hunks[i].Edited = lines
+				accept[i] = true
+			case resp == "y":
+				accept[i] = true
+			case resp == "n":
+			case resp == "q":
+				return accept, true
+			case resp == "a":
+				for j := i; j < len(hunks); j++ {
+					accept[j] = true
+				}
+				return accept, false
+			case resp == "d":
+				return accept, false
+			default:
+				fmt.Println(help)
+				continue
+			}
+			break
+		}
+	}
+	return accept, false
+}
+
+func printHunk(h services.Hunk) {
+	fmt.Printf("\033[36m%s\033[0m\n", h.Header())
+	for _, line := range h.Lines {
+		switch {
+		case strings.HasPrefix(line, "+"):
+			fmt.Printf("\033[32m%s\033[0m\n", line)
+		case strings.HasPrefix(line, "-"):
+			fmt.Printf("\033[31m%s\033[0m\n", line)
+		default:
+			fmt.Println(line)
+		}
+	}
+}
+
+// editHunkLines opens the lines a hunk would put in place (taken from b) in
+// the user's editor and returns them as saved.
+func editHunkLines(b []string) func(services.Hunk) ([]string, error) {
+	return func(h services.Hunk) ([]string, error) {
+		tmp, err := os.CreateTemp("", "dotman-hunk-*")
+		if err != nil {
+			return nil, err
+		}
+		defer os.Remove(tmp.Name())
+		_, err = tmp.WriteString(strings.Join(b[h.BStart:h.BStart+h.BLen], ""))
+		if closeErr := tmp.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := openEditor(tmp.Name()); err != nil {
+			return nil, err
+		}
+		edited, err := os.ReadFile(tmp.Name())
+		if err != nil {
+			return nil, err
+		}
+		// A non-nil result marks the hunk as edited, even when now empty.
+		lines := services.SplitLines(string(edited))
+		if lines == nil {
+			lines = []string{}
+		}
+		return lines, nil
+	}
+}
+
+func countAccepted(accept []bool) int {
+	n := 0
+	for _, ok := range accept {
+		if ok {
+			n++
+		}
+	}
+	return n
+}
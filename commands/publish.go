@@ -1,6 +1,8 @@
 package commands
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
 	"os"
 
@@ -9,7 +11,7 @@ import (
 	"github.com/spf13/cobra"
 )
 
-func NewPublishCommand(dotman *services.DotmanService, git *services.GitService) *cobra.Command {
+func NewPublishCommand(dotman *services.DotmanService, git services.Git) *cobra.Command {
 	var noPull bool
 	var dryRun bool
 	var verbose bool
@@ -18,46 +20,64 @@ func NewPublishCommand(dotman *services.DotmanService, git *services.GitService)
 		Use:   "publish",
 		Short: "Pull and push the dotman repository to sync with remote",
 		Run: func(cmd *cobra.Command, args []string) {
-			git.SetVerbose(verbose)
-			if _, err := dotman.IsInitialized(); err != nil {
-				fmt.Fprintln(os.Stderr, err)
-				os.Exit(1)
-			}
+			exitOnError(runPublish(cmd, dotman, git, noPull, dryRun, verbose))
+		},
+	}
+	cmd.Flags().BoolVar(&noPull, "no-pull", false, "Skip pull step and only push")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Preview publish without making changes")
+	cmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "Show verbose git output")
+	return cmd
+}
 
-			repoHomeDir, err := dotman.GetHomeDir()
-			if err != nil {
-				fmt.Fprintln(os.Stderr, err)
-				os.Exit(1)
-			}
+func runPublish(cmd *cobra.Command, dotman *services.DotmanService, git services.Git, noPull, dryRun, verbose bool) error {
+	git.SetVerbose(verbose)
+	repoDir, err := dotman.IsInitialized()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return errReported
+	}
+	if err := dotman.RequireWritable("publish"); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return errReported
+	}
 
-			if !noPull {
-				if dryRun {
-					fmt.Println("[publish] Dry run: would pull with rebase from remote.")
-				} else {
-					pullOut, err := git.PullRebase(repoHomeDir)
-					if err != nil {
-						fmt.Fprintf(os.Stderr, "[publish] Pull failed: %v\n%s", err, pullOut)
-						os.Exit(1)
-					}
-					fmt.Println("[publish] Pulled latest changes from remote.")
+	repoHomeDir, err := dotman.GetHomeDir()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return errReported
+	}
+
+	if !noPull {
+		if dryRun {
+			fmt.Println("[publish] Dry run: would pull with rebase from remote.")
+		} else {
+			_, err := git.PullRebase(repoHomeDir)
+			switch {
+			case errors.Is(err, services.ErrNoUpstream):
+				// Nothing to pull yet; the push below reports how to set one.
+				fmt.Println("[publish] The repo has no upstream branch to pull from.")
+			case errors.Is(err, services.ErrRebaseConflict):
+				if !resolveRebase(bufio.NewScanner(cmd.InOrStdin()), git, "publish", repoDir, false) {
+					return errReported
 				}
+			case err != nil:
+				reportGitError("publish", "Pull", err)
+				return errReported
+			default:
+				fmt.Println("[publish] Pulled latest changes from remote.")
 			}
+		}
+	}
 
-			if dryRun {
-				fmt.Println("[publish] Dry run: would push changes to remote.")
-				return
-			}
+	if dryRun {
+		fmt.Println("[publish] Dry run: would push changes to remote.")
+		return nil
+	}
 
-			pushOut, err := git.Push(repoHomeDir)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "[publish] Push failed: %v\n%s", err, pushOut)
-				os.Exit(1)
-			}
-			fmt.Println("[publish] Dotfiles updated on remote.")
-		},
+	if _, err := git.Push(repoHomeDir); err != nil {
+		reportGitError("publish", "Push", err)
+		return errReported
 	}
-	cmd.Flags().BoolVar(&noPull, "no-pull", false, "Skip pull step and only push")
-	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Preview publish without making changes")
-	cmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "Show verbose git output")
-	return cmd
+	fmt.Println("[publish] Dotfiles updated on remote.")
+	return nil
 }
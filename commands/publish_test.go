@@ -0,0 +1,109 @@
+package commands
+
+import (
+	"errors"
+	"testing"
+
+	"dotman/services"
+	"dotman/services/gittest"
+)
+
+func TestRunPublish(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  map[string]string
+		noPull  bool
+		setup   func(*gittest.Fake)
+		input   string
+		wantErr bool
+		// calls counts the git calls expected by method.
+		calls    map[string]int
+		rebasing bool
+	}{
+		{
+			name:  "pull_and_push",
+			calls: map[string]int{"PullRebase": 1, "Push": 1},
+		},
+		{
+			name:    "push_rejected",
+			noPull:  true,
+			setup:   func(g *gittest.Fake) { g.Fail("Push", services.ErrPushRejected) },
+			wantErr: true,
+			calls:   map[string]int{"PullRebase": 0, "Push": 1},
+		},
+		{
+			name:  "no_upstream_to_pull",
+			setup: func(g *gittest.Fake) { g.Fail("PullRebase", services.ErrNoUpstream) },
+			calls: map[string]int{"PullRebase": 1, "Push": 1},
+		},
+		{
+			name:    "pull_auth_failed",
+			setup:   func(g *gittest.Fake) { g.Fail("PullRebase", services.ErrAuthFailed) },
+			wantErr: true,
+			calls:   map[string]int{"PullRebase": 1, "Push": 0},
+		},
+		{
+			name: "rebase_conflict_aborted",
+			setup: func(g *gittest.Fake) {
+				g.Fail("PullRebase", services.ErrRebaseConflict)
+				g.Conflicts = []string{"home/.zshrc"}
+			},
+			// Quit the resolution, then agree to abort the rebase.
+			input:   "q\ny\n",
+			wantErr: true,
+			calls:   map[string]int{"RebaseAbort": 1, "Push": 0},
+		},
+		{
+			name: "rebase_conflict_left",
+			setup: func(g *gittest.Fake) {
+				g.Fail("PullRebase", services.ErrRebaseConflict)
+				g.Conflicts = []string{"home/.zshrc"}
+			},
+			input:    "",
+			wantErr:  true,
+			calls:    map[string]int{"RebaseAbort": 0, "Push": 0},
+			rebasing: true,
+		},
+		{
+			name: "rebase_conflict_resolved",
+			setup: func(g *gittest.Fake) {
+				g.Fail("PullRebase", services.ErrRebaseConflict)
+				g.Conflicts = []string{"home/.zshrc"}
+				g.Stages = map[int]map[string][]byte{services.StageTheirs: {"home/.zshrc": []byte("local\n")}}
+			},
+			// The remote deleted the file; keep the local side.
+			input: "l\n",
+			calls: map[string]int{"Add": 1, "RebaseContinue": 1, "Push": 1},
+		},
+		{
+			name:    "read_only",
+			config:  map[string]string{"read_only": "true"},
+			wantErr: true,
+			calls:   map[string]int{"PullRebase": 0, "Push": 0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t, tt.config)
+			if tt.setup != nil {
+				tt.setup(env.git)
+			}
+			err := runPublish(env.command(tt.input), env.dotman, env.git, tt.noPull, false, false)
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("runPublish() = %v, want error %v; calls %q", err, tt.wantErr, env.git.Calls)
+			}
+			if err != nil && !errors.Is(err, errReported) {
+				t.Errorf("runPublish() = %v, want errReported", err)
+			}
+			for method, n := range tt.calls {
+				if got := env.git.Called(method); got != n {
+					t.Errorf("%s called %d times, want %d; calls %q", method, got, n, env.git.Calls)
+				}
+			}
+			if env.git.Rebasing != tt.rebasing {
+				t.Errorf("Rebasing = %v, want %v", env.git.Rebasing, tt.rebasing)
+			}
+		})
+	}
+}
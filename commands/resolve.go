@@ -0,0 +1,294 @@
+package commands
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"dotman/diffview"
+	"dotman/services"
+
+	"github.com/spf13/cobra"
+)
+
+// AllowDuringRebase is the annotation marking commands that may run while the
+// dotfiles repo is in the middle of a rebase.
+const AllowDuringRebase = "dotman/allow-during-rebase"
+
+// CheckRebase refuses to run cmd while the dotfiles repo is half-way through a
+// rebase, since reading or writing files then would act on a mix of local and
+// remote commits. Commands annotated with AllowDuringRebase, cobra's help and
+// completion commands, and machines without an initialized repo are let
+// through.
+func CheckRebase(cmd *cobra.Command, dotman *services.DotmanService, git services.Git) error {
+	for c := cmd; c != nil; c = c.Parent() {
+		if _, ok := c.Annotations[AllowDuringRebase]; ok || c.Name() == "help" || c.Name() == "completion" {
+			return nil
+		}
+	}
+	repoDir, err := dotman.IsInitialized()
+	if err != nil {
+		return nil
+	}
+	rebasing, err := git.RebaseInProgress(repoDir)
+	if err != nil || !rebasing {
+		return nil
+	}
+	return fmt.Errorf("[ERROR] The dotfiles repo %s is in the middle of a rebase. Run 'dotman resolve' to finish it, or 'dotman resolve --abort' to undo the pull.", repoDir)
+}
+
+func NewResolveCommand(dotman *services.DotmanService, git services.Git) *cobra.Command {
+	var abort bool
+	var showSecrets bool
+	cmd := &cobra.Command{
+		Use:   "resolve",
+		Short: "Finish or abort a pull that stopped on conflicts",
+		Long: `Resolve the conflicts left by a pull that stopped half-way through rebasing
+your local commits onto the remote ones.
+
+Each conflicted file is shown side by side, the remote version on the left and
+your local commit on the right. Keep one side or edit the file with its
+conflict markers, then dotman continues the rebase. With --abort the pull is
+undone and the repo returns to where it was before it.
+
+While a rebase is in progress every other command refuses to run.`,
+		Annotations: map[string]string{AllowDuringRebase: ""},
+		Run: func(cmd *cobra.Command, args []string) {
+			exitOnError(runResolve(cmd, dotman, git, abort, showSecrets))
+		},
+	}
+	cmd.Flags().BoolVar(&abort, "abort", false, "Undo the pull instead of resolving it")
+	cmd.Flags().BoolVar(&showSecrets, "show-secrets", false, "Show encrypted files in conflict views")
+	return cmd
+}
+
+func runResolve(cmd *cobra.Command, dotman *services.DotmanService, git services.Git, abort, showSecrets bool) error {
+	repoDir, err := dotman.IsInitialized()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return errReported
+	}
+	rebasing, err := git.RebaseInProgress(repoDir)
+	if err != nil {
+		reportGitError("resolve", "Checking for a rebase", err)
+		return errReported
+	}
+	if !rebasing {
+		fmt.Println("[resolve] No rebase in progress; nothing to resolve.")
+		return nil
+	}
+	if abort {
+		if err := git.RebaseAbort(repoDir); err != nil {
+			reportGitError("resolve", "Aborting the rebase", err)
+			return errReported
+		}
+		fmt.Println("[resolve] Aborted the rebase; the repo is back where it was before the pull.")
+		return nil
+	}
+	if !resolveRebase(bufio.NewScanner(cmd.InOrStdin()), git, "resolve", repoDir, showSecrets) {
+		return errReported
+	}
+	return nil
+}
+
+// resolveRebase walks the user through the conflicts of the rebase in progress
+// in repoDir, one commit at a time, and continues or aborts it. It reports
+// whether the rebase finished; when it returns false the rebase was aborted or
+// left for 'dotman resolve'.
+func resolveRebase(scan *bufio.Scanner, git services.Git, prefix, repoDir string, showSecrets bool) bool {
+	for {
+		files, err := git.ConflictedFiles(repoDir)
+		if err != nil {
+			reportGitError(prefix, "Listing conflicts", err)
+			return false
+		}
+		if len(files) > 0 {
+			fmt.Printf("[%s] The pull stopped on conflicts between the remote and your local commits in:\n", prefix)
+			for _, f := range files {
+				fmt.Printf("  - %s\n", f)
+			}
+		}
+		left := 0
+		for _, f := range files {
+			resolved, quit := resolveConflictedFile(scan, git, prefix, repoDir, f, showSecrets)
+			if quit {
+				return leaveRebase(scan, git, prefix, repoDir)
+			}
+			if !resolved {
+				left++
+			}
+		}
+		if left > 0 {
+			fmt.Printf("[%s] %d file(s) are still unresolved.\n", prefix, left)
+			return leaveRebase(scan, git, prefix, repoDir)
+		}
+
+		err = git.RebaseContinue(repoDir)
+		if errors.Is(err, services.ErrNothingToCommit) {
+			// The resolution matches the remote; the local commit is redundant.
+			err = git.RebaseSkip(repoDir)
+		}
+		switch {
+		case err == nil:
+			if rebasing, _ := git.RebaseInProgress(repoDir); !rebasing {
+				fmt.Printf("[%s] Rebase finished; your local commits are now on top of the remote.\n", prefix)
+				return true
+			}
+		case errors.Is(err, services.ErrRebaseConflict):
+			fmt.Printf("[%s] The next local commit conflicts too.\n", prefix)
+		default:
+			reportGitError(prefix, "Continuing the rebase", err)
+			return leaveRebase(scan, git, prefix, repoDir)
+		}
+	}
+}
+
+// resolveConflictedFile shows both sides of the conflicted repo path f and
+// asks which to keep. It reports whether f was resolved and staged, and
+// whether the user asked to stop.
+func resolveConflictedFile(scan *bufio.Scanner, git services.Git, prefix, repoDir, f string, showSecrets bool) (bool, bool) {
+	remote, inRemote, err := git.ShowStage(repoDir, services.StageOurs, f)
+	if err != nil {
+		reportGitError(prefix, "Reading the remote side of "+f, err)
+		return false, false
+	}
+	local, inLocal, err := git.ShowStage(repoDir, services.StageTheirs, f)
+	if err != nil {
+		reportGitError(prefix, "Reading the local side of "+f, err)
+		return false, false
+	}
+	encrypted := services.IsEncrypted(remote) || services.IsEncrypted(local)
+
+	show := func() {
+		switch {
+		case !inRemote:
+			fmt.Printf("[%s] %s was deleted on the remote and changed locally.\n", prefix, f)
+		case !inLocal:
+			fmt.Printf("[%s] %s was deleted locally and changed on the remote.\n", prefix, f)
+		default:
+			renderer := diffview.NewRenderer()
+			renderer.ShowSensitive = showSecrets
+			renderer.Theme.LeftTitle = "remote"
+			renderer.Theme.RightTitle = "local"
+			panels, err := renderer.RenderFiles([]diffview.FilePair{{Label: f, LeftContent: remote, RightContent: local, Sensitive: encrypted}}, true)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "[%s] Failed to display diff viewer for %s: %v\n", prefix, f, err)
+				return
+			}
+			for _, p := range panels {
+				fmt.Println(p)
+			}
+		}
+	}
+
+	path := filepath.Join(repoDir, filepath.FromSlash(f))
+	fmt.Println()
+	show()
+	for {
+		fmt.Print("Resolve: [r] take remote, [l] keep local, [e] edit, [d] diff, [s] skip, [q] quit: ")
+		if !scan.Scan() {
+			return false, true
+		}
+		var side []byte
+		var keep bool
+		var stage int
+		switch strings.ToLower(strings.TrimSpace(scan.Text())) {
+		case "r", "remote":
+			side, keep, stage = remote, inRemote, services.StageOurs
+		case "l", "local":
+			side, keep, stage = local, inLocal, services.StageTheirs
+		case "e", "edit":
+			if encrypted {
+				fmt.Printf("[%s] %s is encrypted and cannot be merged by hand; take one side.\n", prefix, f)
+				continue
+			}
+			if err := openEditor(path); err != nil {
+				fmt.Fprintf(os.Stderr, "[%s] Failed to open the editor: %v\n", prefix, err)
+				continue
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "[%s] Failed to read %s: %v\n", prefix, path, err)
+				continue
+			}
+			if hasConflictMarkers(data) {
+				fmt.Printf("[%s] %s still has conflict markers.\n", prefix, f)
+				continue
+			}
+			if err := git.Add(repoDir, []string{f}); err != nil {
+				reportGitError(prefix, "Staging "+f, err)
+				continue
+			}
+			fmt.Printf("[%s] Resolved %s by hand.\n", prefix, f)
+			return true, false
+		case "d", "diff":
+			show()
+			continue
+		case "s", "skip", "":
+			fmt.Printf("[%s] Skipped %s.\n", prefix, f)
+			return false, false
+		case "q", "quit":
+			return false, true
+		default:
+			fmt.Printf("[%s] Please enter 'r', 'l', 'e', 'd', 's' or 'q'.\n", prefix)
+			continue
+		}
+
+		if !keep {
+			err = git.Remove(repoDir, []string{f})
+		} else if err = writeConflictSide(git, repoDir, f, stage, path, side); err == nil {
+			err = git.Add(repoDir, []string{f})
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[%s] Failed to resolve %s: %v\n", prefix, f, err)
+			continue
+		}
+		fmt.Printf("[%s] Resolved %s.\n", prefix, f)
+		return true, false
+	}
+}
+
+// writeConflictSide writes the chosen side of the conflicted path f over its
+// working copy at path. The mode of the working copy is kept; when git left
+// none (a modify/delete conflict), the mode recorded for that side is used, so
+// an executable stays executable.
+func writeConflictSide(git services.Git, repoDir, f string, stage int, path string, side []byte) error {
+	mode := os.FileMode(0644)
+	if stat, err := os.Stat(path); err == nil {
+		mode = stat.Mode().Perm()
+	} else if m, err := git.StageMode(repoDir, stage, f); err != nil {
+		return err
+	} else if m != 0 {
+		mode = m
+	}
+	fs := services.NewFileService()
+	if err := fs.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return fs.WriteFile(path, side, mode)
+}
+
+// leaveRebase offers to abort a rebase that was not finished. Otherwise it is
+// left in place for 'dotman resolve'.
+func leaveRebase(scan *bufio.Scanner, git services.Git, prefix, repoDir string) bool {
+	if confirm(scan, "Abort the rebase and undo the pull? [y/N]: ") {
+		if err := git.RebaseAbort(repoDir); err != nil {
+			reportGitError(prefix, "Aborting the rebase", err)
+			return false
+		}
+		fmt.Printf("[%s] Aborted the rebase; the repo is back where it was before the pull.\n", prefix)
+		return false
+	}
+	fmt.Printf("[%s] The rebase is still in progress. Run 'dotman resolve' to finish it; other commands refuse to run until then.\n", prefix)
+	return false
+}
+
+// hasConflictMarkers reports whether data still has the start of a conflict
+// left by a merge.
+func hasConflictMarkers(data []byte) bool {
+	return bytes.Contains(data, []byte("\n<<<<<<< ")) || bytes.HasPrefix(data, []byte("<<<<<<< "))
+}
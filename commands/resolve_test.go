@@ -0,0 +1,87 @@
+package commands
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"dotman/services"
+	"dotman/services/gittest"
+)
+
+func TestWriteConflictSide(t *testing.T) {
+	tests := []struct {
+		name string
+		// existing is the mode of the working copy, or 0 when git left none.
+		existing os.FileMode
+		staged   os.FileMode
+		want     os.FileMode
+	}{
+		{name: "keeps_working_copy_mode", existing: 0700, staged: 0644, want: 0700},
+		{name: "recreated_executable", staged: 0755, want: 0755},
+		{name: "recreated_without_mode", want: 0644},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := t.TempDir()
+			path := filepath.Join(repo, "home", ".local", "bin", "tool")
+			if tt.existing != 0 {
+				writeTestFile(t, path, "old\n")
+				if err := os.Chmod(path, tt.existing); err != nil {
+					t.Fatal(err)
+				}
+			}
+			git := gittest.NewFake()
+			git.StageModes = map[int]map[string]os.FileMode{services.StageTheirs: {"home/.local/bin/tool": tt.staged}}
+
+			if err := writeConflictSide(git, repo, "home/.local/bin/tool", services.StageTheirs, path, []byte("new\n")); err != nil {
+				t.Fatalf("writeConflictSide: %v", err)
+			}
+			stat, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stat.Mode().Perm() != tt.want {
+				t.Errorf("mode = %v, want %v", stat.Mode().Perm(), tt.want)
+			}
+			if data, _ := os.ReadFile(path); string(data) != "new\n" {
+				t.Errorf("content = %q", data)
+			}
+		})
+	}
+}
+
+func TestRunResolve(t *testing.T) {
+	tests := []struct {
+		name     string
+		rebasing bool
+		abort    bool
+		fail     string
+		wantErr  bool
+		calls    map[string]int
+	}{
+		{name: "nothing_to_resolve", calls: map[string]int{"RebaseAbort": 0}},
+		{name: "abort", rebasing: true, abort: true, calls: map[string]int{"RebaseAbort": 1}},
+		{name: "abort_fails", rebasing: true, abort: true, fail: "RebaseAbort", wantErr: true},
+		{name: "check_fails", fail: "RebaseInProgress", wantErr: true, calls: map[string]int{"RebaseAbort": 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t, nil)
+			env.git.Rebasing = tt.rebasing
+			if tt.fail != "" {
+				env.git.Fail(tt.fail, errors.New("boom"))
+			}
+			err := runResolve(env.command(""), env.dotman, env.git, tt.abort, false)
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("runResolve() = %v, want error %v; calls %q", err, tt.wantErr, env.git.Calls)
+			}
+			for method, n := range tt.calls {
+				if got := env.git.Called(method); got != n {
+					t.Errorf("%s called %d times, want %d; calls %q", method, got, n, env.git.Calls)
+				}
+			}
+		})
+	}
+}
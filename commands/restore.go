@@ -0,0 +1,130 @@
+package commands
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"dotman/services"
+
+	"github.com/spf13/cobra"
+)
+
+func NewRestoreCommand(dotman *services.DotmanService, fs *services.FileService, backups *services.BackupService) *cobra.Command {
+	var list bool
+	var yes bool
+	var prune bool
+
+	cmd := &cobra.Command{
+		Use:   "restore [<set>] [paths...]",
+		Short: "Restore home files from a backup taken before apply overwrote them",
+		Long: `Every file dotman overwrites or deletes in $HOME is first saved to a
+timestamped backup set under $XDG_STATE_HOME/dotman/backups.
+
+Without arguments (or with --list) the available sets are listed. With a set id
+(or "latest") and --list the files in that set are listed. Otherwise the files
+in the set are restored, optionally limited to the given paths. The current
+home copies are backed up again before they are replaced.
+
+Old sets are pruned according to the backup.keep and backup.max_age settings.
+
+Examples:
+  dotman restore --list
+  dotman restore latest ~/.zshrc
+  dotman restore 20240101-120000`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if prune {
+				pruneBackups(dotman, backups, "restore")
+				return
+			}
+			if len(args) == 0 {
+				listBackupSets(backups)
+				return
+			}
+			set, err := backups.Get(args[0])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "[restore] %v\n", err)
+				os.Exit(1)
+			}
+			if list {
+				fmt.Printf("Backup %s (%s, %s):\n", set.ID, set.Manifest.Command, set.Manifest.Created)
+				for _, entry := range set.Manifest.Files {
+					fmt.Printf("  %-9s %s\n", entry.Action, entry.Path)
+				}
+				return
+			}
+
+			homeDir := fs.HomeDir()
+			var paths []string
+			for _, arg := range args[1:] {
+				path := fs.ExpandHome(arg)
+				if fs.IsAbs(path) {
+					rel, err := fs.Rel(homeDir, path)
+					if err != nil {
+						fmt.Fprintf(os.Stderr, "[restore] Path is not inside $HOME: %s\n", arg)
+						os.Exit(1)
+					}
+					// Files outside $HOME are kept in backups by absolute path.
+					if rel != ".." && !strings.HasPrefix(rel, "../") {
+						path = rel
+					}
+				}
+				paths = append(paths, path)
+			}
+
+			if !yes {
+				scan := bufio.NewScanner(os.Stdin)
+				if !confirm(scan, fmt.Sprintf("Restore files from backup %s into %s? [y/N]: ", set.ID, homeDir)) {
+					fmt.Println("[restore] Aborted.")
+					return
+				}
+			}
+
+			// Keep what is currently in place, so a restore can itself be undone.
+			current := backups.Begin("restore")
+			for _, entry := range set.Manifest.Files {
+				if !services.PathWithin(entry.Path, paths) {
+					continue
+				}
+				if err := current.Save(homeDir, entry.Path, "overwrite"); err != nil {
+					fmt.Fprintf(os.Stderr, "[restore] Failed to back up current %s: %v\n", entry.Path, err)
+					os.Exit(1)
+				}
+			}
+
+			restored, err := backups.Restore(set, homeDir, paths)
+			for _, rel := range restored {
+				fmt.Printf("[restore] Restored %s\n", rel)
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "[restore] Failed to restore: %v\n", err)
+				os.Exit(1)
+			}
+			if len(restored) == 0 {
+				fmt.Println("[restore] Nothing matched in that backup.")
+				return
+			}
+			finishBackup(dotman, backups, current, "restore")
+		},
+	}
+	cmd.Flags().BoolVarP(&list, "list", "l", false, "List backup sets, or the files in a set")
+	cmd.Flags().BoolVarP(&yes, "yes", "y", false, "Do not ask for confirmation")
+	cmd.Flags().BoolVar(&prune, "prune", false, "Apply the retention policy to existing backups and exit")
+	return cmd
+}
+
+func listBackupSets(backups *services.BackupService) {
+	sets, err := backups.List()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[restore] Failed to list backups: %v\n", err)
+		os.Exit(1)
+	}
+	if len(sets) == 0 {
+		fmt.Printf("[restore] No backups in %s\n", backups.Dir())
+		return
+	}
+	for _, set := range sets {
+		fmt.Printf("%s  %-8s %d file(s)\n", set.ID, set.Manifest.Command, len(set.Manifest.Files))
+	}
+}
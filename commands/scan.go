@@ -0,0 +1,166 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"dotman/diffview"
+	"dotman/services"
+	"dotman/types"
+)
+
+// scanFiles compares the repo home tree, with any host or profile overlays
+// layered over it, against userHome. Each difference is classified using the
+// sync state; new files under directories tracked in the repo manifest are
+// picked up, ignored paths are skipped and content filters such as templates
+// are applied to the repo side. Manifest entries decide which files apply to
+// this machine and where they live in $HOME; tags, when given, limit the scan
+// to entries carrying one of them.
+func scanFiles(dotman *services.DotmanService, fs *services.FileService, state *services.StateService, content *services.ContentService, repoHome, userHome string, tags []string) (changed, created, inSync []types.FileDiff, err error) {
+	manifest, err := dotman.Manifest()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	ignore, err := dotman.IgnoreMatcher()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	layers, err := dotman.HomeLayers()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return fs.CompareFilesWith(repoHome, userHome, services.CompareOptions{
+		State:       state,
+		TrackedDirs: manifest.Directories(),
+		Ignore:      ignore,
+		Overlays:    layerDirs(layers)[1:],
+		Content:     content,
+		Manifest:    manifest,
+		Tags:        tags,
+	})
+}
+
+// skipUnreadable reports the files in changed whose repo copy could not be
+// converted to its home form and returns the rest, so one broken template or
+// missing key does not hold up every other file.
+func skipUnreadable(prefix string, changed []types.FileDiff) []types.FileDiff {
+	kept := changed[:0]
+	for _, info := range changed {
+		if info.Err != nil {
+			fmt.Fprintf(os.Stderr, "[%s] Skipping %s: %v\n", prefix, info.RelPath, info.Err)
+			continue
+		}
+		kept = append(kept, info)
+	}
+	return kept
+}
+
+// repoDiffPair builds the diff viewer pair for a tracked file, showing filtered
+// repo files (such as templates) in their home form and marking decrypted
+// secrets as sensitive. homePath is the file in $HOME.
+func repoDiffPair(content *services.ContentService, rel, repoPath, homePath string) diffview.FilePair {
+	pair := diffview.FilePair{
+		Label:     rel,
+		LeftPath:  repoPath,
+		RightPath: homePath,
+	}
+	if data, filtered, err := content.HomeContent(rel, repoPath); err == nil && filtered {
+		pair.LeftContent = data
+		pair.Sensitive = content.Sensitive(rel, repoPath)
+	}
+	return pair
+}
+
+// layerRel maps a path relative to the repo root onto the home tree when it
+// lies inside one of layers, e.g. "hosts/laptop/home/.zshrc" to ".zshrc".
+func layerRel(layers []services.HomeLayer, repoRel string) (string, bool) {
+	repoRel = strings.TrimPrefix(filepath.ToSlash(repoRel), "./")
+	for i := len(layers) - 1; i >= 0; i-- {
+		if rel, ok := strings.CutPrefix(repoRel, layers[i].Name+"/"); ok {
+			return rel, true
+		}
+	}
+	return "", false
+}
+
+// rootRel maps a path relative to the repo root onto the manifest root it lies
+// in, e.g. "root/etc/hosts" to "root:etc/hosts".
+func rootRel(manifest *services.ManifestService, repoRel string) (string, bool) {
+	repoRel = strings.TrimPrefix(filepath.ToSlash(repoRel), "./")
+	for _, root := range manifest.Roots() {
+		if within, ok := strings.CutPrefix(repoRel, root.Dir+"/"); ok && within != "" {
+			return root.Name + ":" + within, true
+		}
+	}
+	return "", false
+}
+
+// layerDirs returns the directories of layers, lowest precedence first.
+func layerDirs(layers []services.HomeLayer) []string {
+	dirs := make([]string, len(layers))
+	for i, layer := range layers {
+		dirs[i] = layer.Dir
+	}
+	return dirs
+}
+
+// homeMode returns the permissions to write the home copy of info with: the
+// manifest mode, 0600 for secrets, or fallback (usually the repo file's mode).
+func homeMode(info types.FileDiff, fallback os.FileMode) os.FileMode {
+	switch {
+	case info.Mode != 0:
+		return info.Mode
+	case info.Sensitive:
+		return 0600
+	default:
+		return fallback
+	}
+}
+
+// homeRel returns the path of info's home copy relative to userHome, as used
+// for backups; copies outside userHome keep their absolute path.
+func homeRel(userHome string, info types.FileDiff) string {
+	rel, err := filepath.Rel(userHome, info.HomePath)
+	if err != nil {
+		return info.RelPath
+	}
+	if rel == ".." || strings.HasPrefix(rel, "../") {
+		return info.HomePath
+	}
+	return rel
+}
+
+// trackedRel maps path, absolute, onto its name in the repo: relative to
+// userHome, or "<root>:<path>" for a path under one of the manifest's roots.
+func trackedRel(manifest *services.ManifestService, userHome, path string) (string, bool) {
+	rel, err := filepath.Rel(userHome, path)
+	if err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, "../") {
+		return rel, true
+	}
+	return manifest.RelFor(userHome, path)
+}
+
+// argRel resolves a path argument, relative to userHome unless absolute, to its
+// name in the repo (see trackedRel).
+func argRel(fs *services.FileService, manifest *services.ManifestService, userHome, arg string) (string, bool) {
+	path := fs.ExpandHome(arg)
+	if !fs.IsAbs(path) {
+		path = fs.Join(userHome, path)
+	}
+	return trackedRel(manifest, userHome, filepath.Clean(path))
+}
+
+// repoCandidates returns the repo-relative paths rel may be stored at, highest
+// precedence first: each overlay, then home/, or its root directory.
+func repoCandidates(manifest *services.ManifestService, layers []services.HomeLayer, repoDir, rel string) []string {
+	if root, _ := manifest.SplitRel(rel); root != nil {
+		return []string{filepath.ToSlash(mustRepoRel(repoDir, manifest.RepoPath("", rel)))}
+	}
+	paths := make([]string, 0, len(layers))
+	for i := len(layers) - 1; i >= 0; i-- {
+		paths = append(paths, filepath.ToSlash(filepath.Join(layers[i].Name, rel)))
+	}
+	return paths
+}
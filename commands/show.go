@@ -30,31 +30,145 @@ func runShow(dotman *services.DotmanService, fs *services.FileService) {
 	repoHome := filepath.Join(repoRoot, "home")
 	userHome := fs.HomeDir()
 
-	// Build set of files with local changes
-	changed, _, err := fs.CompareFiles(repoHome, userHome)
+	ignore, err := dotman.IgnoreMatcher()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	layers, err := dotman.HomeLayers()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	dirs := layerDirs(layers)
+	content, err := dotman.Content()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	manifest, err := dotman.Manifest()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	// Build set of repo files whose home copy has local changes
+	changed, _, _, err := fs.CompareFilesWith(repoHome, userHome, services.CompareOptions{Ignore: ignore, Overlays: dirs[1:], Content: content, Manifest: manifest})
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "[show] Error scanning files: %v\n", err)
 		os.Exit(1)
 	}
 	changedSet := make(map[string]struct{}, len(changed))
 	for _, f := range changed {
-		changedSet[f.RelPath] = struct{}{}
+		if f.Err != nil {
+			continue
+		}
+		changedSet[f.RepoPath] = struct{}{}
 	}
 
-	rootLabel := fmt.Sprintf("home (repo: %s → extracts to %s)", repoHome, userHome)
+	annotate := func(path, rel string, isDir bool) string {
+		suffix := entryAnnotation(manifest, userHome, rel)
+		if isDir {
+			return suffix
+		}
+		if _, ok := changedSet[path]; ok {
+			return suffix + fmt.Sprintf(" %s(local)%s", colorGreen, colorReset)
+		}
+		if root, _ := manifest.SplitRel(rel); root != nil {
+			return suffix
+		}
+		if effective := fs.ResolveRepoFile(dirs, rel); effective != path {
+			for _, layer := range layers {
+				if strings.HasPrefix(effective, layer.Dir+string(filepath.Separator)) {
+					return suffix + fmt.Sprintf(" %s(overridden by %s)%s", colorYellow, layer.Name, colorReset)
+				}
+			}
+		}
+		return suffix
+	}
 
-	lines, err := renderTree(repoHome, rootLabel, changedSet)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "[show] Failed to render tree: %v\n", err)
-		os.Exit(1)
+	for i, layer := range layers {
+		rootLabel := fmt.Sprintf("home (repo: %s → extracts to %s)", repoHome, userHome)
+		if i > 0 {
+			rootLabel = fmt.Sprintf("%s (overlay: %s, takes precedence over the trees above)", layer.Name, layer.Dir)
+			fmt.Println()
+		}
+		lines, err := renderTree(layer.Dir, rootLabel, annotate, ignore)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[show] Failed to render tree: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(strings.Join(lines, "\n"))
+	}
+
+	for _, root := range manifest.Roots() {
+		dir := manifest.RootDir(root)
+		if _, err := os.Stat(dir); err != nil {
+			continue
+		}
+		rootLabel := fmt.Sprintf("%s (repo: %s → extracts to %s)", root.Name, dir, manifest.HomePath(userHome, root.Name+":"))
+		lines, err := renderTree(dir, rootLabel, func(path, rel string, isDir bool) string {
+			return annotate(path, root.Name+":"+filepath.ToSlash(rel), isDir)
+		}, ignore)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[show] Failed to render tree: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println()
+		fmt.Println(strings.Join(lines, "\n"))
 	}
-	fmt.Println(strings.Join(lines, "\n"))
 }
 
 const colorGreen = "\033[32m"
+const colorYellow = "\033[33m"
+const colorDim = "\033[2m"
 const colorReset = "\033[0m"
 
-func renderTree(rootPath, label string, changedSet map[string]struct{}) ([]string, error) {
+// entryAnnotation describes the manifest entry for rel, if it has its own, as
+// "[os=linux mode=0600 → ~/.target tags=work]", and marks entries that do not
+// apply to this machine. The target shown is the one for this machine.
+func entryAnnotation(manifest *services.ManifestService, userHome, rel string) string {
+	var suffix string
+	if e := manifest.Entry(rel); e != nil && e.Path == filepath.ToSlash(rel) {
+		var attrs []string
+		if c := e.Conditions(); c != "" {
+			attrs = append(attrs, c)
+		}
+		if e.Mode != "" {
+			attrs = append(attrs, "mode="+e.Mode)
+		}
+		if e.Template {
+			attrs = append(attrs, "template")
+		}
+		if e.Encrypted {
+			attrs = append(attrs, "encrypted")
+		}
+		if e.Target != "" || len(e.Targets) > 0 {
+			target := manifest.HomePath(userHome, rel)
+			if within, err := filepath.Rel(userHome, target); err == nil && !strings.HasPrefix(within, "..") {
+				target = "~/" + within
+			}
+			attrs = append(attrs, "→ "+target)
+		}
+		if len(e.Tags) > 0 {
+			attrs = append(attrs, "tags="+strings.Join(e.Tags, ","))
+		}
+		if len(attrs) > 0 {
+			suffix = " [" + strings.Join(attrs, " ") + "]"
+		}
+		if !manifest.Active(rel) {
+			suffix += fmt.Sprintf(" %s(not for this machine)%s", colorDim, colorReset)
+		}
+	}
+	return suffix
+}
+
+// renderTree draws the tree under rootPath; annotate returns a suffix for each
+// entry given its full path, its path relative to the home tree and whether it
+// is a directory.
+func renderTree(rootPath, label string, annotate func(path, rel string, isDir bool) string, ignore *services.IgnoreMatcher) ([]string, error) {
 	lines := []string{label}
 
 	var walk func(path, prefix string) error
@@ -67,10 +181,11 @@ func renderTree(rootPath, label string, changedSet map[string]struct{}) ([]strin
 			return entries[i].Name() < entries[j].Name()
 		})
 
-		// filter out common non-managed dirs
+		// filter out ignored entries
 		filtered := entries[:0]
 		for _, e := range entries {
-			if e.Name() == ".git" || e.Name() == ".history" {
+			relPath, _ := filepath.Rel(rootPath, filepath.Join(path, e.Name()))
+			if ignore.Ignored(relPath, e.IsDir()) {
 				continue
 			}
 			filtered = append(filtered, e)
@@ -85,15 +200,9 @@ func renderTree(rootPath, label string, changedSet map[string]struct{}) ([]strin
 				nextPrefix = prefix + "    "
 			}
 
-			suffix := ""
-			if !e.IsDir() {
-				fullPath := filepath.Join(path, e.Name())
-				relPath, _ := filepath.Rel(rootPath, fullPath)
-				relPath = services.NormalizeRelPath(relPath)
-				if _, ok := changedSet[relPath]; ok {
-					suffix = fmt.Sprintf(" %s(local)%s", colorGreen, colorReset)
-				}
-			}
+			fullPath := filepath.Join(path, e.Name())
+			relPath, _ := filepath.Rel(rootPath, fullPath)
+			suffix := annotate(fullPath, services.NormalizeRelPath(relPath), e.IsDir())
 
 			line := fmt.Sprintf("%s%s%s%s", prefix, connector, e.Name(), suffix)
 			lines = append(lines, line)
@@ -0,0 +1,280 @@
+package commands
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"dotman/services"
+	"dotman/types"
+
+	"github.com/spf13/cobra"
+)
+
+// Exit codes for `dotman status`. The drift and repo bits combine, so a
+// script can test for either condition independently; an error is reported
+// as statusExitError alone.
+const (
+	statusExitClean = 0
+	statusExitError = 1
+	statusExitDrift = 2 // home and repo copies differ
+	statusExitRepo  = 4 // uncommitted changes or unpushed commits in the repo
+)
+
+const (
+	stateModifiedHome = "modified-in-home"
+	stateNewInHome    = "new-in-home"
+	stateDeletedHome  = "deleted-in-home"
+	stateModifiedRepo = "modified-in-repo"
+	stateConflict     = "modified-in-both"
+	stateMissingHome  = "missing-in-home"
+	stateUncommitted  = "uncommitted-in-repo"
+	stateUnreadable   = "unreadable"
+)
+
+// statusEntry is one file in the report. Path is relative to $HOME (or
+// "<root>:<path>" for manifest roots) like every other dotman path; only repo
+// files outside the home trees, such as the manifest, are named by their repo
+// path. Uncommitted entries also carry RepoPath.
+type statusEntry struct {
+	Path     string `json:"path"`
+	RepoPath string `json:"repo_path,omitempty"`
+	State    string `json:"state"`
+	// Error says why an unreadable file could not be compared.
+	Error string `json:"error,omitempty"`
+}
+
+type statusReport struct {
+	Repo     string        `json:"repo"`
+	Upstream bool          `json:"upstream"`
+	Ahead    int           `json:"ahead"`
+	Behind   int           `json:"behind"`
+	Files    []statusEntry `json:"files"`
+}
+
+func NewStatusCommand(dotman *services.DotmanService, git services.Git, fs *services.FileService, state *services.StateService) *cobra.Command {
+	var short bool
+	var asJSON bool
+	cmd := &cobra.Command{
+		Use:   "status",
+		Short: "Show which tracked files differ between home and the repo",
+		Long: `Show which tracked files differ between your home directory and the repo,
+along with uncommitted changes and unpushed commits in the repo itself.
+
+Exit codes:
+  0  everything is in sync
+  1  an error occurred, or a file could not be compared (e.g. a template
+     failed to render or no decryption key is available); this is never
+     combined with the codes below
+  2  home and repo copies differ
+  4  the repo has uncommitted changes or unpushed commits
+  6  both of the above`,
+		Run: func(cmd *cobra.Command, args []string) {
+			os.Exit(runStatus(dotman, git, fs, state, short, asJSON))
+		},
+	}
+	cmd.Flags().BoolVarP(&short, "short", "s", false, "Give the output in the short format")
+	cmd.Flags().BoolVar(&asJSON, "json", false, "Give the output as JSON")
+	return cmd
+}
+
+func runStatus(dotman *services.DotmanService, git services.Git, fs *services.FileService, state *services.StateService, short, asJSON bool) int {
+	repoDir, err := dotman.IsInitialized()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return statusExitError
+	}
+	repoHome, err := dotman.GetHomeDir()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return statusExitError
+	}
+	if err := state.Load(); err != nil {
+		fmt.Fprintf(os.Stderr, "[status] Failed to load sync state: %v\n", err)
+		return statusExitError
+	}
+
+	content, err := dotman.Content()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return statusExitError
+	}
+	changed, created, _, err := scanFiles(dotman, fs, state, content, repoHome, fs.HomeDir(), nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[status] Error scanning files: %v\n", err)
+		return statusExitError
+	}
+	uncommitted, err := git.Status(repoDir)
+	if err != nil {
+		reportGitError("status", "Checking git status", err)
+		return statusExitError
+	}
+	layers, err := dotman.HomeLayers()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return statusExitError
+	}
+	manifest, err := dotman.Manifest()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return statusExitError
+	}
+
+	report := statusReport{Repo: repoDir, Files: []statusEntry{}}
+	// Only a missing upstream is expected; any other failure is reported
+	// and makes status exit with statusExitError.
+	failed := false
+	switch ahead, behind, err := git.AheadBehind(repoDir); {
+	case errors.Is(err, services.ErrNoUpstream):
+	case err != nil:
+		reportGitError("status", "Comparing with the remote", err)
+		failed = true
+	default:
+		report.Upstream = true
+		report.Ahead = ahead
+		report.Behind = behind
+	}
+	unreadable := 0
+	for _, info := range changed {
+		if info.Err != nil {
+			unreadable++
+			report.Files = append(report.Files, statusEntry{Path: info.RelPath, State: stateUnreadable, Error: info.Err.Error()})
+			continue
+		}
+		switch info.Direction {
+		case types.HomeChanged:
+			entryState := stateModifiedHome
+			switch {
+			case info.RepoHash == "missing":
+				entryState = stateNewInHome
+			case info.UserHash == "missing":
+				entryState = stateDeletedHome
+			}
+			report.Files = append(report.Files, statusEntry{Path: info.RelPath, State: entryState})
+		case types.RepoChanged:
+			report.Files = append(report.Files, statusEntry{Path: info.RelPath, State: stateModifiedRepo})
+		case types.BothChanged:
+			report.Files = append(report.Files, statusEntry{Path: info.RelPath, State: stateConflict})
+		}
+	}
+	for _, info := range created {
+		report.Files = append(report.Files, statusEntry{Path: info.RelPath, State: stateMissingHome})
+	}
+	for _, e := range uncommitted {
+		if e.Ignored {
+			continue
+		}
+		repoPath := filepath.ToSlash(e.Path)
+		path, ok := layerRel(layers, repoPath)
+		if !ok {
+			path, ok = rootRel(manifest, repoPath)
+		}
+		if !ok {
+			path = repoPath
+		}
+		report.Files = append(report.Files, statusEntry{Path: path, RepoPath: repoPath, State: stateUncommitted})
+	}
+	sort.SliceStable(report.Files, func(i, j int) bool {
+		return report.Files[i].Path < report.Files[j].Path
+	})
+
+	code := statusExitClean
+	if len(changed) > unreadable || len(created) > 0 {
+		code |= statusExitDrift
+	}
+	if len(uncommitted) > 0 || report.Ahead > 0 {
+		code |= statusExitRepo
+	}
+
+	switch {
+	case asJSON:
+		out, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[status] Failed to encode JSON: %v\n", err)
+			return statusExitError
+		}
+		fmt.Println(string(out))
+	case short:
+		printStatusShort(report)
+	default:
+		printStatusLong(report)
+	}
+	if unreadable > 0 || failed {
+		return statusExitError
+	}
+	return code
+}
+
+var statusShortCodes = map[string]string{
+	stateModifiedHome: "H",
+	stateNewInHome:    "A",
+	stateDeletedHome:  "D",
+	stateModifiedRepo: "R",
+	stateConflict:     "C",
+	stateMissingHome:  "!",
+	stateUncommitted:  "U",
+	stateUnreadable:   "E",
+}
+
+func printStatusShort(report statusReport) {
+	if report.Ahead > 0 || report.Behind > 0 {
+		fmt.Printf("## ahead %d, behind %d\n", report.Ahead, report.Behind)
+	}
+	for _, f := range report.Files {
+		fmt.Printf("%s %s\n", statusShortCodes[f.State], f.Path)
+	}
+}
+
+func printStatusLong(report statusReport) {
+	fmt.Printf("Repo: %s\n", report.Repo)
+	switch {
+	case !report.Upstream:
+		fmt.Println("No upstream branch configured.")
+	case report.Ahead > 0 || report.Behind > 0:
+		fmt.Printf("%d unpushed commit(s), %d commit(s) behind upstream.\n", report.Ahead, report.Behind)
+	default:
+		fmt.Println("Up to date with upstream.")
+	}
+
+	sections := []struct {
+		state string
+		title string
+	}{
+		{stateConflict, "Modified in both home and repo (run 'dotman apply' to resolve):"},
+		{stateModifiedHome, "Modified in home (run 'dotman submit'):"},
+		{stateNewInHome, "New in a tracked directory in home (run 'dotman submit'):"},
+		{stateDeletedHome, "Deleted in home (run 'dotman submit'):"},
+		{stateModifiedRepo, "Modified in repo (run 'dotman apply'):"},
+		{stateMissingHome, "Missing in home (run 'dotman apply'):"},
+		{stateUncommitted, "Uncommitted in repo:"},
+		{stateUnreadable, "Could not be compared:"},
+	}
+	clean := true
+	for _, section := range sections {
+		var paths []string
+		for _, f := range report.Files {
+			if f.State != section.state {
+				continue
+			}
+			if f.Error != "" {
+				paths = append(paths, fmt.Sprintf("%s%s: %s", f.Path, colorReset, f.Error))
+				continue
+			}
+			paths = append(paths, f.Path)
+		}
+		if len(paths) == 0 {
+			continue
+		}
+		clean = false
+		fmt.Printf("\n%s\n", section.title)
+		for _, p := range paths {
+			fmt.Printf("  %s%s%s\n", colorGreen, p, colorReset)
+		}
+	}
+	if clean {
+		fmt.Println("\nEverything is in sync.")
+	}
+}
@@ -1,6 +1,8 @@
 package commands
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -9,70 +11,138 @@ import (
 
 	"dotman/diffview"
 	"dotman/services"
+	"dotman/types"
 
-	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/spf13/cobra"
 )
 
 
-type commitModel struct {
-	input      textinput.Model
-	defaultMsg string
+// submitCommitMessage builds the default message for what is staged in
+// repoDir, naming files by their path in $HOME (or root) where they have one.
+// It returns "" when nothing is staged.
+func submitCommitMessage(dotman *services.DotmanService, git services.Git, manifest *services.ManifestService, layers []services.HomeLayer, repoDir string) (string, error) {
+	tmpl, err := dotman.CommitTemplate()
+	if err != nil {
+		return "", err
+	}
+	changes, err := git.StagedChanges(repoDir)
+	if err != nil {
+		return "", err
+	}
+	if len(changes) == 0 {
+		return "", nil
+	}
+	for i, c := range changes {
+		if rel, ok := layerRel(layers, c.Path); ok {
+			changes[i].Path = rel
+		} else if rel, ok := rootRel(manifest, c.Path); ok {
+			changes[i].Path = rel
+		}
+	}
+	return services.CommitMessage(tmpl, changes)
 }
 
-func (m *commitModel) Init() tea.Cmd {
-	return textinput.Blink
+// commitSubmission commits what submit staged in repoDir, with the generated
+// message once the user has accepted or edited it.
+func commitSubmission(scan *bufio.Scanner, dotman *services.DotmanService, git services.Git, manifest *services.ManifestService, layers []services.HomeLayer, repoDir string, count int) error {
+	message, err := submitCommitMessage(dotman, git, manifest, layers, repoDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[submit] Failed to prepare the commit message: %v\n", err)
+		return errReported
+	}
+	if message == "" {
+		fmt.Println("[submit] Nothing to commit; the repo already matches your home directory.")
+		return nil
+	}
+	if message, err = promptCommitMessage(scan, message); err != nil {
+		fmt.Fprintf(os.Stderr, "[submit] Failed to edit the commit message: %v\n", err)
+		return errReported
+	}
+	if message == "" {
+		fmt.Println("[submit] Not committed; the changes stay staged in the repo.")
+		return nil
+	}
+	switch err := git.Commit(repoDir, message); {
+	case errors.Is(err, services.ErrNothingToCommit):
+		fmt.Println("[submit] Nothing to commit; the repo already matches your home directory.")
+	case err != nil:
+		// A rejecting commit hook's output is part of err.
+		reportGitError("submit", "Commit", err)
+		return errReported
+	default:
+		fmt.Printf("[submit] Committed %d file(s).\n", count)
+	}
+	return nil
 }
 
-func (m *commitModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
-	switch msg := msg.(type) {
-	case tea.KeyMsg:
-		switch msg.Type {
-		case tea.KeyEnter:
-			return m, tea.Quit
-		case tea.KeyEsc, tea.KeyCtrlC:
-			m.input.SetValue("")
-			return m, tea.Quit
+// promptCommitMessage shows message and asks whether to commit with it, edit
+// it in $EDITOR first, or not commit. It returns "" when nothing should be
+// committed.
+func promptCommitMessage(scan *bufio.Scanner, message string) (string, error) {
+	for {
+		fmt.Println("[submit] Commit message:")
+		for _, line := range strings.Split(strings.TrimRight(message, "\n"), "\n") {
+			fmt.Printf("    %s\n", line)
+		}
+		fmt.Print("Commit with this message? [Y/e/n] (e edits it in $EDITOR): ")
+		if !scan.Scan() {
+			return message, nil
+		}
+		switch strings.ToLower(strings.TrimSpace(scan.Text())) {
+		case "", "y", "yes":
+			return message, nil
+		case "n", "no":
+			return "", nil
+		case "e", "edit":
+			edited, err := editCommitMessage(message)
+			if err != nil {
+				return "", err
+			}
+			if edited == "" {
+				fmt.Println("[submit] The message is empty.")
+				return "", nil
+			}
+			message = edited
+		default:
+			fmt.Println("[submit] Please enter 'y', 'e' or 'n'.")
 		}
 	}
-	var cmd tea.Cmd
-	m.input, cmd = m.input.Update(msg)
-	return m, cmd
-}
-
-func (m *commitModel) View() string {
-	var b strings.Builder
-	b.WriteString("\nEnter commit message (Esc for default):\n\n")
-	b.WriteString(m.input.View())
-	b.WriteString("\n")
-	return b.String()
 }
 
-func promptCommitMessage(defaultMsg string) (string, error) {
-	ti := textinput.New()
-	ti.Placeholder = defaultMsg
-	ti.Focus()
-	ti.CharLimit = 256
-	ti.Prompt = "  > "
-
-	p := tea.NewProgram(&commitModel{
-		input:      ti,
-		defaultMsg: defaultMsg,
-	})
-	res, err := p.Run()
+// editCommitMessage opens message in the user's editor and returns the result
+// without comment lines, or "" when it was emptied.
+func editCommitMessage(message string) (string, error) {
+	f, err := os.CreateTemp("", "dotman-commit-*.txt")
 	if err != nil {
 		return "", err
 	}
-	m, ok := res.(*commitModel)
-	if !ok {
-		return "", fmt.Errorf("unexpected model type")
+	defer os.Remove(f.Name())
+	_, err = fmt.Fprintf(f, "%s\n# Edit the commit message above. Lines starting with '#' are ignored,\n# and an empty message cancels the commit.\n", strings.TrimRight(message, "\n"))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
 	}
-	msg := strings.TrimSpace(m.input.Value())
-	if msg == "" {
-		msg = defaultMsg
+	if err != nil {
+		return "", err
+	}
+	if err := openEditor(f.Name()); err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile(f.Name())
+	if err != nil {
+		return "", err
 	}
-	return msg, nil
+	var lines []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if !strings.HasPrefix(line, "#") {
+			lines = append(lines, strings.TrimRight(line, " \t\r"))
+		}
+	}
+	edited := strings.TrimSpace(strings.Join(lines, "\n"))
+	if edited == "" {
+		return "", nil
+	}
+	return edited + "\n", nil
 }
 
 type fileOption struct {
@@ -81,6 +151,7 @@ type fileOption struct {
 }
 
 type selectionModel struct {
+	action   string
 	items    []fileOption
 	cursor   int
 	quit     bool
@@ -137,7 +208,7 @@ func (m selectionModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 func (m selectionModel) View() string {
 	var b strings.Builder
-	b.WriteString("Select files to submit (↑/↓ or j/k, space to toggle, a to toggle all, Enter to confirm, Esc to cancel)\n\n")
+	b.WriteString(fmt.Sprintf("Select files to %s (↑/↓ or j/k, space to toggle, a to toggle all, Enter to confirm, Esc to cancel)\n\n", m.action))
 	if len(m.items) == 0 {
 		b.WriteString("No files available.\n")
 		return b.String()
@@ -156,12 +227,14 @@ func (m selectionModel) View() string {
 	return b.String()
 }
 
-func startSubmitWizard(paths []string) ([]string, bool, error) {
+// startSelectionWizard lets the user pick which of paths to act on; action
+// names the operation in the prompt (e.g. "submit", "apply").
+func startSelectionWizard(action string, paths []string) ([]string, bool, error) {
 	items := make([]fileOption, len(paths))
 	for i, p := range paths {
 		items[i] = fileOption{label: p, selected: true}
 	}
-	p := tea.NewProgram(selectionModel{items: items})
+	p := tea.NewProgram(selectionModel{action: action, items: items})
 	res, err := p.Run()
 	if err != nil {
 		return nil, false, err
@@ -185,63 +258,199 @@ func startSubmitWizard(paths []string) ([]string, bool, error) {
 	return selected, true, nil
 }
 
-func NewSubmitCommand(dotman *services.DotmanService, git *services.GitService, publishCmd *cobra.Command, fs *services.FileService) *cobra.Command {
+func NewSubmitCommand(dotman *services.DotmanService, git services.Git, publishCmd *cobra.Command, fs *services.FileService, state *services.StateService) *cobra.Command {
 	var verbose bool
 	var publish bool
 	var dryRun bool
+	var patch bool
+	var showSecrets bool
+	var allowSecrets []string
 
 	cmd := &cobra.Command{
 		Use:   "submit",
 		Short: "Copy modified tracked files from home into the dotman repo and commit them",
+		Long: `Copy files you changed in your home directory into the repo and commit them.
+
+With --patch each selected file is offered hunk by hunk: stage a hunk, skip
+it, or edit the lines it puts into the repo. Only staged hunks are written to
+the repo copy; skipped ones stay in $HOME as local changes.
+
+Changes to encrypted files are re-encrypted before they are written to the
+repo, and secret values on lines marked with "dotman:secret" or matched by a
+manifest rule are replaced by placeholders and kept in the local secret store.
+Their contents are hidden unless --show-secrets is given.
+
+Everything about to be committed is first scanned for secrets such as private
+keys, API tokens and random-looking values assigned to password or token
+settings, plus any regular expressions set with
+'dotman config set secrets.patterns'. Findings are listed as path:line and
+block the commit. Allow a finding once with --allow-secret <path:line>, or for
+good with a line in the repo's ` + services.SecretAllowFileName + ` file.
+
+The commit message is generated from the committed files, e.g.
+"Update .zshrc, nvim/init.lua (+2 more)", with a body listing each file and
+its diffstat and trailers naming this host and the dotman version. Accept it,
+edit it in $EDITOR, or leave the changes staged. Shape the subject with
+'dotman config set commit.template conventional' or a Go template such as
+'dotfiles: {{.Summary}}'.`,
 		Run: func(cmd *cobra.Command, args []string) {
-			runSubmit(cmd, args, dotman, git, publishCmd, fs, verbose, publish, dryRun)
+			exitOnError(runSubmit(cmd, args, dotman, git, publishCmd, fs, state, verbose, publish, dryRun, patch, showSecrets, allowSecrets))
 		},
 	}
 
 	cmd.Flags().BoolVar(&publish, "publish", false, "Publish after submitting")
 	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Preview changes without committing")
 	cmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "Show verbose output")
+	cmd.Flags().BoolVarP(&patch, "patch", "p", false, "Choose the changes to submit hunk by hunk")
+	cmd.Flags().BoolVar(&showSecrets, "show-secrets", false, "Show the decrypted contents of encrypted files in diffs")
+	cmd.Flags().StringArrayVar(&allowSecrets, "allow-secret", nil, "Commit despite a secret found at `path:line` (repeatable)")
 	return cmd
 }
 
-func runSubmit(cmd *cobra.Command, args []string, dotman *services.DotmanService, git *services.GitService, publishCmd *cobra.Command, fs *services.FileService, verbose, publish, dryRun bool) {
+func runSubmit(cmd *cobra.Command, args []string, dotman *services.DotmanService, git services.Git, publishCmd *cobra.Command, fs *services.FileService, state *services.StateService, verbose, publish, dryRun, patch, showSecrets bool, allowSecrets []string) error {
 	repoDir, err := dotman.IsInitialized()
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+		return errReported
+	}
+	if err := dotman.RequireWritable("submit"); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return errReported
 	}
 	repoHome, err := dotman.GetHomeDir()
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+		return errReported
 	}
 	userHome := fs.HomeDir()
 
-	// 1. Detect content-changed files
-	toUpdate, _, err := fs.CompareFiles(repoHome, userHome)
+	if err := state.Load(); err != nil {
+		fmt.Fprintln(os.Stderr, "[submit] Failed to load sync state:", err)
+		return errReported
+	}
+
+	// 1. Detect content-changed files; only home-side changes are submitted.
+	content, err := dotman.Content()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return errReported
+	}
+	changed, _, inSync, err := scanFiles(dotman, fs, state, content, repoHome, userHome, nil)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "[submit] Error scanning files:", err)
-		os.Exit(1)
+		return errReported
+	}
+	changed = skipUnreadable("submit", changed)
+	var toUpdate []types.FileDiff
+	for _, info := range changed {
+		switch info.Direction {
+		case types.HomeChanged:
+			if data, err := os.ReadFile(info.HomePath); err == nil && hasConflictMarkers(data) {
+				fmt.Printf("[submit] Skipping %s (it still has conflict markers)\n", info.RelPath)
+				continue
+			}
+			toUpdate = append(toUpdate, info)
+		case types.BothChanged:
+			fmt.Printf("[submit] Skipping %s (changed on both sides, run 'dotman apply' first)\n", info.RelPath)
+		}
+	}
+
+	ignore, err := dotman.IgnoreMatcher()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return errReported
 	}
 
 	// Gather both content-changed files (toUpdate) and uncommitted/untracked files (git.Status)
 	statusFiles, err := git.Status(repoHome)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "[submit] Failed to check git status:", err)
-		os.Exit(1)
+		return errReported
+	}
+
+	layers, err := dotman.HomeLayers()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return errReported
 	}
 
-	// Build a set of all files to submit (union of relPaths from toUpdate and statusFiles)
+	// Build a set of all files to submit (union of relPaths from toUpdate and
+	// statusFiles), remembering which repo file each one is written back to:
+	// the overlay it came from, or the base home/ tree.
+	manifest, err := dotman.Manifest()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return errReported
+	}
+	// Files deleted from $HOME since the last sync are removed from the repo.
 	fileSet := make(map[string]struct{})
+	repoPaths := make(map[string]string)
+	homePaths := make(map[string]string)
+	deletedHome := make(map[string]struct{})
 	for _, info := range toUpdate {
 		fileSet[info.RelPath] = struct{}{}
+		repoPaths[info.RelPath] = info.RepoPath
+		homePaths[info.RelPath] = info.HomePath
+		if info.UserHash == "missing" {
+			deletedHome[info.RelPath] = struct{}{}
+		}
 	}
-	for _, f := range statusFiles {
-		fileSet[services.NormalizeRelPath(f)] = struct{}{}
+	// Uncommitted files outside the home layers and roots (such as the
+	// manifest) are staged by their repo path rather than mapped into a tree.
+	// Renamed files remember the repo path they came from, and files deleted
+	// from the repo are staged as removals.
+	repoLevel := make(map[string]struct{})
+	renamedFrom := make(map[string]string)
+	deleted := make(map[string]struct{})
+	for rel := range deletedHome {
+		deleted[rel] = struct{}{}
+	}
+	var conflicts []string
+	for _, e := range statusFiles {
+		if e.Ignored {
+			continue
+		}
+		if e.Conflict {
+			conflicts = append(conflicts, e.Path)
+			continue
+		}
+		f := strings.TrimPrefix(filepath.ToSlash(e.Path), "./")
+		rel, ok := layerRel(layers, f)
+		if !ok {
+			rel, ok = rootRel(manifest, f)
+		}
+		if !ok {
+			rel = f
+			repoLevel[f] = struct{}{}
+		} else if ignore.Ignored(rel, strings.HasSuffix(f, "/")) {
+			continue
+		}
+		fileSet[rel] = struct{}{}
+		if e.Renamed() {
+			renamedFrom[rel] = e.OrigPath
+		}
+		if _, fromHome := repoPaths[rel]; e.Deleted() && !fromHome {
+			deleted[rel] = struct{}{}
+		}
+		if !ok {
+			continue
+		}
+		if _, ok := repoPaths[rel]; !ok {
+			repoPaths[rel] = filepath.Join(repoDir, filepath.FromSlash(f))
+			homePaths[rel] = manifest.HomePath(userHome, rel)
+		}
+	}
+	if len(conflicts) > 0 {
+		fmt.Fprintln(os.Stderr, "[submit] The repo has unresolved merge conflicts in:")
+		for _, f := range conflicts {
+			fmt.Fprintf(os.Stderr, "  - %s\n", f)
+		}
+		fmt.Fprintf(os.Stderr, "Resolve and stage them in %s before submitting.\n", repoDir)
+		return errReported
 	}
 	if len(fileSet) == 0 {
 		fmt.Println("[submit] No changed files to submit.")
-		return
+		return nil
 	}
 
 	// Prepare a stable ordered list for the viewer
@@ -253,16 +462,28 @@ func runSubmit(cmd *cobra.Command, args []string, dotman *services.DotmanService
 
 	// Render diffs for each candidate file before prompting for selection.
 	renderer := diffview.NewRenderer()
+	renderer.ShowSensitive = showSecrets
 	fmt.Println()
 	for _, rel := range allRelPaths {
-		panels, err := renderer.RenderFiles([]diffview.FilePair{{
-			Label:     rel,
-			LeftPath:  filepath.Join(repoHome, rel),
-			RightPath: filepath.Join(userHome, rel),
-		}}, true)
+		if from, ok := renamedFrom[rel]; ok {
+			fmt.Printf("\x1b[33m    (Renamed from %s): %s\x1b[0m\n", from, rel)
+		}
+		if _, ok := repoLevel[rel]; ok {
+			fmt.Printf("\x1b[33m    (Repo File): %s\x1b[0m\n", rel)
+			continue
+		}
+		if _, ok := deletedHome[rel]; ok {
+			fmt.Printf("\x1b[31m    (Deleted in home): %s\x1b[0m\n", rel)
+			continue
+		}
+		if _, ok := deleted[rel]; ok {
+			fmt.Printf("\x1b[31m    (Deleted in repo): %s\x1b[0m\n", rel)
+			continue
+		}
+		panels, err := renderer.RenderFiles([]diffview.FilePair{repoDiffPair(content, rel, repoPaths[rel], homePaths[rel])}, true)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "[submit] Failed to display diff viewer for %s: %v\n", rel, err)
-			os.Exit(1)
+			return errReported
 		}
 		for _, p := range panels {
 			fmt.Println(p)
@@ -272,14 +493,14 @@ func runSubmit(cmd *cobra.Command, args []string, dotman *services.DotmanService
 		}
 	}
 
-	selectedPaths, proceed, err := startSubmitWizard(allRelPaths)
+	selectedPaths, proceed, err := startSelectionWizard("submit", allRelPaths)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "[submit] Failed to select files: %v\n", err)
-		os.Exit(1)
+		return errReported
 	}
 	if !proceed {
 		fmt.Println("[submit] No files selected. Aborting.")
-		return
+		return nil
 	}
 	allRelPaths = selectedPaths
 
@@ -288,58 +509,187 @@ func runSubmit(cmd *cobra.Command, args []string, dotman *services.DotmanService
 		for _, f := range allRelPaths {
 			fmt.Printf("  - %s\n", f)
 		}
-		return
+		return nil
 	}
 
-	// Copy changed files from $HOME to repo (only those in toUpdate)
+	// Convert changed files from $HOME for the repo (only those in toUpdate).
+	// Nothing is written until the secret scan has passed.
 	selectedSet := make(map[string]struct{}, len(allRelPaths))
 	for _, f := range allRelPaths {
 		selectedSet[f] = struct{}{}
 	}
+	pending := make(map[string]pendingWrite)
+	scan := bufio.NewScanner(cmd.InOrStdin())
+	quit := false
 	for _, info := range toUpdate {
-		if _, ok := selectedSet[services.NormalizeRelPath(info.RelPath)]; !ok {
+		rel := services.NormalizeRelPath(info.RelPath)
+		if _, ok := selectedSet[rel]; !ok {
+			continue
+		}
+		if _, ok := deletedHome[rel]; ok {
 			continue
 		}
-		src := filepath.Join(userHome, services.NormalizeRelPath(info.RelPath))
-		dst := filepath.Join(repoHome, services.NormalizeRelPath(info.RelPath))
+		if info.Filtered && !content.Reversible(rel, info.RepoPath) {
+			// Rendered templates are never overwritten; the edit has to be
+			// made to the template source, which is then staged as is.
+			if !editTemplateSource(scan, rel, info.RepoPath) {
+				delete(selectedSet, rel)
+			}
+			continue
+		}
+		if patch {
+			hidden := info.Sensitive && !showSecrets
+			if quit {
+				delete(selectedSet, rel)
+				continue
+			}
+			w, ok := submitHunks(scan, content, rel, info.RepoPath, info.HomePath, hidden, &quit)
+			if !ok {
+				delete(selectedSet, rel)
+				continue
+			}
+			pending[info.RelPath] = w
+			continue
+		}
+		src := info.HomePath
+		dst := info.RepoPath
 		userStat, err := os.Stat(src)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "[submit] Skipping %s (missing in $HOME)\n", info.RelPath)
 			continue
 		}
-		if err := fs.MkdirAll(filepath.Dir(dst), 0755); err != nil {
-			fmt.Fprintf(os.Stderr, "[submit] Failed to create directory for %s: %v\n", dst, err)
+		homeData, err := os.ReadFile(src)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[submit] Failed to read %s: %v\n", src, err)
 			continue
 		}
-		if err := fs.CopyFile(src, dst, userStat.Mode()); err != nil {
-			fmt.Fprintf(os.Stderr, "[submit] Failed to copy %s: %v\n", info.RelPath, err)
+		repoData, err := content.RepoContent(rel, homeData, dst)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[submit] Failed to convert %s for the repo: %v\n", info.RelPath, err)
 			continue
 		}
-		fmt.Printf("[submit] Copied %s\n", info.RelPath)
+		pending[info.RelPath] = pendingWrite{dst: dst, data: repoData, mode: userStat.Mode().Perm(), copied: "Copied"}
 	}
 
-	// Stage all files (some may not exist in $HOME, but are tracked/uncommitted)
-	git.SetVerbose(verbose)
-	stagePaths := make([]string, 0, len(allRelPaths))
+	var staged []string
 	for _, rel := range allRelPaths {
-		stagePaths = append(stagePaths, filepath.Join("home", rel))
+		if _, ok := selectedSet[rel]; ok {
+			staged = append(staged, rel)
+		}
+	}
+	allRelPaths = staged
+	if len(allRelPaths) == 0 {
+		fmt.Println("[submit] Nothing staged. Aborting.")
+		return nil
 	}
-	if err := git.Add(repoDir, stagePaths); err != nil {
-		fmt.Fprintf(os.Stderr, "[submit] Failed to stage files: %v\n", err)
-		os.Exit(1)
+	stagePaths := make([]string, 0, len(allRelPaths))
+	for _, rel := range allRelPaths {
+		if _, ok := repoLevel[rel]; ok {
+			stagePaths = append(stagePaths, rel)
+			continue
+		}
+		stagePaths = append(stagePaths, mustRepoRel(repoDir, repoPaths[rel]))
 	}
 
-	fmt.Println("Prepare commit message (Esc accepts default):")
-	commitMsg, err := promptCommitMessage("Update dotfiles")
+	// Scan everything about to be committed for secrets before touching the repo.
+	scanner, err := dotman.SecretScanner()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "[submit] Failed to read commit message: %v\n", err)
-		os.Exit(1)
+		fmt.Fprintln(os.Stderr, err)
+		return errReported
+	}
+	if err := scanner.Allow(allowSecrets...); err != nil {
+		fmt.Fprintf(os.Stderr, "[submit] %v\n", err)
+		return errReported
 	}
-	if err := git.Commit(repoHome, commitMsg); err != nil {
-		fmt.Fprintf(os.Stderr, "[submit] Failed to commit: %v\n", err)
-		os.Exit(1)
+	var findings []services.SecretFinding
+	for i, rel := range allRelPaths {
+		if _, ok := deleted[rel]; ok {
+			continue
+		}
+		var data []byte
+		w, ok := pending[rel]
+		if ok {
+			data = w.data
+		}
+		found, err := scanStaged(scanner, repoDir, stagePaths[i], data, ok)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[submit] Failed to scan %s for secrets: %v\n", stagePaths[i], err)
+			return errReported
+		}
+		findings = append(findings, found...)
 	}
-	fmt.Printf("[submit] Committed %d file(s).\n", len(allRelPaths))
+	if len(findings) > 0 {
+		fmt.Fprintln(os.Stderr, "[submit] Possible secrets found; nothing was committed:")
+		for _, f := range findings {
+			fmt.Fprintf(os.Stderr, "  %s: %s (%s)\n", f.Location(), f.Rule, f.Match)
+		}
+		fmt.Fprintf(os.Stderr, "Remove them, encrypt the file with 'dotman add --encrypt', or allow a finding with --allow-secret <path:line> or an entry in %s.\n", services.SecretAllowFileName)
+		return errReported
+	}
+
+	var copied []string
+	for _, rel := range allRelPaths {
+		w, ok := pending[rel]
+		if !ok {
+			continue
+		}
+		if err := fs.MkdirAll(filepath.Dir(w.dst), 0755); err != nil {
+			fmt.Fprintf(os.Stderr, "[submit] Failed to create directory for %s: %v\n", w.dst, err)
+			return errReported
+		}
+		if err := fs.WriteFile(w.dst, w.data, w.mode); err != nil {
+			fmt.Fprintf(os.Stderr, "[submit] Failed to write %s: %v\n", w.dst, err)
+			return errReported
+		}
+		if w.copied != "" {
+			fmt.Printf("[submit] %s %s\n", w.copied, rel)
+		}
+		copied = append(copied, rel)
+	}
+	persistContent(content, "submit")
+
+	// Stage all files (some may not exist in $HOME, but are tracked/uncommitted).
+	// Deleted files, and the old path of renamed ones, are staged as removals.
+	var addPaths, removePaths []string
+	for i, rel := range allRelPaths {
+		if _, ok := deleted[rel]; ok {
+			removePaths = append(removePaths, stagePaths[i])
+			continue
+		}
+		addPaths = append(addPaths, stagePaths[i])
+		if from, ok := renamedFrom[rel]; ok {
+			removePaths = append(removePaths, from)
+		}
+	}
+	git.SetVerbose(verbose)
+	if err := git.Add(repoDir, addPaths); err != nil {
+		reportGitError("submit", "Staging files", err)
+		return errReported
+	}
+	if err := git.Remove(repoDir, removePaths); err != nil {
+		reportGitError("submit", "Staging removed files", err)
+		return errReported
+	}
+
+	if err := commitSubmission(scan, dotman, git, manifest, layers, repoDir, len(allRelPaths)); err != nil {
+		return err
+	}
+	for _, rel := range copied {
+		data, _, err := content.HomeContent(rel, repoPaths[rel])
+		if err == nil {
+			err = state.RecordContent(rel, data)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[submit] Failed to record sync state for %s: %v\n", rel, err)
+		}
+	}
+	for _, rel := range allRelPaths {
+		if _, ok := deletedHome[rel]; ok {
+			state.Forget(rel)
+		}
+	}
+	recordInSync(state, inSync, "submit")
+	saveState(state, "submit")
 	if publish {
 		publishCmd.Flags().Set("no-pull", "false")
 		if verbose {
@@ -347,4 +697,138 @@ func runSubmit(cmd *cobra.Command, args []string, dotman *services.DotmanService
 		}
 		publishCmd.Run(cmd, args)
 	}
+	return nil
+}
+
+// submitHunks offers the differences between the repo copy of rel (dst) and
+// the home copy (src) hunk by hunk and returns the repo content holding only the
+// staged hunks. Hidden (secret) files are offered as a whole instead. ok is
+// false when nothing was staged; quit is set when the user stops early.
+func submitHunks(scan *bufio.Scanner, content *services.ContentService, rel, dst, src string, hidden bool, quit *bool) (w pendingWrite, ok bool) {
+	userStat, err := os.Stat(src)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[submit] Skipping %s (missing in $HOME)\n", rel)
+		return w, false
+	}
+	homeContent, err := os.ReadFile(src)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[submit] Failed to read %s: %v\n", src, err)
+		return w, false
+	}
+	repoContent, _, err := content.HomeContent(rel, dst)
+	if err != nil && !os.IsNotExist(err) {
+		fmt.Fprintf(os.Stderr, "[submit] Failed to read %s: %v\n", dst, err)
+		return w, false
+	}
+	a := services.SplitLines(string(repoContent))
+	b := services.SplitLines(string(homeContent))
+	hunks := services.ComputeHunks(a, b, 3)
+	if len(hunks) == 0 {
+		return w, false
+	}
+
+	var accept []bool
+	if hidden {
+		// Hunks would print decrypted lines, so offer the file as a whole.
+		whole := confirm(scan, fmt.Sprintf("\n[submit] %s holds secrets; contents hidden. Stage it? [y/N]: ", rel))
+		accept = make([]bool, len(hunks))
+		for i := range accept {
+			accept[i] = whole
+		}
+	} else {
+		fmt.Printf("\n[submit] %s\n", rel)
+		var stop bool
+		accept, stop = selectHunks(scan, hunks, "Stage this hunk", editHunkLines(b))
+		*quit = stop
+	}
+	n := countAccepted(accept)
+	if n == 0 {
+		return w, false
+	}
+	patched := strings.Join(services.ApplyHunks(a, b, hunks, accept), "")
+	repoData, err := content.RepoContent(rel, []byte(patched), dst)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[submit] Failed to convert %s for the repo: %v\n", rel, err)
+		return w, false
+	}
+	fmt.Printf("[submit] Staged %d of %d hunk(s) of %s\n", n, len(hunks), rel)
+	return pendingWrite{dst: dst, data: repoData, mode: userStat.Mode().Perm()}, true
+}
+
+// pendingWrite is repo content converted from $HOME, written to dst once the
+// secret scan has passed. copied, when set, is reported as it is written.
+type pendingWrite struct {
+	dst    string
+	data   []byte
+	mode   os.FileMode
+	copied string
+}
+
+// scanStaged scans the file staged at stagePath (relative to repoDir) for
+// secrets. Pending content is scanned when given; otherwise the file on disk
+// is, walking directories and skipping files that were deleted.
+func scanStaged(scanner *services.SecretScanner, repoDir, stagePath string, pending []byte, isPending bool) ([]services.SecretFinding, error) {
+	if isPending {
+		return scanner.Scan(stagePath, pending), nil
+	}
+	var findings []services.SecretFinding
+	root := filepath.Join(repoDir, filepath.FromSlash(strings.TrimSuffix(stagePath, "/")))
+	err := filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		findings = append(findings, scanner.Scan(mustRepoRel(repoDir, path), data)...)
+		return nil
+	})
+	return findings, err
+}
+
+// mustRepoRel returns path relative to the repo root, for staging.
+func mustRepoRel(repoDir, path string) string {
+	rel, err := filepath.Rel(repoDir, path)
+	if err != nil {
+		return path
+	}
+	return rel
+}
+
+// editTemplateSource explains that rel is rendered from the template at
+// repoPath, shows the source and offers to open it in the editor. It reports
+// whether the template was edited and should be staged.
+func editTemplateSource(scan *bufio.Scanner, rel, repoPath string) bool {
+	source, err := os.ReadFile(repoPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[submit] Failed to read template %s: %v\n", repoPath, err)
+		return false
+	}
+	fmt.Printf("\n[submit] %s is rendered from a template, so your edits cannot be copied back.\n", rel)
+	fmt.Printf("[submit] Make the change in the template source instead: %s\n", repoPath)
+	for i, line := range strings.Split(strings.TrimSuffix(string(source), "\n"), "\n") {
+		fmt.Printf("%4d  %s\n", i+1, line)
+	}
+	if !confirm(scan, "Open the template in your editor now? [y/N]: ") {
+		fmt.Printf("[submit] Skipped %s.\n", rel)
+		return false
+	}
+	if err := openEditor(repoPath); err != nil {
+		fmt.Fprintf(os.Stderr, "[submit] Editor failed: %v\n", err)
+		return false
+	}
+	return true
 }
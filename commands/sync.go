@@ -0,0 +1,364 @@
+package commands
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"dotman/services"
+	"dotman/types"
+
+	"github.com/spf13/cobra"
+)
+
+func NewSyncCommand(dotman *services.DotmanService, git services.Git, fs *services.FileService, state *services.StateService, backups *services.BackupService) *cobra.Command {
+	var dryRun bool
+	var yes bool
+	var noPush bool
+	var showSecrets bool
+	var allowSecrets []string
+	cmd := &cobra.Command{
+		Use:   "sync",
+		Short: "Apply, submit and publish in one go",
+		Long: `Bring home and the repo up to date with each other and with the remote.
+
+sync fetches once and rebases your local commits onto the remote's, then sorts
+every tracked file by the side it changed on. Repo-side changes are applied to
+$HOME, home-side changes are copied into the repo and committed together with
+a generated message, and the result is pushed. Nothing is asked unless a file
+changed on both sides or your commits conflict with the remote's.
+
+Home-side changes are scanned for secrets like 'dotman submit' does; a finding
+stops the commit unless it is allowed with --allow-secret <path:line>. Files
+rendered from templates are not copied back.
+
+With --yes nothing is asked at all, and sync refuses to continue when there is
+a conflict to resolve. This suits cron jobs and login scripts.
+
+With --dry-run nothing is fetched either; the repo is compared with the remote
+as of the last fetch.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			exitOnError(runSync(cmd, dotman, git, fs, state, backups, dryRun, yes, noPush, showSecrets, allowSecrets))
+		},
+	}
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Show what would be synced without changing anything")
+	cmd.Flags().BoolVarP(&yes, "yes", "y", false, "Never prompt; refuse to continue when there are conflicts")
+	cmd.Flags().BoolVar(&noPush, "no-push", false, "Commit home-side changes but do not push")
+	cmd.Flags().BoolVar(&showSecrets, "show-secrets", false, "Show the decrypted contents of encrypted files in diffs")
+	cmd.Flags().StringArrayVar(&allowSecrets, "allow-secret", nil, "Commit despite a secret found at `path:line` (repeatable)")
+	return cmd
+}
+
+func runSync(cmd *cobra.Command, dotman *services.DotmanService, git services.Git, fs *services.FileService, state *services.StateService, backups *services.BackupService, dryRun, yes, noPush, showSecrets bool, allowSecrets []string) error {
+	repoDir, err := dotman.IsInitialized()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return errReported
+	}
+	if err := dotman.RequireWritable("sync"); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return errReported
+	}
+	repoHome, err := dotman.GetHomeDir()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return errReported
+	}
+	scan := bufio.NewScanner(cmd.InOrStdin())
+
+	// 1. Fetch once and put the local commits on top of the remote's. A dry
+	// run leaves the remote refs alone and uses those of the last fetch.
+	if !dryRun {
+		if err := git.Fetch(repoDir); err != nil {
+			reportGitError("sync", "Fetch", err)
+			return errReported
+		}
+	}
+	upstream := true
+	ahead, behind, err := git.AheadBehind(repoDir)
+	switch {
+	case errors.Is(err, services.ErrNoUpstream):
+		upstream = false
+		fmt.Println("[sync] The repo has no upstream branch; changes are committed locally only.")
+	case err != nil:
+		reportGitError("sync", "Comparing with the remote", err)
+		return errReported
+	case dryRun:
+		fmt.Printf("[sync] As of the last fetch: %d commit(s) to pull, %d to push.\n", behind, ahead)
+	default:
+		fmt.Printf("[sync] Fetched: %d commit(s) to pull, %d to push.\n", behind, ahead)
+	}
+	if behind > 0 {
+		if dryRun {
+			fmt.Printf("[sync] Dry run: would rebase onto %d remote commit(s); the files below do not include them yet.\n", behind)
+		} else if err := syncRebase(scan, git, repoDir, yes, showSecrets); err != nil {
+			return err
+		} else if ahead, _, err = git.AheadBehind(repoDir); err != nil {
+			reportGitError("sync", "Comparing with the remote", err)
+			return errReported
+		}
+	}
+
+	// 2. Sort every tracked file by the side it changed on.
+	if err := state.Load(); err != nil {
+		fmt.Fprintf(os.Stderr, "[sync] Failed to load sync state: %v\n", err)
+		return errReported
+	}
+	content, err := dotman.Content()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return errReported
+	}
+	userHome := fs.HomeDir()
+	changed, created, inSync, err := scanFiles(dotman, fs, state, content, repoHome, userHome, nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[sync] Error scanning files: %v\n", err)
+		return errReported
+	}
+	changed = skipUnreadable("sync", changed)
+	toRepo, toHome, conflicts := splitSync(changed)
+	toHome = append(created, toHome...)
+	toHome = skipUnwritable(fs, toHome)
+	conflicts = skipUnwritable(fs, conflicts)
+	listSync("To home", toHome)
+	listSync("To repo", toRepo)
+	listSync("Changed on both sides", conflicts)
+
+	if dryRun {
+		fmt.Println("[sync] Dry run: nothing was changed.")
+		return nil
+	}
+	if len(conflicts) > 0 && yes {
+		fmt.Fprintf(os.Stderr, "[sync] Refusing to sync with %d file(s) changed on both sides; nothing was changed in $HOME. Run 'dotman sync' without --yes to resolve them.\n", len(conflicts))
+		return errReported
+	}
+
+	backup := backups.Begin("sync")
+	recordInSync(state, inSync, "sync")
+	defer saveState(state, "sync")
+	defer persistContent(content, "sync")
+	defer finishBackup(dotman, backups, backup, "sync")
+
+	// 3. Resolve true conflicts. Keeping or merging the home copy makes it a
+	// home-side change, so the files are sorted again afterwards.
+	if len(conflicts) > 0 {
+		resolveConflicts(scan, "sync", git, fs, state, content, backup, conflicts, userHome, showSecrets)
+		changed, _, inSync, err = scanFiles(dotman, fs, state, content, repoHome, userHome, nil)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[sync] Error scanning files: %v\n", err)
+			return errReported
+		}
+		recordInSync(state, inSync, "sync")
+		toRepo, _, _ = splitSync(changed)
+	}
+
+	// 4. Apply repo-side changes to $HOME.
+	if len(toHome) > 0 {
+		applyFiles(fs, state, content, backup, toHome, userHome)
+		fmt.Printf("[sync] Applied %d file(s) to your home directory.\n", len(toHome))
+	}
+
+	// 5. Commit home-side changes together.
+	if len(toRepo) > 0 {
+		committed, err := syncCommit(dotman, git, fs, state, content, repoDir, toRepo, allowSecrets)
+		if err != nil {
+			return err
+		}
+		if committed {
+			ahead++
+		}
+	}
+
+	// 6. Push.
+	switch {
+	case !upstream || ahead == 0:
+	case noPush:
+		fmt.Printf("[sync] Not pushing %d commit(s) (--no-push).\n", ahead)
+	default:
+		if _, err := git.Push(repoDir); err != nil {
+			reportGitError("sync", "Push", err)
+			return errReported
+		}
+		fmt.Printf("[sync] Pushed %d commit(s).\n", ahead)
+	}
+	if len(toHome) == 0 && len(toRepo) == 0 && len(conflicts) == 0 && ahead == 0 {
+		fmt.Println("[sync] Everything is in sync.")
+	}
+	return nil
+}
+
+// syncRebase rebases the local commits in repoDir onto the fetched upstream.
+// Conflicts are resolved interactively, or undone with yes.
+func syncRebase(scan *bufio.Scanner, git services.Git, repoDir string, yes, showSecrets bool) error {
+	err := git.Rebase(repoDir, "@{upstream}")
+	switch {
+	case err == nil:
+		fmt.Println("[sync] Rebased onto the remote commits.")
+	case errors.Is(err, services.ErrRebaseConflict) && yes:
+		if abortErr := git.RebaseAbort(repoDir); abortErr != nil {
+			reportGitError("sync", "Aborting the rebase", abortErr)
+		}
+		fmt.Fprintln(os.Stderr, "[sync] Your local commits conflict with the remote; the rebase was undone and nothing was changed. Run 'dotman sync' without --yes to resolve them.")
+		return errReported
+	case errors.Is(err, services.ErrRebaseConflict):
+		if !resolveRebase(scan, git, "sync", repoDir, showSecrets) {
+			return errReported
+		}
+	default:
+		reportGitError("sync", "Rebase", err)
+		return errReported
+	}
+	return nil
+}
+
+// splitSync sorts changed files by the side they changed on.
+func splitSync(changed []types.FileDiff) (toRepo, toHome, conflicts []types.FileDiff) {
+	for _, info := range changed {
+		switch info.Direction {
+		case types.RepoChanged:
+			toHome = append(toHome, info)
+		case types.HomeChanged:
+			toRepo = append(toRepo, info)
+		case types.BothChanged:
+			conflicts = append(conflicts, info)
+		}
+	}
+	return toRepo, toHome, conflicts
+}
+
+func listSync(title string, files []types.FileDiff) {
+	if len(files) == 0 {
+		return
+	}
+	fmt.Printf("[sync] %s:\n", title)
+	for _, info := range files {
+		fmt.Printf("  - %s\n", info.RelPath)
+	}
+}
+
+// syncCommit copies the home-side changes in files into the repo and commits
+// them with a generated message; files deleted from $HOME are removed from the
+// repo. Nothing is written when a secret is found. It reports whether a commit
+// was made.
+func syncCommit(dotman *services.DotmanService, git services.Git, fs *services.FileService, state *services.StateService, content *services.ContentService, repoDir string, files []types.FileDiff, allowSecrets []string) (bool, error) {
+	scanner, err := dotman.SecretScanner()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return false, errReported
+	}
+	if err := scanner.Allow(allowSecrets...); err != nil {
+		fmt.Fprintf(os.Stderr, "[sync] %v\n", err)
+		return false, errReported
+	}
+	var pending []pendingWrite
+	var rels, stagePaths, removed, removePaths []string
+	var findings []services.SecretFinding
+	for _, info := range files {
+		rel := info.RelPath
+		if info.Filtered && !content.Reversible(rel, info.RepoPath) {
+			fmt.Printf("[sync] Skipping %s: it is rendered from a template; make the change in %s.\n", rel, info.RepoPath)
+			continue
+		}
+		if info.UserHash == "missing" {
+			removed = append(removed, rel)
+			removePaths = append(removePaths, filepath.ToSlash(mustRepoRel(repoDir, info.RepoPath)))
+			continue
+		}
+		userStat, err := os.Stat(info.HomePath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[sync] Skipping %s (missing in $HOME)\n", rel)
+			continue
+		}
+		homeData, err := os.ReadFile(info.HomePath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[sync] Failed to read %s: %v\n", info.HomePath, err)
+			continue
+		}
+		if hasConflictMarkers(homeData) {
+			fmt.Printf("[sync] Skipping %s: it still has conflict markers.\n", rel)
+			continue
+		}
+		repoData, err := content.RepoContent(rel, homeData, info.RepoPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[sync] Failed to convert %s for the repo: %v\n", rel, err)
+			continue
+		}
+		stagePath := filepath.ToSlash(mustRepoRel(repoDir, info.RepoPath))
+		findings = append(findings, scanner.Scan(stagePath, repoData)...)
+		pending = append(pending, pendingWrite{dst: info.RepoPath, data: repoData, mode: userStat.Mode().Perm()})
+		rels = append(rels, rel)
+		stagePaths = append(stagePaths, stagePath)
+	}
+	if len(findings) > 0 {
+		fmt.Fprintln(os.Stderr, "[sync] Possible secrets found in home-side changes; nothing was committed:")
+		for _, f := range findings {
+			fmt.Fprintf(os.Stderr, "  %s: %s (%s)\n", f.Location(), f.Rule, f.Match)
+		}
+		fmt.Fprintf(os.Stderr, "Remove them, encrypt the file with 'dotman add --encrypt', or allow a finding with --allow-secret <path:line> or an entry in %s.\n", services.SecretAllowFileName)
+		return false, errReported
+	}
+	if len(pending) == 0 && len(removed) == 0 {
+		return false, nil
+	}
+
+	for _, w := range pending {
+		if err := fs.MkdirAll(filepath.Dir(w.dst), 0755); err != nil {
+			fmt.Fprintf(os.Stderr, "[sync] Failed to create directory for %s: %v\n", w.dst, err)
+			return false, errReported
+		}
+		if err := fs.WriteFile(w.dst, w.data, w.mode); err != nil {
+			fmt.Fprintf(os.Stderr, "[sync] Failed to write %s: %v\n", w.dst, err)
+			return false, errReported
+		}
+	}
+	if err := git.Add(repoDir, stagePaths); err != nil {
+		reportGitError("sync", "Staging files", err)
+		return false, errReported
+	}
+	if err := git.Remove(repoDir, removePaths); err != nil {
+		reportGitError("sync", "Staging removed files", err)
+		return false, errReported
+	}
+	manifest, err := dotman.Manifest()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return false, errReported
+	}
+	layers, err := dotman.HomeLayers()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return false, errReported
+	}
+	message, err := submitCommitMessage(dotman, git, manifest, layers, repoDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[sync] Failed to prepare the commit message: %v\n", err)
+		return false, errReported
+	}
+	committed := false
+	if message != "" {
+		switch err := git.Commit(repoDir, message); {
+		case errors.Is(err, services.ErrNothingToCommit):
+		case err != nil:
+			reportGitError("sync", "Commit", err)
+			return false, errReported
+		default:
+			committed = true
+			fmt.Printf("[sync] Committed %d file(s) from your home directory.\n", len(pending)+len(removed))
+		}
+	}
+	for i, rel := range rels {
+		data, _, err := content.HomeContent(rel, pending[i].dst)
+		if err == nil {
+			err = state.RecordContent(rel, data)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[sync] Failed to record sync state for %s: %v\n", rel, err)
+		}
+	}
+	for _, rel := range removed {
+		state.Forget(rel)
+	}
+	return committed, nil
+}
@@ -84,11 +84,22 @@ type FilePair struct {
 	Label     string
 	LeftPath  string
 	RightPath string
+	// LeftContent, when non-nil, is shown instead of the contents of LeftPath
+	// (e.g. a rendered template).
+	LeftContent []byte
+	// RightContent, when non-nil, is shown instead of the contents of
+	// RightPath (e.g. one side of a merge conflict).
+	RightContent []byte
+	// Sensitive pairs (e.g. decrypted secrets) are only rendered when the
+	// renderer's ShowSensitive is set.
+	Sensitive bool
 }
 
 // Renderer renders side-by-side panels to plain text with ANSI styling.
 type Renderer struct {
 	Theme Theme
+	// ShowSensitive allows the contents of sensitive pairs to be printed.
+	ShowSensitive bool
 }
 
 // NewRenderer returns a Renderer with default colors.
@@ -282,8 +293,12 @@ func (r *Renderer) renderPanel(label, leftText, rightText string, idx, total int
 func (r *Renderer) RenderFiles(pairs []FilePair, highlightDiffLines bool) ([]string, error) {
 	var results []string
 	for i, pair := range pairs {
-		leftOk := fileExists(pair.LeftPath)
-		rightOk := fileExists(pair.RightPath)
+		if pair.Sensitive && !r.ShowSensitive {
+			results = append(results, fmt.Sprintf("\x1b[33m    (Secret File): %s (contents hidden, use --show-secrets to display)\x1b[0m", pair.Label))
+			continue
+		}
+		leftOk := pair.LeftContent != nil || fileExists(pair.LeftPath)
+		rightOk := pair.RightContent != nil || fileExists(pair.RightPath)
 		if !leftOk || !rightOk {
 			// If either side is missing, don't show a full diff panel.
 			// Emit a concise diff-like status line instead.
@@ -298,8 +313,16 @@ func (r *Renderer) RenderFiles(pairs []FilePair, highlightDiffLines bool) ([]str
 			continue
 		}
 
-		leftRaw := strings.ReplaceAll(r.readOrMsg(pair.LeftPath), "\r\n", "\n")
-		rightRaw := strings.ReplaceAll(r.readOrMsg(pair.RightPath), "\r\n", "\n")
+		left := string(pair.LeftContent)
+		if pair.LeftContent == nil {
+			left = r.readOrMsg(pair.LeftPath)
+		}
+		leftRaw := strings.ReplaceAll(left, "\r\n", "\n")
+		right := string(pair.RightContent)
+		if pair.RightContent == nil {
+			right = r.readOrMsg(pair.RightPath)
+		}
+		rightRaw := strings.ReplaceAll(right, "\r\n", "\n")
 
 		leftLines := strings.Split(leftRaw, "\n")
 		rightLines := strings.Split(rightRaw, "\n")
@@ -25,28 +25,49 @@ func main() {
 	}
 
 	rootCmd.AddCommand(&cobra.Command{
-		Use:   "version",
-		Short: "Print version information",
+		Use:         "version",
+		Short:       "Print version information",
+		Annotations: map[string]string{commands.AllowDuringRebase: ""},
 		Run: func(cmd *cobra.Command, args []string) {
 			fmt.Printf("dotman %s (commit: %s, built: %s)\n", version, commit, date)
 		},
 	})
 
 	// Register all subcommands directly
+	services.Version = version
 	dotman := services.NewDotmanService()
 	fs := services.NewFileService()
 	git := services.NewGitService()
 	cfg := services.NewConfigService()
+	state := services.NewStateService()
+	backups := services.NewBackupService()
+
+	// A read-only machine never commits or pushes, whichever command runs.
+	rootCmd.PersistentPreRun = func(cmd *cobra.Command, args []string) {
+		git.SetReadOnly(dotman.ReadOnly())
+		if err := commands.CheckRebase(cmd, dotman, git); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
 
 	commandList := make(map[string]*cobra.Command)
 	commandList["init"] = commands.NewInitCommand(dotman, git, cfg)
 	commandList["bootstrap"] = commands.NewBootstrapCommand(dotman, fs)
-	commandList["apply"] = commands.NewApplyCommand(dotman, git, fs)
+	commandList["apply"] = commands.NewApplyCommand(dotman, git, fs, state, backups)
 	commandList["publish"] = commands.NewPublishCommand(dotman, git)
-	commandList["submit"] = commands.NewSubmitCommand(dotman, git, commandList["publish"], fs)
+	commandList["submit"] = commands.NewSubmitCommand(dotman, git, commandList["publish"], fs, state)
 	commandList["add"] = commands.NewAddCommand(dotman, fs)
 	commandList["config"] = commands.NewConfigCommand(cfg)
 	commandList["show"] = commands.NewShowCommand(dotman, fs)
+	commandList["status"] = commands.NewStatusCommand(dotman, git, fs, state)
+	commandList["check-ignore"] = commands.NewCheckIgnoreCommand(dotman, fs)
+	commandList["forget"] = commands.NewForgetCommand(dotman, git, fs, state, backups)
+	commandList["restore"] = commands.NewRestoreCommand(dotman, fs, backups)
+	commandList["resolve"] = commands.NewResolveCommand(dotman, git)
+	commandList["log"] = commands.NewLogCommand(dotman, git, fs)
+	commandList["checkout"] = commands.NewCheckoutCommand(dotman, git, fs, backups)
+	commandList["sync"] = commands.NewSyncCommand(dotman, git, fs, state, backups)
 
 	rootCmd.AddCommand(
 		commandList["init"],
@@ -57,6 +78,14 @@ func main() {
 		commandList["add"],
 		commandList["config"],
 		commandList["show"],
+		commandList["status"],
+		commandList["check-ignore"],
+		commandList["forget"],
+		commandList["restore"],
+		commandList["resolve"],
+		commandList["log"],
+		commandList["checkout"],
+		commandList["sync"],
 	)
 
 	if err := rootCmd.Execute(); err != nil {
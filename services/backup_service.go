@@ -0,0 +1,285 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const backupManifestName = "manifest.json"
+const backupIDFormat = "20060102-150405"
+
+// BackupEntry describes one home file saved in a backup set.
+type BackupEntry struct {
+	Path   string      `json:"path"`
+	Action string      `json:"action"`
+	Mode   os.FileMode `json:"mode"`
+}
+
+type BackupManifest struct {
+	Created string        `json:"created"`
+	Command string        `json:"command"`
+	Files   []BackupEntry `json:"files"`
+}
+
+// BackupSet is a timestamped directory holding copies of home files taken
+// before dotman overwrote or deleted them, plus a manifest describing them.
+type BackupSet struct {
+	ID       string
+	Dir      string
+	Manifest BackupManifest
+	created  bool
+}
+
+// BackupService manages backup sets under $XDG_STATE_HOME/dotman/backups.
+type BackupService struct {
+	dir string
+}
+
+func NewBackupService() *BackupService {
+	return &BackupService{dir: filepath.Join(StateDir(), "backups")}
+}
+
+// Dir returns the directory backup sets are kept in.
+func (b *BackupService) Dir() string {
+	return b.dir
+}
+
+// Begin starts a new backup set for command. Nothing is written to disk until
+// the first file is saved, so commands that end up changing nothing leave no
+// empty sets behind.
+func (b *BackupService) Begin(command string) *BackupSet {
+	now := time.Now()
+	id := now.Format(backupIDFormat)
+	for i := 2; ; i++ {
+		if _, err := os.Stat(filepath.Join(b.dir, id)); os.IsNotExist(err) {
+			break
+		}
+		id = fmt.Sprintf("%s-%d", now.Format(backupIDFormat), i)
+	}
+	return &BackupSet{
+		ID:  id,
+		Dir: filepath.Join(b.dir, id),
+		Manifest: BackupManifest{
+			Created: now.Format(time.RFC3339),
+			Command: command,
+		},
+	}
+}
+
+// Save copies rel (a file or directory inside homeDir) into the set before it
+// is overwritten or deleted. An absolute rel is a file outside homeDir, kept
+// by its full path. Missing paths are skipped.
+func (s *BackupSet) Save(homeDir, rel, action string) error {
+	src := filepath.Join(homeDir, rel)
+	if filepath.IsAbs(rel) {
+		src = rel
+	}
+	if _, err := os.Lstat(src); os.IsNotExist(err) {
+		return nil
+	}
+	fs := NewFileService()
+	err := filepath.Walk(src, func(path string, info os.FileInfo, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		fileRel := path
+		if !filepath.IsAbs(rel) {
+			fileRel = mustRel(homeDir, path)
+		}
+		dest := s.filePath(filepath.ToSlash(fileRel))
+		if err := fs.MkdirAll(filepath.Dir(dest), 0700); err != nil {
+			return err
+		}
+		if err := fs.CopyFileWith(path, dest, CopyOptions{Perm: info.Mode().Perm(), PreserveModTime: true}); err != nil {
+			return err
+		}
+		s.Manifest.Files = append(s.Manifest.Files, BackupEntry{
+			Path:   filepath.ToSlash(fileRel),
+			Action: action,
+			Mode:   info.Mode().Perm(),
+		})
+		s.created = true
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if !s.created {
+		return nil
+	}
+	return s.writeManifest()
+}
+
+// filePath returns where the copy of entry path is kept in the set.
+func (s *BackupSet) filePath(path string) string {
+	if rest, ok := strings.CutPrefix(path, "/"); ok {
+		return filepath.Join(s.Dir, "files", "_abs", filepath.FromSlash(rest))
+	}
+	return filepath.Join(s.Dir, "files", filepath.FromSlash(path))
+}
+
+// Empty reports whether nothing has been saved in the set.
+func (s *BackupSet) Empty() bool {
+	return !s.created
+}
+
+func (s *BackupSet) writeManifest() error {
+	bytes, err := json.MarshalIndent(s.Manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(s.Dir, backupManifestName), bytes, 0600)
+}
+
+// List returns all backup sets, newest first.
+func (b *BackupService) List() ([]*BackupSet, error) {
+	entries, err := os.ReadDir(b.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var sets []*BackupSet
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		set, err := b.Get(e.Name())
+		if err != nil {
+			continue
+		}
+		sets = append(sets, set)
+	}
+	sort.Slice(sets, func(i, j int) bool {
+		return sets[i].ID > sets[j].ID
+	})
+	return sets, nil
+}
+
+// Get loads the backup set with the given id; "latest" names the newest set.
+func (b *BackupService) Get(id string) (*BackupSet, error) {
+	if id == "latest" {
+		sets, err := b.List()
+		if err != nil {
+			return nil, err
+		}
+		if len(sets) == 0 {
+			return nil, fmt.Errorf("no backups found in %s", b.dir)
+		}
+		return sets[0], nil
+	}
+	dir := filepath.Join(b.dir, id)
+	bytes, err := os.ReadFile(filepath.Join(dir, backupManifestName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no backup set named %s", id)
+		}
+		return nil, err
+	}
+	set := &BackupSet{ID: id, Dir: dir, created: true}
+	if err := json.Unmarshal(bytes, &set.Manifest); err != nil {
+		return nil, fmt.Errorf("invalid backup manifest in %s: %w", dir, err)
+	}
+	return set, nil
+}
+
+// Restore copies files from set back into homeDir, and files saved by absolute
+// path back to that path. When paths is non-empty only entries equal to, or
+// beneath, one of the paths are restored. Returns the restored paths relative
+// to homeDir, or absolute.
+func (b *BackupService) Restore(set *BackupSet, homeDir string, paths []string) ([]string, error) {
+	fs := NewFileService()
+	var restored []string
+	for _, entry := range set.Manifest.Files {
+		if !PathWithin(entry.Path, paths) {
+			continue
+		}
+		src := set.filePath(entry.Path)
+		dst := filepath.Join(homeDir, filepath.FromSlash(entry.Path))
+		if filepath.IsAbs(filepath.FromSlash(entry.Path)) {
+			dst = filepath.FromSlash(entry.Path)
+		}
+		if err := fs.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return restored, err
+		}
+		if err := fs.CopyFileWith(src, dst, CopyOptions{Perm: entry.Mode, PreserveModTime: true}); err != nil {
+			return restored, err
+		}
+		restored = append(restored, entry.Path)
+	}
+	return restored, nil
+}
+
+// PathWithin reports whether path (relative to the home tree, or absolute for
+// files outside $HOME) equals, or lies beneath, one of filters. Absolute
+// filters only match absolute paths. An empty filter list matches everything.
+func PathWithin(path string, filters []string) bool {
+	if len(filters) == 0 {
+		return true
+	}
+	for _, f := range filters {
+		if filepath.IsAbs(f) {
+			f = strings.TrimRight(filepath.ToSlash(filepath.Clean(f)), "/")
+		} else {
+			f = strings.Trim(filepath.ToSlash(NormalizeRelPath(f)), "/")
+		}
+		if path == f || strings.HasPrefix(path, f+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Prune deletes backup sets beyond the newest keep sets and sets older than
+// maxAge. A zero keep or maxAge disables that limit. Returns the removed ids.
+func (b *BackupService) Prune(keep int, maxAge time.Duration) ([]string, error) {
+	sets, err := b.List()
+	if err != nil {
+		return nil, err
+	}
+	var removed []string
+	for i, set := range sets {
+		expired := false
+		if keep > 0 && i >= keep {
+			expired = true
+		}
+		if maxAge > 0 {
+			if created, err := time.Parse(time.RFC3339, set.Manifest.Created); err == nil && time.Since(created) > maxAge {
+				expired = true
+			}
+		}
+		if !expired {
+			continue
+		}
+		if err := os.RemoveAll(set.Dir); err != nil {
+			return removed, err
+		}
+		removed = append(removed, set.ID)
+	}
+	return removed, nil
+}
+
+// ParseAge parses a duration that may also use a "d" (days) suffix, e.g. "30d".
+func ParseAge(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid age %q", value)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(value)
+}
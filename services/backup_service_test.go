@@ -0,0 +1,133 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBackupService_SaveAndRestore(t *testing.T) {
+	t.Parallel()
+
+	home := t.TempDir()
+	backups := &BackupService{dir: t.TempDir()}
+	os.MkdirAll(filepath.Join(home, ".config", "nvim"), 0755)
+	writeFile(t, filepath.Join(home, ".zshrc"), "original zshrc")
+	writeFile(t, filepath.Join(home, ".config", "nvim", "init.lua"), "original init")
+
+	set := backups.Begin("apply")
+	if !set.Empty() {
+		t.Fatalf("expected a new set to be empty")
+	}
+	if err := set.Save(home, ".zshrc", "overwrite"); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if err := set.Save(home, ".config/nvim", "delete"); err != nil {
+		t.Fatalf("Save dir: %v", err)
+	}
+	if err := set.Save(home, ".missing", "overwrite"); err != nil {
+		t.Fatalf("Save missing: %v", err)
+	}
+
+	writeFile(t, filepath.Join(home, ".zshrc"), "overwritten")
+	os.RemoveAll(filepath.Join(home, ".config"))
+
+	loaded, err := backups.Get("latest")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if len(loaded.Manifest.Files) != 2 {
+		t.Fatalf("expected 2 entries, got %+v", loaded.Manifest.Files)
+	}
+	restored, err := backups.Restore(loaded, home, []string{".zshrc"})
+	if err != nil || len(restored) != 1 {
+		t.Fatalf("Restore .zshrc: %v %v", restored, err)
+	}
+	if content, _ := os.ReadFile(filepath.Join(home, ".zshrc")); string(content) != "original zshrc" {
+		t.Fatalf("unexpected .zshrc content %q", content)
+	}
+	if _, err := os.Stat(filepath.Join(home, ".config", "nvim", "init.lua")); !os.IsNotExist(err) {
+		t.Fatalf("expected unrelated paths to stay untouched")
+	}
+	if _, err := backups.Restore(loaded, home, nil); err != nil {
+		t.Fatalf("Restore all: %v", err)
+	}
+	if content, _ := os.ReadFile(filepath.Join(home, ".config", "nvim", "init.lua")); string(content) != "original init" {
+		t.Fatalf("unexpected init.lua content %q", content)
+	}
+}
+
+func TestBackupService_Prune(t *testing.T) {
+	t.Parallel()
+
+	home := t.TempDir()
+	backups := &BackupService{dir: t.TempDir()}
+	writeFile(t, filepath.Join(home, ".zshrc"), "x")
+	for i := 0; i < 3; i++ {
+		if err := backups.Begin("apply").Save(home, ".zshrc", "overwrite"); err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+	}
+	removed, err := backups.Prune(1, 0)
+	if err != nil {
+		t.Fatalf("Prune: %v", err)
+	}
+	if len(removed) != 2 {
+		t.Fatalf("expected 2 sets pruned, got %v", removed)
+	}
+	sets, _ := backups.List()
+	if len(sets) != 1 {
+		t.Fatalf("expected 1 set left, got %d", len(sets))
+	}
+}
+
+func TestBackupService_RestoreOutsideHome(t *testing.T) {
+	t.Parallel()
+
+	home := t.TempDir()
+	etc := t.TempDir()
+	hosts := filepath.Join(etc, "hosts")
+	backups := &BackupService{dir: t.TempDir()}
+	writeFile(t, filepath.Join(home, ".zshrc"), "original zshrc")
+	writeFile(t, hosts, "127.0.0.1 localhost\n")
+
+	set := backups.Begin("apply")
+	if err := set.Save(home, ".zshrc", "overwrite"); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if err := set.Save(home, hosts, "overwrite"); err != nil {
+		t.Fatalf("Save absolute: %v", err)
+	}
+	writeFile(t, filepath.Join(home, ".zshrc"), "overwritten")
+	writeFile(t, hosts, "overwritten")
+
+	loaded, err := backups.Get("latest")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	restored, err := backups.Restore(loaded, home, []string{hosts})
+	if err != nil || len(restored) != 1 || restored[0] != filepath.ToSlash(hosts) {
+		t.Fatalf("Restore %s: %v %v", hosts, restored, err)
+	}
+	if content, _ := os.ReadFile(hosts); string(content) != "127.0.0.1 localhost\n" {
+		t.Fatalf("unexpected hosts content %q", content)
+	}
+	if content, _ := os.ReadFile(filepath.Join(home, ".zshrc")); string(content) != "overwritten" {
+		t.Fatalf("expected .zshrc to stay untouched, got %q", content)
+	}
+
+	for _, tt := range []struct {
+		filter string
+		want   bool
+	}{
+		{hosts, true},
+		{etc, true},
+		{etc + "/", true},
+		{hosts + ".bak", false},
+		{".zshrc", false},
+	} {
+		if got := PathWithin(filepath.ToSlash(hosts), []string{tt.filter}); got != tt.want {
+			t.Errorf("PathWithin(%q, %q) = %v, want %v", hosts, tt.filter, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// Version is the dotman version recorded in commit trailers; main sets it
+// from its build flags.
+var Version = "dev"
+
+// Built-in commit subject templates, selected by name with commit.template.
+var commitTemplates = map[string]string{
+	"default":      "{{.Verb}} {{.Summary}}",
+	"conventional": "chore(dotfiles): {{lower .Verb}} {{.Summary}}",
+}
+
+// FileChange is one file in a commit: its path, git's status letter ('A',
+// 'M' or 'D', or 'T' for a type change) and the lines it adds and deletes.
+type FileChange struct {
+	Path    string
+	Status  byte
+	Added   int
+	Deleted int
+	// Binary is set when git does not count lines for the file.
+	Binary bool
+}
+
+// CommitSubject holds what a commit subject template can use.
+type CommitSubject struct {
+	// Verb is "Add" when every file is new, "Remove" when every file is
+	// deleted, and "Update" otherwise.
+	Verb string
+	// Summary names the files, e.g. ".zshrc, nvim/init.lua (+2 more)".
+	Summary string
+	Files   []FileChange
+	Host    string
+}
+
+// ParseCommitTemplate returns the template for a commit.template setting: the
+// name of a built-in style ("default" or "conventional"), or a text/template
+// for the subject line. Empty selects the default style.
+func ParseCommitTemplate(text string) (*template.Template, error) {
+	if text == "" {
+		text = "default"
+	}
+	if builtin, ok := commitTemplates[text]; ok {
+		text = builtin
+	}
+	tmpl, err := template.New("commit").Funcs(template.FuncMap{"lower": strings.ToLower}).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid commit template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// CommitMessage builds the message for committing files: a subject from
+// tmpl, a body listing every file with its diffstat, and trailers naming the
+// host and dotman version.
+func CommitMessage(tmpl *template.Template, files []FileChange) (string, error) {
+	subject := CommitSubject{
+		Verb:    commitVerb(files),
+		Summary: commitSummary(files),
+		Files:   files,
+		Host:    Hostname(),
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, subject); err != nil {
+		return "", fmt.Errorf("commit template: %w", err)
+	}
+	line := strings.TrimSpace(b.String())
+	if line == "" || strings.Contains(line, "\n") {
+		return "", fmt.Errorf("commit template must produce a single line, got %q", line)
+	}
+
+	b.Reset()
+	b.WriteString(line)
+	b.WriteString("\n\n")
+	width := 0
+	for _, f := range files {
+		width = max(width, len(f.Path))
+	}
+	for _, f := range files {
+		stat := fmt.Sprintf("+%d -%d", f.Added, f.Deleted)
+		if f.Binary {
+			stat = "binary"
+		}
+		fmt.Fprintf(&b, "  %c %-*s  %s\n", f.Status, width, f.Path, stat)
+	}
+	b.WriteString("\n")
+	if host := Hostname(); host != "" {
+		fmt.Fprintf(&b, "Dotman-Host: %s\n", host)
+	}
+	fmt.Fprintf(&b, "Dotman-Version: %s\n", Version)
+	return b.String(), nil
+}
+
+func commitVerb(files []FileChange) string {
+	added, deleted := 0, 0
+	for _, f := range files {
+		switch f.Status {
+		case 'A':
+			added++
+		case 'D':
+			deleted++
+		}
+	}
+	switch {
+	case len(files) > 0 && added == len(files):
+		return "Add"
+	case len(files) > 0 && deleted == len(files):
+		return "Remove"
+	default:
+		return "Update"
+	}
+}
+
+// commitSummary names up to three files, shortening paths under .config/ the
+// way they are usually spoken of (nvim/init.lua), and counts the rest.
+func commitSummary(files []FileChange) string {
+	if len(files) == 0 {
+		return "dotfiles"
+	}
+	names := make([]string, 0, 3)
+	for _, f := range files {
+		names = append(names, strings.TrimPrefix(f.Path, ".config/"))
+	}
+	if len(names) <= 3 {
+		return strings.Join(names, ", ")
+	}
+	return fmt.Sprintf("%s (+%d more)", strings.Join(names[:2], ", "), len(names)-2)
+}
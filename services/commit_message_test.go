@@ -0,0 +1,70 @@
+package services
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCommitMessage(t *testing.T) {
+	t.Parallel()
+
+	files := []FileChange{
+		{Path: ".zshrc", Status: 'M', Added: 3, Deleted: 1},
+		{Path: ".config/nvim/init.lua", Status: 'A', Added: 10},
+		{Path: ".gitconfig", Status: 'M', Added: 1, Deleted: 1},
+		{Path: ".local/bin/tool", Status: 'D', Binary: true},
+	}
+	tests := []struct {
+		name     string
+		template string
+		files    []FileChange
+		subject  string
+	}{
+		{name: "default", files: files, subject: "Update .zshrc, nvim/init.lua (+2 more)"},
+		{name: "three_files", files: files[:3], subject: "Update .zshrc, nvim/init.lua, .gitconfig"},
+		{name: "all_added", files: files[1:2], subject: "Add nvim/init.lua"},
+		{name: "all_deleted", files: files[3:], subject: "Remove .local/bin/tool"},
+		{name: "conventional", template: "conventional", files: files[:1], subject: "chore(dotfiles): update .zshrc"},
+		{name: "custom", template: "dotfiles: {{len .Files}} file(s) {{.Summary}}", files: files[:2], subject: "dotfiles: 2 file(s) .zshrc, nvim/init.lua"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			tmpl, err := ParseCommitTemplate(tt.template)
+			if err != nil {
+				t.Fatal(err)
+			}
+			msg, err := CommitMessage(tmpl, tt.files)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if subject, _, _ := strings.Cut(msg, "\n"); subject != tt.subject {
+				t.Errorf("subject = %q, want %q", subject, tt.subject)
+			}
+		})
+	}
+
+	tmpl, _ := ParseCommitTemplate("")
+	msg, err := CommitMessage(tmpl, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"\n\n  M .zshrc                 +3 -1\n",
+		"  A .config/nvim/init.lua  +10 -0\n",
+		"  D .local/bin/tool        binary\n",
+		"\nDotman-Version: " + Version + "\n",
+	} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("message lacks %q:\n%s", want, msg)
+		}
+	}
+
+	if _, err := ParseCommitTemplate("{{.Verb"); err == nil {
+		t.Errorf("ParseCommitTemplate accepted a broken template")
+	}
+	tmpl, _ = ParseCommitTemplate("{{.Verb}}\n{{.Summary}}")
+	if _, err := CommitMessage(tmpl, files); err == nil {
+		t.Errorf("CommitMessage accepted a multi-line subject")
+	}
+}
@@ -7,21 +7,76 @@ import (
 	"os"
 	"path/filepath"
 	"os/user"
+	"strconv"
+	"strings"
 )
 
 type DotfileConfig struct {
 	Path string `json:"path"`
 }
 
+type BackupConfig struct {
+	// Keep is the number of backup sets to retain; nil means the default and
+	// zero disables the limit.
+	Keep *int `json:"keep,omitempty"`
+	// MaxAge removes backup sets older than this (e.g. "720h" or "30d").
+	MaxAge string `json:"max_age,omitempty"`
+}
+
+type EncryptionConfig struct {
+	// KeyFile overrides where the encryption key is kept.
+	KeyFile string `json:"key_file,omitempty"`
+}
+
+type CommitConfig struct {
+	// Template shapes the subject of commits made by submit: "default",
+	// "conventional", or a text/template (see ParseCommitTemplate).
+	Template string `json:"template,omitempty"`
+}
+
+type SecretsConfig struct {
+	// Patterns are extra regular expressions the secret scanner reports.
+	Patterns []string `json:"patterns,omitempty"`
+}
+
 type DotmanConfig struct {
 	Dotfile DotfileConfig `json:"dotfile"`
+	// Ignore holds per-machine gitignore-style patterns, applied after the
+	// repo's .dotmanignore.
+	Ignore []string     `json:"ignore,omitempty"`
+	Backup BackupConfig `json:"backup,omitempty"`
+	// Profile selects the profiles/<name>/home overlay in the repo.
+	Profile    string           `json:"profile,omitempty"`
+	Encryption EncryptionConfig `json:"encryption,omitempty"`
+	Secrets    SecretsConfig    `json:"secrets,omitempty"`
+	Commit     CommitConfig     `json:"commit,omitempty"`
+	// Roots overrides where manifest roots are applied on this machine, by
+	// root name.
+	Roots map[string]string `json:"roots,omitempty"`
+	// ReadOnly makes this machine a consumer of the repo: commands that would
+	// commit or push are refused.
+	ReadOnly bool `json:"read_only,omitempty"`
 }
 
+// DefaultBackupKeep is the number of backup sets kept when backup.keep is unset.
+const DefaultBackupKeep = 20
+
 type ConfigService struct {
 	config DotmanConfig
 	path   string
 }
 
+// ConfigDir returns the directory for per-machine dotman files that are not
+// state, such as template data and the encryption key: $XDG_CONFIG_HOME/dotman,
+// or ~/.config/dotman.
+func ConfigDir() string {
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return filepath.Join(dir, "dotman")
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".config", "dotman")
+}
+
 func getDefaultConfigPath() string {
 	usr, err := user.Current()
 	if err != nil {
@@ -37,6 +92,15 @@ func NewConfigService() *ConfigService {
 	}
 }
 
+// NewConfigServiceAt returns a ConfigService that reads and writes the config
+// file at path instead of ~/.dotman.json.
+func NewConfigServiceAt(path string) *ConfigService {
+	return &ConfigService{
+		config: DotmanConfig{},
+		path:   path,
+	}
+}
+
 func (c *ConfigService) Load() error {
 	bytes, err := os.ReadFile(c.path)
 	if err != nil {
@@ -57,6 +121,11 @@ func (c *ConfigService) Save() error {
 	return os.WriteFile(c.path, bytes, 0644)
 }
 
+// Path returns the location of the config file.
+func (c *ConfigService) Path() string {
+	return c.path
+}
+
 // Get returns a value using dot notation (supports nested fields)
 func (c *ConfigService) Get(key string) (interface{}, error) {
 	switch key {
@@ -64,7 +133,37 @@ func (c *ConfigService) Get(key string) (interface{}, error) {
 		return c.config.Dotfile.Path, nil
 	case "dotfile":
 		return c.config.Dotfile, nil
+	case "ignore":
+		return c.config.Ignore, nil
+	case "backup.keep":
+		if c.config.Backup.Keep == nil {
+			return DefaultBackupKeep, nil
+		}
+		return *c.config.Backup.Keep, nil
+	case "backup.max_age":
+		return c.config.Backup.MaxAge, nil
+	case "profile":
+		return c.config.Profile, nil
+	case "encryption.key_file":
+		if c.config.Encryption.KeyFile == "" {
+			return DefaultKeyPath(), nil
+		}
+		return c.config.Encryption.KeyFile, nil
+	case "secrets.patterns":
+		return c.config.Secrets.Patterns, nil
+	case "roots":
+		return c.config.Roots, nil
+	case "read_only":
+		return c.config.ReadOnly, nil
+	case "commit.template":
+		if c.config.Commit.Template == "" {
+			return "default", nil
+		}
+		return c.config.Commit.Template, nil
 	default:
+		if name, ok := strings.CutPrefix(key, "root."); ok && name != "" {
+			return c.config.Roots[name], nil
+		}
 		return nil, errors.New("unsupported key")
 	}
 }
@@ -79,7 +178,86 @@ func (c *ConfigService) Set(key string, value interface{}) error {
 	case "dotfile.path":
 		c.config.Dotfile.Path = strVal
 		return nil
+	case "ignore":
+		list, err := parseStringList(strVal)
+		if err != nil {
+			return err
+		}
+		c.config.Ignore = list
+		return nil
+	case "backup.keep":
+		n, err := strconv.Atoi(strVal)
+		if err != nil || n < 0 {
+			return errors.New("value must be a non-negative number")
+		}
+		c.config.Backup.Keep = &n
+		return nil
+	case "backup.max_age":
+		if _, err := ParseAge(strVal); err != nil {
+			return err
+		}
+		c.config.Backup.MaxAge = strVal
+		return nil
+	case "profile":
+		if strings.ContainsAny(strVal, `/\`) || strVal == "." || strVal == ".." {
+			return errors.New("profile must be a plain name")
+		}
+		c.config.Profile = strVal
+		return nil
+	case "encryption.key_file":
+		c.config.Encryption.KeyFile = strVal
+		return nil
+	case "secrets.patterns":
+		list, err := parseStringList(strVal)
+		if err != nil {
+			return err
+		}
+		if err := NewSecretScanner().AddPatterns(list); err != nil {
+			return err
+		}
+		c.config.Secrets.Patterns = list
+		return nil
+	case "read_only":
+		b, err := strconv.ParseBool(strVal)
+		if err != nil {
+			return errors.New("value must be true or false")
+		}
+		c.config.ReadOnly = b
+		return nil
+	case "commit.template":
+		if _, err := ParseCommitTemplate(strVal); err != nil {
+			return err
+		}
+		c.config.Commit.Template = strVal
+		return nil
 	default:
+		if name, ok := strings.CutPrefix(key, "root."); ok && name != "" {
+			if strVal == "" {
+				delete(c.config.Roots, name)
+				return nil
+			}
+			if !filepath.IsAbs(strVal) && !strings.HasPrefix(strVal, "~/") {
+				return errors.New("root path must be absolute or start with ~/")
+			}
+			if c.config.Roots == nil {
+				c.config.Roots = map[string]string{}
+			}
+			c.config.Roots[name] = strVal
+			return nil
+		}
 		return errors.New("unsupported key")
 	}
 }
+
+// parseStringList parses a list-valued setting given as a JSON array of strings.
+// An empty value clears the list.
+func parseStringList(value string) ([]string, error) {
+	if value == "" {
+		return nil, nil
+	}
+	var list []string
+	if err := json.Unmarshal([]byte(value), &list); err != nil {
+		return nil, errors.New(`value must be a JSON array of strings, e.g. '["*.log", "cache/"]'`)
+	}
+	return list, nil
+}
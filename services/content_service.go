@@ -0,0 +1,193 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ErrNotReversible is returned by ContentFilter.Clean when home content cannot
+// be turned back into repo content automatically, as with rendered templates.
+var ErrNotReversible = errors.New("content is generated and cannot be copied back")
+
+// ContentFilter converts tracked files between the form stored in the repo and
+// the form written to $HOME.
+type ContentFilter interface {
+	// Applies reports whether the filter handles rel, given its repo content.
+	Applies(rel string, repo []byte) bool
+	// Smudge converts repo content into home content.
+	Smudge(rel string, repo []byte) ([]byte, error)
+	// Clean converts home content back into repo content. current is the repo
+	// content being replaced.
+	Clean(rel string, home, current []byte) ([]byte, error)
+}
+
+// SensitiveFilter is implemented by filters whose home content is private,
+// such as decrypted secrets. Such files are written with 0600 permissions and
+// their contents are not displayed unless explicitly requested.
+type SensitiveFilter interface {
+	Sensitive() bool
+}
+
+// HomeFilter is implemented by filters that can also recognise a file by its
+// home content, such as newly marked secret lines, so the content is cleaned
+// before it first reaches the repo.
+type HomeFilter interface {
+	AppliesHome(rel string, home []byte) bool
+}
+
+// PersistentFilter is implemented by filters that pick up state while
+// converting content, such as secret values, and keep it in memory until
+// Persist is called. Commands persist once they have written files, so
+// read-only scans leave no trace.
+type PersistentFilter interface {
+	Persist() error
+}
+
+// ContentService runs tracked files through the configured filters. A nil
+// ContentService passes content through unchanged.
+type ContentService struct {
+	filters []ContentFilter
+}
+
+func NewContentService(filters ...ContentFilter) *ContentService {
+	return &ContentService{filters: filters}
+}
+
+// Persist saves the state the filters picked up while converting files.
+func (c *ContentService) Persist() error {
+	if c == nil {
+		return nil
+	}
+	for _, f := range c.filters {
+		if p, ok := f.(PersistentFilter); ok {
+			if err := p.Persist(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// HomeContent reads the repo file at repoPath and returns the content that
+// belongs in $HOME. filtered reports whether any filter was involved.
+func (c *ContentService) HomeContent(rel, repoPath string) (data []byte, filtered bool, err error) {
+	data, err = os.ReadFile(repoPath)
+	if err != nil {
+		return nil, false, err
+	}
+	return c.HomeData(rel, data)
+}
+
+// HomeData converts repo content for rel, such as an older version of the
+// file, into the content that belongs in $HOME.
+func (c *ContentService) HomeData(rel string, data []byte) (_ []byte, filtered bool, err error) {
+	if c == nil {
+		return data, false, nil
+	}
+	for _, f := range c.filters {
+		if !f.Applies(rel, data) {
+			continue
+		}
+		data, err = f.Smudge(rel, data)
+		if err != nil {
+			return nil, true, fmt.Errorf("%s: %w", rel, err)
+		}
+		filtered = true
+	}
+	return data, filtered, nil
+}
+
+// RepoContent converts home content for rel into the form to store at
+// repoPath, undoing the filters that apply to the current repo file. A missing
+// repo file is stored verbatim, apart from home filters. Returns
+// ErrNotReversible (wrapped) when a filter cannot convert back.
+func (c *ContentService) RepoContent(rel string, home []byte, repoPath string) ([]byte, error) {
+	if c == nil {
+		return home, nil
+	}
+	current, err := os.ReadFile(repoPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	missing := err != nil
+	// Work out which filters apply on the way in, then undo them in reverse.
+	var applied []ContentFilter
+	var stages [][]byte
+	stage := current
+	for _, f := range c.filters {
+		if missing || !f.Applies(rel, stage) {
+			continue
+		}
+		applied = append(applied, f)
+		stages = append(stages, stage)
+		if stage, err = f.Smudge(rel, stage); err != nil {
+			return nil, fmt.Errorf("%s: %w", rel, err)
+		}
+	}
+	data := home
+	// Filters recognising the home content run first, as the innermost ones.
+	for i := len(c.filters) - 1; i >= 0; i-- {
+		f := c.filters[i]
+		h, ok := f.(HomeFilter)
+		if !ok || containsFilter(applied, f) || !h.AppliesHome(rel, data) {
+			continue
+		}
+		if data, err = f.Clean(rel, data, nil); err != nil {
+			return nil, fmt.Errorf("%s: %w", rel, err)
+		}
+	}
+	for i := len(applied) - 1; i >= 0; i-- {
+		if data, err = applied[i].Clean(rel, data, stages[i]); err != nil {
+			return nil, fmt.Errorf("%s: %w", rel, err)
+		}
+	}
+	return data, nil
+}
+
+func containsFilter(filters []ContentFilter, f ContentFilter) bool {
+	for _, g := range filters {
+		if g == f {
+			return true
+		}
+	}
+	return false
+}
+
+// Sensitive reports whether a sensitive filter applies to the repo file at
+// repoPath.
+func (c *ContentService) Sensitive(rel, repoPath string) bool {
+	if c == nil {
+		return false
+	}
+	data, err := os.ReadFile(repoPath)
+	if err != nil {
+		return false
+	}
+	return c.SensitiveData(rel, data)
+}
+
+// SensitiveData reports whether a sensitive filter applies to repo content
+// for rel.
+func (c *ContentService) SensitiveData(rel string, data []byte) bool {
+	if c == nil {
+		return false
+	}
+	for _, f := range c.filters {
+		if s, ok := f.(SensitiveFilter); ok && s.Sensitive() && f.Applies(rel, data) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reversible reports whether home content for rel can be converted back into
+// the repo form stored at repoPath.
+func (c *ContentService) Reversible(rel, repoPath string) bool {
+	home, filtered, err := c.HomeContent(rel, repoPath)
+	if err != nil || !filtered {
+		return err == nil || os.IsNotExist(err)
+	}
+	_, err = c.RepoContent(rel, home, repoPath)
+	return !errors.Is(err, ErrNotReversible)
+}
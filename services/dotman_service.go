@@ -5,6 +5,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"text/template"
 )
 
 type DotmanService struct {
@@ -50,6 +51,21 @@ func (d *DotmanService) IsInitialized() (string, error) {
 	return dir, nil
 }
 
+// ReadOnly reports whether this machine only consumes the repo (read_only in
+// the config).
+func (d *DotmanService) ReadOnly() bool {
+	val, err := d.Config.Get("read_only")
+	return err == nil && val.(bool)
+}
+
+// RequireWritable returns an error naming command when the repo is read-only.
+func (d *DotmanService) RequireWritable(command string) error {
+	if d.ReadOnly() {
+		return fmt.Errorf("[ERROR] '%s' is disabled: this machine uses the dotfiles repo read-only. Run 'dotman config set read_only false' to allow changes.", command)
+	}
+	return nil
+}
+
 // GetHomeDir returns the absolute path to the 'home' subdirectory inside the dotman repo.
 func (d *DotmanService) GetHomeDir() (string, error) {
 	dir, err := d.IsInitialized()
@@ -59,3 +75,158 @@ func (d *DotmanService) GetHomeDir() (string, error) {
 	homeDir := filepath.Join(dir, "home")
 	return homeDir, nil
 }
+
+// HomeLayer is one tree in the repo that mirrors $HOME: the base home/ tree
+// or an overlay layered over it.
+type HomeLayer struct {
+	// Name is the layer's path relative to the repo root, e.g. "home" or
+	// "hosts/laptop/home".
+	Name string
+	Dir  string
+}
+
+// HomeLayers returns the repo trees that make up the effective home tree,
+// lowest precedence first: home/, then profiles/<profile>/home/ when a profile
+// is configured, then hosts/<hostname>/home/. A file in a later layer wins.
+// Overlays that do not exist in the repo are left out.
+func (d *DotmanService) HomeLayers() ([]HomeLayer, error) {
+	dir, err := d.IsInitialized()
+	if err != nil {
+		return nil, err
+	}
+	layers := []HomeLayer{{Name: "home", Dir: filepath.Join(dir, "home")}}
+	var overlays []string
+	if val, err := d.Config.Get("profile"); err == nil && val.(string) != "" {
+		overlays = append(overlays, filepath.Join("profiles", val.(string), "home"))
+	}
+	if host := Hostname(); host != "" {
+		overlays = append(overlays, filepath.Join("hosts", host, "home"))
+	}
+	for _, name := range overlays {
+		path := filepath.Join(dir, name)
+		if stat, err := os.Stat(path); err == nil && stat.IsDir() {
+			layers = append(layers, HomeLayer{Name: filepath.ToSlash(name), Dir: path})
+		}
+	}
+	return layers, nil
+}
+
+// Hostname returns the machine's host name without any domain part, as used
+// for hosts/<hostname>/home overlays.
+func Hostname() string {
+	host, err := os.Hostname()
+	if err != nil {
+		return ""
+	}
+	host, _, _ = strings.Cut(host, ".")
+	return host
+}
+
+// Manifest loads the manifest of the initialized dotman repo.
+func (d *DotmanService) Manifest() (*ManifestService, error) {
+	dir, err := d.IsInitialized()
+	if err != nil {
+		return nil, err
+	}
+	manifest := NewManifestService(dir)
+	if err := manifest.Load(); err != nil {
+		return nil, fmt.Errorf("[ERROR] Could not load %s: %v", manifest.Path(), err)
+	}
+	if val, err := d.Config.Get("roots"); err == nil {
+		for name, path := range val.(map[string]string) {
+			manifest.SetRootPath(name, path)
+		}
+	}
+	return manifest, nil
+}
+
+// Encryption returns the encryption service using the configured key file.
+func (d *DotmanService) Encryption() *EncryptionService {
+	keyPath := DefaultKeyPath()
+	if val, err := d.Config.Get("encryption.key_file"); err == nil {
+		keyPath = NewFileService().ExpandHome(val.(string))
+	}
+	return NewEncryptionService(keyPath)
+}
+
+// Content builds the content filters for the initialized dotman repo:
+// decryption of encrypted files (and encryption of manifest entries marked
+// encrypted), template rendering for files listed in the
+// manifest or carrying the template marker, using this machine's template
+// data file, and finally filling in secret placeholders from the local
+// secret store.
+func (d *DotmanService) Content() (*ContentService, error) {
+	manifest, err := d.Manifest()
+	if err != nil {
+		return nil, err
+	}
+	templates := NewTemplateService(manifest.Templates())
+	if err := templates.LoadData(TemplateDataPath()); err != nil {
+		return nil, fmt.Errorf("[ERROR] Could not read template data %s: %v", TemplateDataPath(), err)
+	}
+	store := NewSecretStore(SecretStorePath())
+	if err := store.Load(); err != nil {
+		return nil, fmt.Errorf("[ERROR] Could not read secret store %s: %v", SecretStorePath(), err)
+	}
+	placeholders, err := NewPlaceholderService(store, manifest.Secrets(), NewFileService().HomeDir(), manifest)
+	if err != nil {
+		return nil, fmt.Errorf("[ERROR] %s: %v", manifest.Path(), err)
+	}
+	enc := d.Encryption()
+	enc.RequireFor(manifest.Encrypted)
+	return NewContentService(enc, templates, placeholders), nil
+}
+
+// IgnoreMatcher builds the ignore rules for the initialized dotman repo: the
+// built-in rules, the repo's .dotmanignore, then per-machine patterns from the
+// user config.
+func (d *DotmanService) IgnoreMatcher() (*IgnoreMatcher, error) {
+	dir, err := d.IsInitialized()
+	if err != nil {
+		return nil, err
+	}
+	matcher := NewIgnoreMatcher()
+	if err := matcher.AddFile(filepath.Join(dir, IgnoreFileName)); err != nil {
+		return nil, fmt.Errorf("[ERROR] Could not read %s: %v", IgnoreFileName, err)
+	}
+	if val, err := d.Config.Get("ignore"); err == nil {
+		if patterns, ok := val.([]string); ok {
+			matcher.AddPatterns(d.Config.Path()+" (ignore)", patterns)
+		}
+	}
+	return matcher, nil
+}
+
+// SecretScanner builds the secret scanner for the initialized dotman repo: the
+// built-in rules plus patterns from the user config, with the repo's
+// allowlist applied.
+func (d *DotmanService) SecretScanner() (*SecretScanner, error) {
+	dir, err := d.IsInitialized()
+	if err != nil {
+		return nil, err
+	}
+	scanner := NewSecretScanner()
+	if val, err := d.Config.Get("secrets.patterns"); err == nil {
+		if patterns, ok := val.([]string); ok {
+			if err := scanner.AddPatterns(patterns); err != nil {
+				return nil, fmt.Errorf("[ERROR] %v", err)
+			}
+		}
+	}
+	if err := scanner.AddAllowFile(filepath.Join(dir, SecretAllowFileName)); err != nil {
+		return nil, fmt.Errorf("[ERROR] Could not read %s: %v", SecretAllowFileName, err)
+	}
+	return scanner, nil
+}
+
+// CommitTemplate returns the commit subject template chosen with
+// commit.template in the user config.
+func (d *DotmanService) CommitTemplate() (*template.Template, error) {
+	val, _ := d.Config.Get("commit.template")
+	text, _ := val.(string)
+	tmpl, err := ParseCommitTemplate(text)
+	if err != nil {
+		return nil, fmt.Errorf("[ERROR] commit.template: %v", err)
+	}
+	return tmpl, nil
+}
@@ -0,0 +1,264 @@
+package services
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	encryptedHeader = "-----BEGIN DOTMAN ENCRYPTED FILE-----\n"
+	encryptedFooter = "-----END DOTMAN ENCRYPTED FILE-----\n"
+	// PassphraseEnv names the environment variable holding the passphrase used
+	// instead of a key file.
+	PassphraseEnv    = "DOTMAN_PASSPHRASE"
+	pbkdf2Iterations = 600000
+	// maxPBKDF2Iterations bounds the iteration count read from a file, so a
+	// tampered header cannot make decryption run for hours.
+	maxPBKDF2Iterations = 10 * pbkdf2Iterations
+	keySize             = 32
+)
+
+// DefaultKeyPath returns where the encryption key is kept unless configured
+// otherwise. It is outside the repo and must never be committed.
+func DefaultKeyPath() string {
+	return filepath.Join(ConfigDir(), "key")
+}
+
+// EncryptionService stores tracked files in the repo as AES-256-GCM encrypted,
+// ASCII-armoured blobs. The key comes from $DOTMAN_PASSPHRASE (stretched with
+// PBKDF2) or from a key file outside the repo.
+type EncryptionService struct {
+	keyPath  string
+	derived  map[string][]byte
+	required func(rel string) bool
+}
+
+func NewEncryptionService(keyPath string) *EncryptionService {
+	return &EncryptionService{keyPath: keyPath, derived: map[string][]byte{}}
+}
+
+// RequireFor makes files for which required returns true get encrypted on
+// their way into the repo, even when the repo copy is not encrypted yet.
+func (e *EncryptionService) RequireFor(required func(rel string) bool) {
+	e.required = required
+}
+
+// KeyPath returns the location of the key file.
+func (e *EncryptionService) KeyPath() string {
+	return e.keyPath
+}
+
+// UsesPassphrase reports whether files are encrypted with $DOTMAN_PASSPHRASE
+// rather than the key file.
+func (e *EncryptionService) UsesPassphrase() bool {
+	return os.Getenv(PassphraseEnv) != ""
+}
+
+// EnsureKey creates a random key file if no passphrase is set and the key file
+// does not exist yet. It reports whether a key was created.
+func (e *EncryptionService) EnsureKey() (bool, error) {
+	if e.UsesPassphrase() {
+		return false, nil
+	}
+	if _, err := os.Stat(e.keyPath); err == nil {
+		return false, nil
+	}
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		return false, err
+	}
+	fs := NewFileService()
+	if err := fs.MkdirAll(filepath.Dir(e.keyPath), 0700); err != nil {
+		return false, err
+	}
+	encoded := base64.StdEncoding.EncodeToString(key) + "\n"
+	if err := fs.WriteFile(e.keyPath, []byte(encoded), 0600); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (e *EncryptionService) fileKey() ([]byte, error) {
+	data, err := os.ReadFile(e.keyPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no encryption key at %s (copy it from another machine or set %s)", e.keyPath, PassphraseEnv)
+		}
+		return nil, err
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != keySize {
+		return nil, fmt.Errorf("invalid encryption key in %s", e.keyPath)
+	}
+	return key, nil
+}
+
+func (e *EncryptionService) passphraseKey(salt []byte, iterations int) ([]byte, error) {
+	passphrase := os.Getenv(PassphraseEnv)
+	if passphrase == "" {
+		return nil, fmt.Errorf("file was encrypted with a passphrase; set %s", PassphraseEnv)
+	}
+	cacheKey := fmt.Sprintf("%x:%d", salt, iterations)
+	if key, ok := e.derived[cacheKey]; ok {
+		return key, nil
+	}
+	key, err := pbkdf2.Key(sha256.New, passphrase, salt, iterations, keySize)
+	if err != nil {
+		return nil, err
+	}
+	e.derived[cacheKey] = key
+	return key, nil
+}
+
+// IsEncrypted reports whether data is an encrypted dotman blob.
+func IsEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(encryptedHeader))
+}
+
+// Encrypt seals plaintext into an armoured blob.
+func (e *EncryptionService) Encrypt(plaintext []byte) ([]byte, error) {
+	var kdf string
+	var key []byte
+	var err error
+	if e.UsesPassphrase() {
+		salt := make([]byte, 16)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, err
+		}
+		kdf = fmt.Sprintf("pbkdf2-sha256:%d:%s", pbkdf2Iterations, base64.StdEncoding.EncodeToString(salt))
+		key, err = e.passphraseKey(salt, pbkdf2Iterations)
+	} else {
+		kdf = "key"
+		key, err = e.fileKey()
+	}
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	sealed := gcm.Seal(nonce, nonce, plaintext, []byte(kdf))
+
+	var out bytes.Buffer
+	out.WriteString(encryptedHeader)
+	out.WriteString("kdf: " + kdf + "\n\n")
+	encoded := base64.StdEncoding.EncodeToString(sealed)
+	for len(encoded) > 64 {
+		out.WriteString(encoded[:64] + "\n")
+		encoded = encoded[64:]
+	}
+	out.WriteString(encoded + "\n")
+	out.WriteString(encryptedFooter)
+	return out.Bytes(), nil
+}
+
+// Decrypt opens a blob produced by Encrypt. Tampered or truncated blobs, and
+// blobs sealed with a different key, fail authentication.
+func (e *EncryptionService) Decrypt(blob []byte) ([]byte, error) {
+	body, ok := strings.CutPrefix(string(blob), encryptedHeader)
+	if !ok {
+		return nil, errors.New("not an encrypted dotman file")
+	}
+	body, ok = strings.CutSuffix(strings.TrimRight(body, "\n")+"\n", encryptedFooter)
+	if !ok {
+		return nil, errors.New("encrypted file is truncated")
+	}
+	headerLine, payload, _ := strings.Cut(body, "\n\n")
+	kdf, ok := strings.CutPrefix(headerLine, "kdf: ")
+	if !ok {
+		return nil, errors.New("encrypted file has no kdf header")
+	}
+
+	var key []byte
+	var err error
+	switch {
+	case kdf == "key":
+		key, err = e.fileKey()
+	case strings.HasPrefix(kdf, "pbkdf2-sha256:"):
+		parts := strings.Split(kdf, ":")
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("invalid kdf %q", kdf)
+		}
+		iterations, convErr := strconv.Atoi(parts[1])
+		salt, decErr := base64.StdEncoding.DecodeString(parts[2])
+		if convErr != nil || decErr != nil || iterations < 1 || iterations > maxPBKDF2Iterations {
+			return nil, fmt.Errorf("invalid kdf %q", kdf)
+		}
+		key, err = e.passphraseKey(salt, iterations)
+	default:
+		return nil, fmt.Errorf("unsupported kdf %q", kdf)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(payload), ""))
+	if err != nil {
+		return nil, fmt.Errorf("invalid encrypted payload: %w", err)
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("encrypted file is truncated")
+	}
+	plaintext, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], []byte(kdf))
+	if err != nil {
+		return nil, errors.New("decryption failed: wrong key or corrupted file")
+	}
+	return plaintext, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Applies reports whether the repo content is an encrypted blob.
+func (e *EncryptionService) Applies(rel string, repo []byte) bool {
+	return IsEncrypted(repo)
+}
+
+// AppliesHome reports whether rel has to be encrypted before it is stored.
+func (e *EncryptionService) AppliesHome(rel string, home []byte) bool {
+	return e.required != nil && e.required(rel) && !IsEncrypted(home)
+}
+
+// Smudge decrypts the repo blob into the plaintext written to $HOME.
+func (e *EncryptionService) Smudge(rel string, repo []byte) ([]byte, error) {
+	return e.Decrypt(repo)
+}
+
+// Clean encrypts home content for the repo. When the plaintext is unchanged
+// the current blob is kept, so unchanged secrets do not produce new commits.
+func (e *EncryptionService) Clean(rel string, home, current []byte) ([]byte, error) {
+	if plaintext, err := e.Decrypt(current); err == nil && bytes.Equal(plaintext, home) {
+		return current, nil
+	}
+	return e.Encrypt(home)
+}
+
+// Sensitive marks decrypted files as private.
+func (e *EncryptionService) Sensitive() bool {
+	return true
+}
@@ -0,0 +1,119 @@
+package services
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEncryptionService_RoundTrip(t *testing.T) {
+	t.Setenv(PassphraseEnv, "")
+
+	enc := NewEncryptionService(filepath.Join(t.TempDir(), "dotman", "key"))
+	if created, err := enc.EnsureKey(); err != nil || !created {
+		t.Fatalf("EnsureKey: created=%v err=%v", created, err)
+	}
+
+	plaintext := []byte("machine example.com login me password hunter2\n")
+	blob, err := enc.Encrypt(plaintext)
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	if !IsEncrypted(blob) || bytes.Contains(blob, []byte("hunter2")) {
+		t.Fatalf("blob is not encrypted:\n%s", blob)
+	}
+	got, err := enc.Decrypt(blob)
+	if err != nil || !bytes.Equal(got, plaintext) {
+		t.Fatalf("Decrypt: got %q, err %v", got, err)
+	}
+
+	// Unchanged plaintext keeps the existing blob; changes produce a new one.
+	if kept, err := enc.Clean(".netrc", plaintext, blob); err != nil || !bytes.Equal(kept, blob) {
+		t.Fatalf("expected Clean to keep the blob, err %v", err)
+	}
+	if changed, err := enc.Clean(".netrc", []byte("other\n"), blob); err != nil || bytes.Equal(changed, blob) {
+		t.Fatalf("expected Clean to re-encrypt, err %v", err)
+	}
+
+	// Tampering with the ciphertext fails authentication.
+	lines := strings.Split(string(blob), "\n")
+	payload := []byte(lines[3])
+	if payload[10] == 'A' {
+		payload[10] = 'B'
+	} else {
+		payload[10] = 'A'
+	}
+	lines[3] = string(payload)
+	if _, err := enc.Decrypt([]byte(strings.Join(lines, "\n"))); err == nil {
+		t.Fatalf("expected tampered blob to fail")
+	}
+
+	// A different key cannot open it.
+	other := NewEncryptionService(filepath.Join(t.TempDir(), "key"))
+	if _, err := other.EnsureKey(); err != nil {
+		t.Fatalf("EnsureKey: %v", err)
+	}
+	if _, err := other.Decrypt(blob); err == nil {
+		t.Fatalf("expected decryption with another key to fail")
+	}
+}
+
+func TestEncryptionService_Passphrase(t *testing.T) {
+	t.Setenv(PassphraseEnv, "correct horse battery staple")
+
+	enc := NewEncryptionService(filepath.Join(t.TempDir(), "missing-key"))
+	blob, err := enc.Encrypt([]byte("secret"))
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	if !strings.Contains(string(blob), "kdf: pbkdf2-sha256:") {
+		t.Fatalf("expected a passphrase kdf header:\n%s", blob)
+	}
+	if got, err := enc.Decrypt(blob); err != nil || string(got) != "secret" {
+		t.Fatalf("Decrypt: got %q, err %v", got, err)
+	}
+
+	t.Setenv(PassphraseEnv, "wrong")
+	if _, err := NewEncryptionService("").Decrypt(blob); err == nil {
+		t.Fatalf("expected wrong passphrase to fail")
+	}
+
+	// Iteration counts outside the accepted range are rejected before any key
+	// is derived.
+	for _, n := range []string{"0", "-1", "999999999"} {
+		tampered := strings.Replace(string(blob), "pbkdf2-sha256:600000:", "pbkdf2-sha256:"+n+":", 1)
+		if _, err := enc.Decrypt([]byte(tampered)); err == nil || !strings.Contains(err.Error(), "invalid kdf") {
+			t.Errorf("Decrypt with %s iterations: got %v, want an invalid kdf error", n, err)
+		}
+	}
+}
+
+func TestContentService_Encrypted(t *testing.T) {
+	t.Setenv(PassphraseEnv, "")
+
+	dir := t.TempDir()
+	enc := NewEncryptionService(filepath.Join(dir, "key"))
+	if _, err := enc.EnsureKey(); err != nil {
+		t.Fatalf("EnsureKey: %v", err)
+	}
+	blob, err := enc.Encrypt([]byte("token=abc\n"))
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	repoPath := filepath.Join(dir, ".netrc")
+	writeFile(t, repoPath, string(blob))
+
+	content := NewContentService(enc, NewTemplateService(nil))
+	data, filtered, err := content.HomeContent(".netrc", repoPath)
+	if err != nil || !filtered || string(data) != "token=abc\n" {
+		t.Fatalf("HomeContent: %q filtered=%v err=%v", data, filtered, err)
+	}
+	if !content.Sensitive(".netrc", repoPath) || !content.Reversible(".netrc", repoPath) {
+		t.Fatalf("expected encrypted file to be sensitive and reversible")
+	}
+	repoData, err := content.RepoContent(".netrc", []byte("token=def\n"), repoPath)
+	if err != nil || !IsEncrypted(repoData) {
+		t.Fatalf("RepoContent: err %v\n%s", err, repoData)
+	}
+}
@@ -36,6 +36,39 @@ func (fs *FileService) ExpandHome(path string) string {
 	return path
 }
 
+// CheckWritable reports whether path can be written without actually
+// changing it: an existing file must open for writing, and otherwise a file
+// must be creatable in its nearest existing parent directory.
+func (fs *FileService) CheckWritable(path string) error {
+	if info, err := os.Stat(path); err == nil {
+		if info.IsDir() {
+			return fmt.Errorf("%s is a directory", path)
+		}
+		f, err := os.OpenFile(path, os.O_WRONLY, 0)
+		if err != nil {
+			return err
+		}
+		return f.Close()
+	}
+	dir := filepath.Dir(path)
+	for {
+		if _, err := os.Stat(dir); err == nil {
+			break
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+	f, err := os.CreateTemp(dir, ".dotman-check-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
 // HomeDir returns the current user's home directory.
 func (fs *FileService) HomeDir() string {
 	home, _ := os.UserHomeDir()
@@ -90,6 +123,12 @@ func (fs *FileService) FileHash(path string) (string, error) {
 	return fmt.Sprintf("%x", h.Sum(nil)), nil
 }
 
+// ContentHash returns the hex-encoded SHA-256 hash of data, matching FileHash
+// for a file with that content.
+func ContentHash(data []byte) string {
+	return fmt.Sprintf("%x", sha256.Sum256(data))
+}
+
 // ShortUniquePrefix truncates two hash strings to the shortest prefix (min 7)
 // that still distinguishes them.
 func ShortUniquePrefix(a, b string) (string, string) {
@@ -115,20 +154,112 @@ func NormalizeRelPath(rel string) string {
 	return rel
 }
 
+// CompareOptions tunes how CompareFilesWith classifies files.
+type CompareOptions struct {
+	// State holds the hashes recorded at the last apply/submit. When nil,
+	// every differing file is reported as BothChanged.
+	State *StateService
+	// TrackedDirs lists directories, relative to the home tree, whose new
+	// files in userHome are reported as home-side changes.
+	TrackedDirs []string
+	// Ignore excludes matching paths on both sides of the comparison.
+	Ignore *IgnoreMatcher
+	// Overlays lists further repo trees layered over repoHome, lowest
+	// precedence first. A file in an overlay replaces the same path in
+	// repoHome and in earlier overlays. Missing overlays are skipped.
+	Overlays []string
+	// Content converts repo files into their home form (e.g. rendering
+	// templates) before they are compared.
+	Content *ContentService
+	// Manifest supplies per-entry attributes: entries whose conditions do
+	// not hold on this machine are skipped, and targets and modes are
+	// applied. A nil Manifest compares every file at $HOME/<rel>.
+	Manifest *ManifestService
+	// Tags, when set, limits the comparison to entries carrying one of them.
+	Tags []string
+}
+
 // CompareFiles walks repoHome and compares each file against the corresponding
 // file in userHome. Returns two lists: files that differ (changed) and files
 // that exist only in the repo (created).
 func (fs *FileService) CompareFiles(repoHome, userHome string) (changed []types.FileDiff, created []types.FileDiff, err error) {
-	err = filepath.Walk(repoHome, func(path string, info os.FileInfo, walkErr error) error {
-		if walkErr != nil {
-			return walkErr
+	changed, created, _, err = fs.CompareFilesWith(repoHome, userHome, CompareOptions{})
+	return changed, created, err
+}
+
+// CompareFilesWith behaves like CompareFiles but also classifies each result by
+// direction using the sync records in opts.State, which it only reads. Files
+// found identical on both sides are returned in inSync, so that commands
+// which write can record them with RecordInSync. Files found only in userHome
+// under one of opts.TrackedDirs are reported as changed with a RepoHash of
+// "missing" and Direction HomeChanged; files deleted from userHome since the
+// last sync are reported the same way with a UserHash of "missing". Each
+// result's RepoPath names the
+// effective repo file after applying opts.Overlays; repo files are compared in
+// their home form after opts.Content has run. A file opts.Content cannot
+// convert does not stop the scan: it is reported in changed with Err set.
+func (fs *FileService) CompareFilesWith(repoHome, userHome string, opts CompareOptions) (changed, created, inSync []types.FileDiff, err error) {
+	var order []string
+	effective := make(map[string]string)
+	// Trees are walked home tree first, then its overlays, then each extra
+	// root, whose files are named "<root>:<path>".
+	trees := append([]string{repoHome}, opts.Overlays...)
+	prefixes := make([]string, len(trees))
+	for _, r := range opts.Manifest.Roots() {
+		trees = append(trees, opts.Manifest.RootDir(r))
+		prefixes = append(prefixes, r.Name+":")
+	}
+	for i, root := range trees {
+		if i > 0 {
+			if _, statErr := os.Stat(root); os.IsNotExist(statErr) {
+				continue
+			}
 		}
-		if info.IsDir() {
+		err = filepath.Walk(root, func(path string, info os.FileInfo, walkErr error) error {
+			if walkErr != nil {
+				return walkErr
+			}
+			relPath := NormalizeRelPath(mustRel(root, path))
+			if prefixes[i] != "" {
+				if path == root {
+					return nil
+				}
+				relPath = prefixes[i] + filepath.ToSlash(mustRel(root, path))
+			}
+			if path != root && opts.Ignore.Ignored(relPath, info.IsDir()) {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if info.IsDir() {
+				return nil
+			}
+			if !opts.Manifest.Active(relPath) || (len(opts.Tags) > 0 && !opts.Manifest.HasTag(relPath, opts.Tags)) {
+				return nil
+			}
+			if _, ok := effective[relPath]; !ok {
+				order = append(order, relPath)
+			}
+			effective[relPath] = path
 			return nil
+		})
+		if err != nil {
+			return
 		}
-		relPath := NormalizeRelPath(mustRel(repoHome, path))
-		userFile := filepath.Join(userHome, relPath)
-		repoHash, _ := fs.FileHash(path)
+	}
+
+	seen := make(map[string]struct{})
+	for _, relPath := range order {
+		path := effective[relPath]
+		seen[relPath] = struct{}{}
+		userFile := opts.Manifest.HomePath(userHome, relPath)
+		content, filtered, contentErr := opts.Content.HomeContent(relPath, path)
+		if contentErr != nil {
+			changed = append(changed, types.FileDiff{RelPath: relPath, RepoPath: path, HomePath: userFile, Filtered: true, Err: contentErr})
+			continue
+		}
+		repoHash := ContentHash(content)
 		userHash := "missing"
 		repoDate := "missing"
 		userDate := "missing"
@@ -139,29 +270,137 @@ func (fs *FileService) CompareFiles(repoHome, userHome string) (changed []types.
 			userHash, _ = fs.FileHash(userFile)
 			userDate = stat.ModTime().Format("2006-01-02 15:04:05")
 		}
+		direction := classify(relPath, repoHash, userHash, opts.State)
 		if repoHash != "missing" && userHash != "missing" {
 			repoHash, userHash = ShortUniquePrefix(repoHash, userHash)
 		}
-		if userHash == "missing" {
-			created = append(created, types.FileDiff{
-				RelPath:  relPath,
-				RepoHash: repoHash,
-				UserHash: userHash,
-				RepoDate: repoDate,
-				UserDate: userDate,
-			})
-		} else if repoHash != userHash {
-			changed = append(changed, types.FileDiff{
-				RelPath:  relPath,
-				RepoHash: repoHash,
-				UserHash: userHash,
-				RepoDate: repoDate,
-				UserDate: userDate,
-			})
+		diff := types.FileDiff{
+			RelPath:   relPath,
+			RepoPath:  path,
+			HomePath:  userFile,
+			RepoHash:  repoHash,
+			UserHash:  userHash,
+			RepoDate:  repoDate,
+			UserDate:  userDate,
+			Direction: direction,
+			Filtered:  filtered,
+			Sensitive: filtered && opts.Content.Sensitive(relPath, path),
+		}
+		if e := opts.Manifest.Entry(relPath); e != nil {
+			diff.Mode = e.FileMode()
+		}
+		switch {
+		case userHash == "missing" && direction == types.RepoChanged:
+			created = append(created, diff)
+		case direction == types.InSync:
+			inSync = append(inSync, diff)
+		default:
+			changed = append(changed, diff)
+		}
+	}
+	for _, dir := range opts.TrackedDirs {
+		if !opts.Manifest.Active(dir) || (len(opts.Tags) > 0 && !opts.Manifest.HasTag(dir, opts.Tags)) {
+			continue
+		}
+		var untracked []types.FileDiff
+		untracked, err = fs.untrackedFiles(repoHome, userHome, dir, seen, opts)
+		if err != nil {
+			return
+		}
+		changed = append(changed, untracked...)
+	}
+	return
+}
+
+// ResolveRepoFile returns the path of rel in the last of layers that contains
+// it, or its path in the first layer when none does. layers are repo trees
+// mirroring $HOME, lowest precedence first.
+func (fs *FileService) ResolveRepoFile(layers []string, rel string) string {
+	for i := len(layers) - 1; i > 0; i-- {
+		path := filepath.Join(layers[i], rel)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return filepath.Join(layers[0], rel)
+}
+
+// untrackedFiles walks dir (at its target) and returns the regular files that
+// have no counterpart in the repo (those not present in seen). Their RepoPath
+// points to where a submit would add them.
+func (fs *FileService) untrackedFiles(repoHome, userHome, dir string, seen map[string]struct{}, opts CompareOptions) ([]types.FileDiff, error) {
+	var found []types.FileDiff
+	root := opts.Manifest.HomePath(userHome, filepath.FromSlash(dir))
+	err := filepath.Walk(root, func(path string, info os.FileInfo, walkErr error) error {
+		if walkErr != nil {
+			if os.IsNotExist(walkErr) {
+				return nil
+			}
+			return walkErr
 		}
+		relPath := filepath.Join(filepath.FromSlash(dir), mustRel(root, path))
+		if opts.Ignore.Ignored(relPath, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		if _, ok := seen[relPath]; ok {
+			return nil
+		}
+		if !opts.Manifest.Active(relPath) {
+			return nil
+		}
+		seen[relPath] = struct{}{}
+		userHash, _ := fs.FileHash(path)
+		found = append(found, types.FileDiff{
+			RelPath:   relPath,
+			RepoPath:  opts.Manifest.RepoPath(repoHome, relPath),
+			HomePath:  path,
+			RepoHash:  "missing",
+			UserHash:  userHash,
+			RepoDate:  "missing",
+			UserDate:  info.ModTime().Format("2006-01-02 15:04:05"),
+			Direction: types.HomeChanged,
+		})
 		return nil
 	})
-	return
+	return found, err
+}
+
+// classify works out which side of rel moved by comparing both full hashes
+// against the last recorded sync. A file missing from home is a home-side
+// deletion when the repo still holds the synced content, and otherwise a
+// repo-side change that creates it.
+func classify(rel, repoHash, userHash string, state *StateService) types.SyncDirection {
+	if userHash == "missing" {
+		if state != nil {
+			if rec, ok := state.Get(rel); ok && rec.Hash == repoHash {
+				return types.HomeChanged
+			}
+		}
+		return types.RepoChanged
+	}
+	if repoHash == userHash {
+		return types.InSync
+	}
+	if state == nil {
+		return types.BothChanged
+	}
+	rec, ok := state.Get(rel)
+	switch {
+	case !ok:
+		return types.BothChanged
+	case rec.Hash == repoHash:
+		return types.HomeChanged
+	case rec.Hash == userHash:
+		return types.RepoChanged
+	default:
+		return types.BothChanged
+	}
 }
 
 func mustRel(base, target string) string {
@@ -169,25 +408,98 @@ func mustRel(base, target string) string {
 	return rel
 }
 
-// CopyFile copies a file from src to dst, preserving permissions.
+// CopyOptions tunes CopyFileWith.
+type CopyOptions struct {
+	Perm os.FileMode
+	// PreserveModTime carries the source modification time over to dst.
+	PreserveModTime bool
+}
+
+// CopyFile copies a file from src to dst, preserving permissions. The copy is
+// atomic: dst is either left untouched or fully replaced.
 func (fs *FileService) CopyFile(src, dst string, perm os.FileMode) error {
+	return fs.CopyFileWith(src, dst, CopyOptions{Perm: perm})
+}
+
+// CopyFileWith copies src to dst atomically using the given options.
+func (fs *FileService) CopyFileWith(src, dst string, opts CopyOptions) error {
 	in, err := os.Open(src)
 	if err != nil {
 		return fmt.Errorf("failed to open source file %s: %w", src, err)
 	}
 	defer in.Close()
 
-	out, err := os.Create(dst)
+	err = writeAtomic(dst, opts.Perm, func(out *os.File) error {
+		if _, err := io.Copy(out, in); err != nil {
+			return fmt.Errorf("failed to copy file %s to %s: %w", src, dst, err)
+		}
+		return nil
+	})
+	if err != nil || !opts.PreserveModTime {
+		return err
+	}
+	stat, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	if err := os.Chtimes(dst, stat.ModTime(), stat.ModTime()); err != nil {
+		return fmt.Errorf("failed to set modification time on %s: %w", dst, err)
+	}
+	return nil
+}
+
+// WriteFile atomically replaces dst with data.
+func (fs *FileService) WriteFile(dst string, data []byte, perm os.FileMode) error {
+	return writeAtomic(dst, perm, func(out *os.File) error {
+		if _, err := out.Write(data); err != nil {
+			return fmt.Errorf("failed to write %s: %w", dst, err)
+		}
+		return nil
+	})
+}
+
+// writeAtomic fills a temporary file next to dst, syncs it to disk, applies
+// perm and renames it over dst, so an interrupted write never leaves a
+// truncated file behind. A symlinked dst is resolved so the link is kept and
+// its target replaced.
+func writeAtomic(dst string, perm os.FileMode, fill func(out *os.File) error) error {
+	if resolved, err := filepath.EvalSymlinks(dst); err == nil {
+		dst = resolved
+	}
+	dir := filepath.Dir(dst)
+	out, err := os.CreateTemp(dir, "."+filepath.Base(dst)+".dotman-*")
 	if err != nil {
 		return fmt.Errorf("failed to create destination file %s: %w", dst, err)
 	}
-	defer out.Close()
+	tmp := out.Name()
+	committed := false
+	defer func() {
+		if !committed {
+			out.Close()
+			os.Remove(tmp)
+		}
+	}()
 
-	if _, err = io.Copy(out, in); err != nil {
-		return fmt.Errorf("failed to copy file %s to %s: %w", src, dst, err)
+	if err := fill(out); err != nil {
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		return fmt.Errorf("failed to sync %s: %w", dst, err)
 	}
-	if err = out.Chmod(perm); err != nil {
+	if err := out.Chmod(perm); err != nil {
 		return fmt.Errorf("failed to set permissions on %s: %w", dst, err)
 	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("failed to close %s: %w", dst, err)
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", dst, err)
+	}
+	committed = true
+	// Persist the rename itself; not every platform supports syncing a directory.
+	if d, err := os.Open(dir); err == nil {
+		_ = d.Sync()
+		d.Close()
+	}
 	return nil
 }
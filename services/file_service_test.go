@@ -4,6 +4,9 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
+
+	"dotman/types"
 )
 
 func TestCompareFiles_NoChanges(t *testing.T) {
@@ -137,6 +140,248 @@ func TestCompareFiles_Mixed(t *testing.T) {
 	}
 }
 
+func TestCompareFilesWith_Direction(t *testing.T) {
+	t.Parallel()
+
+	repoHome := t.TempDir()
+	userHome := t.TempDir()
+
+	writeFile(t, filepath.Join(repoHome, ".bashrc"), "base")
+	writeFile(t, filepath.Join(userHome, ".bashrc"), "local edit")
+	writeFile(t, filepath.Join(repoHome, ".zshrc"), "repo edit")
+	writeFile(t, filepath.Join(userHome, ".zshrc"), "base")
+	writeFile(t, filepath.Join(repoHome, ".vimrc"), "repo edit")
+	writeFile(t, filepath.Join(userHome, ".vimrc"), "local edit")
+	writeFile(t, filepath.Join(repoHome, ".inputrc"), "no record")
+	writeFile(t, filepath.Join(userHome, ".inputrc"), "differs")
+
+	fs := NewFileService()
+	base, _ := fs.FileHash(filepath.Join(userHome, ".zshrc"))
+	state := &StateService{path: filepath.Join(t.TempDir(), "state.json")}
+	state.Record(".bashrc", base)
+	state.Record(".zshrc", base)
+	state.Record(".vimrc", base)
+
+	changed, _, _, err := fs.CompareFilesWith(repoHome, userHome, CompareOptions{State: state})
+	if err != nil {
+		t.Fatalf("CompareFilesWith: %v", err)
+	}
+	want := map[string]types.SyncDirection{
+		".bashrc":  types.HomeChanged,
+		".zshrc":   types.RepoChanged,
+		".vimrc":   types.BothChanged,
+		".inputrc": types.BothChanged,
+	}
+	if len(changed) != len(want) {
+		t.Fatalf("expected %d changed, got %d", len(want), len(changed))
+	}
+	for _, info := range changed {
+		if info.Direction != want[info.RelPath] {
+			t.Fatalf("%s: expected %s, got %s", info.RelPath, want[info.RelPath], info.Direction)
+		}
+	}
+}
+
+func TestCompareFilesWith_DeletedInHome(t *testing.T) {
+	t.Parallel()
+
+	repoHome := t.TempDir()
+	userHome := t.TempDir()
+
+	// .zshrc was synced and then deleted at home; .bashrc changed in the repo
+	// since it was synced, and .vimrc was never synced.
+	writeFile(t, filepath.Join(repoHome, ".zshrc"), "base")
+	writeFile(t, filepath.Join(repoHome, ".bashrc"), "repo edit")
+	writeFile(t, filepath.Join(repoHome, ".vimrc"), "new")
+
+	fs := NewFileService()
+	base, _ := fs.FileHash(filepath.Join(repoHome, ".zshrc"))
+	state := &StateService{path: filepath.Join(t.TempDir(), "state.json")}
+	state.Record(".zshrc", base)
+	state.Record(".bashrc", base)
+
+	changed, created, _, err := fs.CompareFilesWith(repoHome, userHome, CompareOptions{State: state})
+	if err != nil {
+		t.Fatalf("CompareFilesWith: %v", err)
+	}
+	if len(changed) != 1 || changed[0].RelPath != ".zshrc" || changed[0].Direction != types.HomeChanged || changed[0].UserHash != "missing" {
+		t.Fatalf("expected .zshrc deleted in home, got %+v", changed)
+	}
+	if len(created) != 2 {
+		t.Fatalf("expected .bashrc and .vimrc to be created, got %+v", created)
+	}
+	for _, info := range created {
+		if info.Direction != types.RepoChanged {
+			t.Fatalf("%s: expected %s, got %s", info.RelPath, types.RepoChanged, info.Direction)
+		}
+	}
+}
+
+func TestCompareFilesWith_RecordsInSync(t *testing.T) {
+	t.Parallel()
+
+	repoHome := t.TempDir()
+	userHome := t.TempDir()
+
+	writeFile(t, filepath.Join(repoHome, ".zshrc"), "same")
+	writeFile(t, filepath.Join(userHome, ".zshrc"), "same")
+
+	fs := NewFileService()
+	state := &StateService{path: filepath.Join(t.TempDir(), "state.json")}
+	_, _, inSync, err := fs.CompareFilesWith(repoHome, userHome, CompareOptions{State: state})
+	if err != nil {
+		t.Fatalf("CompareFilesWith: %v", err)
+	}
+	if len(inSync) != 1 || inSync[0].RelPath != ".zshrc" {
+		t.Fatalf("expected .zshrc in sync, got %+v", inSync)
+	}
+	if _, ok := state.Get(".zshrc"); ok {
+		t.Fatal("comparing files should not write sync state")
+	}
+	if err := state.RecordInSync(inSync); err != nil {
+		t.Fatalf("RecordInSync: %v", err)
+	}
+	want, _ := fs.FileHash(filepath.Join(repoHome, ".zshrc"))
+	rec, ok := state.Get(".zshrc")
+	if !ok || rec.Hash != want {
+		t.Fatalf("expected in-sync file to be recorded with %s, got %+v", want, rec)
+	}
+}
+
+func TestCompareFilesWith_TrackedDirs(t *testing.T) {
+	t.Parallel()
+
+	repoHome := t.TempDir()
+	userHome := t.TempDir()
+
+	os.MkdirAll(filepath.Join(repoHome, ".config", "nvim"), 0755)
+	os.MkdirAll(filepath.Join(userHome, ".config", "nvim", "lua"), 0755)
+	os.MkdirAll(filepath.Join(userHome, ".config", "other"), 0755)
+	writeFile(t, filepath.Join(repoHome, ".config", "nvim", "init.lua"), "same")
+	writeFile(t, filepath.Join(userHome, ".config", "nvim", "init.lua"), "same")
+	writeFile(t, filepath.Join(userHome, ".config", "nvim", "lua", "new.lua"), "new")
+	writeFile(t, filepath.Join(userHome, ".config", "other", "ignored"), "untracked dir")
+
+	fs := NewFileService()
+	changed, created, _, err := fs.CompareFilesWith(repoHome, userHome, CompareOptions{
+		TrackedDirs: []string{".config/nvim", ".config/missing"},
+	})
+	if err != nil {
+		t.Fatalf("CompareFilesWith: %v", err)
+	}
+	if len(created) != 0 {
+		t.Fatalf("expected 0 created, got %d", len(created))
+	}
+	if len(changed) != 1 {
+		t.Fatalf("expected 1 changed, got %d", len(changed))
+	}
+	if changed[0].RelPath != ".config/nvim/lua/new.lua" || changed[0].Direction != types.HomeChanged || changed[0].RepoHash != "missing" {
+		t.Fatalf("unexpected diff %+v", changed[0])
+	}
+}
+
+func TestCompareFilesWith_Overlays(t *testing.T) {
+	t.Parallel()
+
+	repoHome := t.TempDir()
+	profile := t.TempDir()
+	host := t.TempDir()
+	userHome := t.TempDir()
+
+	writeFile(t, filepath.Join(repoHome, ".zshrc"), "base")
+	writeFile(t, filepath.Join(repoHome, ".vimrc"), "base")
+	writeFile(t, filepath.Join(profile, ".zshrc"), "profile")
+	writeFile(t, filepath.Join(profile, ".gitconfig"), "profile")
+	writeFile(t, filepath.Join(host, ".zshrc"), "host")
+	writeFile(t, filepath.Join(userHome, ".zshrc"), "host")
+	writeFile(t, filepath.Join(userHome, ".vimrc"), "base")
+	writeFile(t, filepath.Join(userHome, ".gitconfig"), "edited")
+
+	fs := NewFileService()
+	changed, created, _, err := fs.CompareFilesWith(repoHome, userHome, CompareOptions{
+		Overlays: []string{profile, filepath.Join(repoHome, "missing"), host},
+	})
+	if err != nil {
+		t.Fatalf("CompareFilesWith: %v", err)
+	}
+	if len(created) != 0 {
+		t.Fatalf("expected 0 created, got %d", len(created))
+	}
+	if len(changed) != 1 || changed[0].RelPath != ".gitconfig" || changed[0].RepoPath != filepath.Join(profile, ".gitconfig") {
+		t.Fatalf("expected only .gitconfig from the profile overlay, got %+v", changed)
+	}
+
+	layers := []string{repoHome, profile, host}
+	if got := fs.ResolveRepoFile(layers, ".zshrc"); got != filepath.Join(host, ".zshrc") {
+		t.Fatalf("expected host overlay to win, got %s", got)
+	}
+	if got := fs.ResolveRepoFile(layers, ".newrc"); got != filepath.Join(repoHome, ".newrc") {
+		t.Fatalf("expected new files to resolve into the base tree, got %s", got)
+	}
+}
+
+func TestCopyFile_ReplacesAtomically(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	writeFile(t, src, "new content")
+	writeFile(t, dst, "old content that is longer")
+
+	fs := NewFileService()
+	if err := fs.CopyFile(src, dst, 0600); err != nil {
+		t.Fatalf("CopyFile: %v", err)
+	}
+	content, _ := os.ReadFile(dst)
+	if string(content) != "new content" {
+		t.Fatalf("unexpected content %q", content)
+	}
+	if info, _ := os.Stat(dst); info.Mode().Perm() != 0600 {
+		t.Fatalf("expected mode 0600, got %v", info.Mode().Perm())
+	}
+
+	// A copy failing partway must leave the destination and directory
+	// untouched. A directory opens fine but fails on the first read, so the
+	// temporary file already exists by then.
+	if err := fs.CopyFile(t.TempDir(), dst, 0644); err == nil {
+		t.Fatalf("expected error copying from a directory")
+	}
+	content, _ = os.ReadFile(dst)
+	if string(content) != "new content" {
+		t.Fatalf("destination changed after failed copy: %q", content)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 2 {
+		t.Fatalf("expected no temporary files left behind, got %d entries", len(entries))
+	}
+}
+
+func TestCopyFileWith_PreserveModTime(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	writeFile(t, src, "content")
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.Chtimes(src, mtime, mtime); err != nil {
+		t.Fatalf("Chtimes: %v", err)
+	}
+
+	fs := NewFileService()
+	if err := fs.CopyFileWith(src, dst, CopyOptions{Perm: 0644, PreserveModTime: true}); err != nil {
+		t.Fatalf("CopyFileWith: %v", err)
+	}
+	info, err := os.Stat(dst)
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if !info.ModTime().Equal(mtime) {
+		t.Fatalf("expected mtime %v, got %v", mtime, info.ModTime())
+	}
+}
+
 func writeFile(t *testing.T, path, content string) {
 	t.Helper()
 	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
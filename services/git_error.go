@@ -0,0 +1,118 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// Sentinel errors for git failures commands can react to. A *GitError matches
+// them with errors.Is.
+var (
+	ErrNothingToCommit = errors.New("nothing to commit")
+	ErrRebaseConflict  = errors.New("rebase stopped on conflicts")
+	ErrNoUpstream      = errors.New("no upstream branch")
+	ErrAuthFailed      = errors.New("authentication failed")
+	ErrPushRejected    = errors.New("push rejected by the remote")
+	ErrNotARepo        = errors.New("not a git repository")
+)
+
+// GitError describes a git command that failed: the command line, its exit
+// code (-1 when git could not be run) and what it printed.
+type GitError struct {
+	Dir      string
+	Args     []string
+	ExitCode int
+	Stdout   string
+	Stderr   string
+	// Kind is the sentinel the failure was recognised as, or nil.
+	Kind error
+	// Err is the error from running the command.
+	Err error
+}
+
+// Command returns the command line that failed, e.g. "git push".
+func (e *GitError) Command() string {
+	return "git " + strings.Join(e.Args, " ")
+}
+
+func (e *GitError) Error() string {
+	name := "git"
+	if len(e.Args) > 0 {
+		name += " " + e.Args[0]
+	}
+	detail := strings.TrimSpace(e.Stderr)
+	if detail == "" {
+		detail = strings.TrimSpace(e.Stdout)
+	}
+	if detail == "" && e.Err != nil {
+		detail = e.Err.Error()
+	}
+	if e.ExitCode < 0 {
+		return fmt.Sprintf("%s failed: %s", name, detail)
+	}
+	return fmt.Sprintf("%s failed (exit %d): %s", name, e.ExitCode, detail)
+}
+
+// Unwrap exposes both the sentinel and the underlying error.
+func (e *GitError) Unwrap() []error {
+	if e.Kind == nil {
+		return []error{e.Err}
+	}
+	return []error{e.Kind, e.Err}
+}
+
+// gitErrorKinds maps phrases in git's output to sentinels. Output is matched
+// lower-cased; the first match wins.
+var gitErrorKinds = []struct {
+	kind    error
+	phrases []string
+}{
+	{ErrNotARepo, []string{"not a git repository"}},
+	{ErrNothingToCommit, []string{"nothing to commit", "nothing added to commit", "no changes added to commit", "no changes - did you forget to use 'git add'"}},
+	{ErrRebaseConflict, []string{"could not apply", "resolve all conflicts manually", "conflict ("}},
+	{ErrNoUpstream, []string{"no tracking information", "has no upstream branch", "no upstream configured", "no such ref was fetched"}},
+	{ErrAuthFailed, []string{"authentication failed", "permission denied (publickey", "could not read username", "could not read password", "terminal prompts disabled", "the requested url returned error: 403"}},
+	{ErrPushRejected, []string{"[rejected]", "[remote rejected]", "updates were rejected"}},
+}
+
+func classifyGitOutput(stdout, stderr string) error {
+	out := strings.ToLower(stderr + "\n" + stdout)
+	for _, k := range gitErrorKinds {
+		for _, phrase := range k.phrases {
+			if strings.Contains(out, phrase) {
+				return k.kind
+			}
+		}
+	}
+	return nil
+}
+
+// runGit executes cmd, capturing its output. A failure is returned as a
+// *GitError.
+func runGit(cmd *exec.Cmd) (stdout, stderr []byte, err error) {
+	var outBuf, errBuf bytes.Buffer
+	cmd.Stdout = &outBuf
+	cmd.Stderr = &errBuf
+	runErr := cmd.Run()
+	stdout, stderr = outBuf.Bytes(), errBuf.Bytes()
+	if runErr == nil {
+		return stdout, stderr, nil
+	}
+	gitErr := &GitError{
+		Dir:      cmd.Dir,
+		Args:     cmd.Args[1:],
+		ExitCode: -1,
+		Stdout:   string(stdout),
+		Stderr:   string(stderr),
+		Err:      runErr,
+	}
+	var exitErr *exec.ExitError
+	if errors.As(runErr, &exitErr) {
+		gitErr.ExitCode = exitErr.ExitCode()
+	}
+	gitErr.Kind = classifyGitOutput(gitErr.Stdout, gitErr.Stderr)
+	return stdout, stderr, gitErr
+}
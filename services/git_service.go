@@ -1,15 +1,61 @@
 package services
 
 import (
+	"errors"
 	"fmt"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 )
 
+// Git is the set of git operations commands use. GitService runs them with
+// the git binary; gittest.Fake scripts them for tests.
+type Git interface {
+	SetVerbose(v bool)
+	SetReadOnly(v bool)
+
+	Status(dir string) ([]StatusEntry, error)
+	AheadBehind(dir string) (int, int, error)
+	Add(dir string, files []string) error
+	Remove(dir string, paths []string) error
+	Commit(dir, message string) error
+	StagedChanges(dir string) ([]FileChange, error)
+	MergeFile(current, base, other string, labels [3]string) ([]byte, int, error)
+	PullRebase(dir string) ([]byte, error)
+	Fetch(dir string) error
+	Rebase(dir, onto string) error
+	Push(dir string) ([]byte, error)
+
+	RebaseInProgress(dir string) (bool, error)
+	ConflictedFiles(dir string) ([]string, error)
+	ShowStage(dir string, stage int, path string) ([]byte, bool, error)
+	StageMode(dir string, stage int, path string) (os.FileMode, error)
+	RebaseContinue(dir string) error
+	RebaseSkip(dir string) error
+	RebaseAbort(dir string) error
+
+	ShowFile(dir, rev, path string) ([]byte, bool, error)
+	ResolveRev(dir, rev string) (string, error)
+	Log(dir string, paths []string, limit int) ([]Commit, error)
+
+	CloneRepo(repoURL, targetDir string, depth1, noCheckout bool) error
+	IsRemoteGitRepo(url string) bool
+}
+
+var _ Git = (*GitService)(nil)
+
 type GitService struct {
-	verbose bool
+	verbose  bool
+	readOnly bool
 }
 
+// ErrReadOnly is returned by operations that would change the repo's history
+// or index, or push, while the GitService is read-only.
+var ErrReadOnly = errors.New("the dotfiles repo is read-only")
+
 func (g *GitService) ExecCommand(dir string, args ...string) *exec.Cmd {
 	if g.verbose {
 		fmt.Printf("[git] (%s) Running: git %s\n", dir, strings.Join(args, " "))
@@ -28,42 +74,125 @@ func (g *GitService) SetVerbose(v bool) {
 	g.verbose = v
 }
 
-// Status returns a list of changed tracked files (modified or untracked) in the repo at dir.
-// Uses porcelain v2 for consistent parsing across Git versions/configs.
-func (g *GitService) Status(dir string) ([]string, error) {
-	cmd := g.ExecCommand(dir, "status", "--porcelain=v2")
-	out, err := cmd.Output()
+// SetReadOnly makes Add, Remove, Commit and Push fail with ErrReadOnly, so a
+// read-only machine cannot commit or push even through a missed check.
+func (g *GitService) SetReadOnly(v bool) {
+	g.readOnly = v
+}
+
+// StatusEntry is one path reported by git status.
+type StatusEntry struct {
+	// Path is relative to the repo root; untracked directories end in "/".
+	Path string
+	// OrigPath is the path a renamed or copied entry came from.
+	OrigPath string
+	// Staged and Unstaged are git's X and Y status letters for the index and
+	// the work tree, e.g. 'M', 'A', 'D' or 'R', and '.' when unchanged.
+	Staged   byte
+	Unstaged byte
+	// Conflict is set for unmerged entries; Staged and Unstaged then describe
+	// what each side did, e.g. 'U' and 'U' when both modified the file.
+	Conflict  bool
+	Untracked bool
+	Ignored   bool
+}
+
+// Renamed reports whether e was staged as a rename of OrigPath. Copies also
+// carry an OrigPath but leave it in place.
+func (e StatusEntry) Renamed() bool {
+	return e.Staged == 'R'
+}
+
+// Deleted reports whether the file is gone from the work tree.
+func (e StatusEntry) Deleted() bool {
+	return !e.Conflict && (e.Unstaged == 'D' || e.Staged == 'D' && e.Unstaged == '.')
+}
+
+// Status returns the changed, untracked and unmerged paths in the repo at dir.
+// Uses porcelain v2 with NUL-terminated records, so paths are never quoted.
+func (g *GitService) Status(dir string) ([]StatusEntry, error) {
+	out, _, err := runGit(g.ExecCommand(dir, "status", "--porcelain=v2", "-z"))
 	if err != nil {
 		return nil, err
 	}
-	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
-	var files []string
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" {
+	return parseStatus(out)
+}
+
+// parseStatus parses the output of git status --porcelain=v2 -z:
+//
+//	1 <XY> <sub> <mH> <mI> <mW> <hH> <hI> <path>
+//	2 <XY> <sub> <mH> <mI> <mW> <hH> <hI> <X><score> <path> NUL <origPath>
+//	u <XY> <sub> <m1> <m2> <m3> <mW> <h1> <h2> <h3> <path>
+//	? <path>
+//	! <path>
+//
+// each terminated by NUL. Header lines (# ...) are skipped.
+func parseStatus(out []byte) ([]StatusEntry, error) {
+	records := strings.Split(string(out), "\x00")
+	var entries []StatusEntry
+	for i := 0; i < len(records); i++ {
+		record := records[i]
+		if record == "" || record[0] == '#' {
 			continue
 		}
-		// Porcelain v2 format:
-		// 1 <XY> <sub> <mH> <mI> <mW> <hH> <hI> <path> [<orig-path>]
-		// ? <path>
-		switch line[0] {
-		case '?':
-			if len(line) > 2 {
-				files = append(files, strings.TrimSpace(line[1:]))
+		var n int
+		switch record[0] {
+		case '?', '!':
+			if len(record) < 3 {
+				return nil, fmt.Errorf("malformed status record %q", record)
 			}
+			entries = append(entries, StatusEntry{
+				Path:      record[2:],
+				Staged:    '.',
+				Unstaged:  '.',
+				Untracked: record[0] == '?',
+				Ignored:   record[0] == '!',
+			})
+			continue
 		case '1':
-			fields := strings.Fields(line)
-			if len(fields) >= 9 {
-				xy := fields[1]
-				// Include if either staged or unstaged status is non-clean.
-				if len(xy) >= 2 && (xy[0] != '.' || xy[1] != '.') {
-					path := fields[8]
-					files = append(files, path)
-				}
+			n = 9
+		case '2':
+			n = 10
+		case 'u':
+			n = 11
+		default:
+			return nil, fmt.Errorf("unknown status record %q", record)
+		}
+		fields := strings.SplitN(record, " ", n)
+		if len(fields) != n || len(fields[1]) != 2 || fields[n-1] == "" {
+			return nil, fmt.Errorf("malformed status record %q", record)
+		}
+		e := StatusEntry{
+			Path:     fields[n-1],
+			Staged:   fields[1][0],
+			Unstaged: fields[1][1],
+			Conflict: record[0] == 'u',
+		}
+		if record[0] == '2' {
+			// The original path is the next NUL-terminated field.
+			i++
+			if i >= len(records) || records[i] == "" {
+				return nil, fmt.Errorf("status record %q lacks its original path", record)
 			}
+			e.OrigPath = records[i]
 		}
+		entries = append(entries, e)
 	}
-	return files, nil
+	return entries, nil
+}
+
+// AheadBehind returns how many commits HEAD is ahead of and behind its
+// upstream branch in the repo at dir. It fails when no upstream is configured.
+func (g *GitService) AheadBehind(dir string) (int, int, error) {
+	out, _, err := runGit(g.ExecCommand(dir, "rev-list", "--left-right", "--count", "HEAD...@{upstream}"))
+	if err != nil {
+		return 0, 0, err
+	}
+	var ahead, behind int
+	if _, err := fmt.Sscanf(strings.TrimSpace(string(out)), "%d\t%d", &ahead, &behind); err != nil {
+		return 0, 0, fmt.Errorf("unexpected rev-list output %q: %w", out, err)
+	}
+	return ahead, behind, nil
 }
 
 // Add stages the given files in the repo at dir.
@@ -71,31 +200,341 @@ func (g *GitService) Add(dir string, files []string) error {
 	if len(files) == 0 {
 		return nil
 	}
+	if g.readOnly {
+		return ErrReadOnly
+	}
 	args := append([]string{"add"}, files...)
-	cmd := g.ExecCommand(dir, args...)
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("git add failed: %w\n%s", err, string(out))
+	_, _, err := runGit(g.ExecCommand(dir, args...))
+	return err
+}
+
+// Remove deletes the given paths (recursively) from the index and working
+// tree of the repo at dir. Paths that are not tracked are skipped.
+func (g *GitService) Remove(dir string, paths []string) error {
+	if len(paths) == 0 {
+		return nil
 	}
-	return nil
+	if g.readOnly {
+		return ErrReadOnly
+	}
+	args := append([]string{"rm", "-r", "-q", "--ignore-unmatch", "--"}, paths...)
+	_, _, err := runGit(g.ExecCommand(dir, args...))
+	return err
 }
 
-// Commit creates a commit with the given message in the repo at dir.
+// Commit creates a commit with the given message in the repo at dir. It fails
+// with ErrNothingToCommit when nothing is staged, and with the hook's output
+// when a commit hook rejects it.
 func (g *GitService) Commit(dir, message string) error {
-	cmd := g.ExecCommand(dir, "commit", "-m", message)
-	return cmd.Run()
+	if g.readOnly {
+		return ErrReadOnly
+	}
+	_, _, err := runGit(g.ExecCommand(dir, "commit", "-m", message))
+	return err
 }
 
-// PullRebase performs a git pull --rebase in the repo at dir and returns output and error.
+// StagedChanges lists the files staged in the repo at dir, with their status
+// and diffstat, in path order. Renames are reported as a deletion and an
+// addition.
+func (g *GitService) StagedChanges(dir string) ([]FileChange, error) {
+	out, _, err := runGit(g.ExecCommand(dir, "diff", "--cached", "--no-renames", "-z", "--name-status"))
+	if err != nil {
+		return nil, err
+	}
+	var changes []FileChange
+	index := make(map[string]int)
+	fields := strings.Split(strings.TrimSuffix(string(out), "\x00"), "\x00")
+	for i := 0; i+1 < len(fields); i += 2 {
+		if fields[i] == "" {
+			continue
+		}
+		index[fields[i+1]] = len(changes)
+		changes = append(changes, FileChange{Path: fields[i+1], Status: fields[i][0]})
+	}
+
+	out, _, err = runGit(g.ExecCommand(dir, "diff", "--cached", "--no-renames", "-z", "--numstat"))
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range strings.Split(string(out), "\x00") {
+		parts := strings.SplitN(record, "\t", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		i, ok := index[parts[2]]
+		if !ok {
+			continue
+		}
+		if parts[0] == "-" {
+			changes[i].Binary = true
+			continue
+		}
+		changes[i].Added, _ = strconv.Atoi(parts[0])
+		changes[i].Deleted, _ = strconv.Atoi(parts[1])
+	}
+	return changes, nil
+}
+
+// MergeFile runs a three-way merge of current and other against base using
+// git merge-file semantics, without touching any of the input files. It returns
+// the merged content and the number of conflicts marked in it. labels name the
+// current, base and other sides in the conflict markers.
+func (g *GitService) MergeFile(current, base, other string, labels [3]string) ([]byte, int, error) {
+	cmd := g.ExecCommand("", "merge-file", "-p",
+		"-L", labels[0], "-L", labels[1], "-L", labels[2],
+		current, base, other)
+	out, _, err := runGit(cmd)
+	if err == nil {
+		return out, 0, nil
+	}
+	// merge-file exits with the number of conflicts; 128 and up are failures.
+	var gitErr *GitError
+	if errors.As(err, &gitErr) && gitErr.ExitCode > 0 && gitErr.ExitCode < 128 {
+		return out, gitErr.ExitCode, nil
+	}
+	return nil, 0, err
+}
+
+// PullRebase performs a git pull --rebase in the repo at dir and returns its
+// output. Failures carry ErrRebaseConflict, ErrNoUpstream or ErrAuthFailed
+// when recognised.
 func (g *GitService) PullRebase(dir string) ([]byte, error) {
-	cmd := g.ExecCommand(dir, "pull", "--rebase")
-	return cmd.CombinedOutput()
+	stdout, stderr, err := runGit(g.ExecCommand(dir, "pull", "--rebase"))
+	return append(stdout, stderr...), err
 }
 
-// Push performs a git push in the repo at dir and returns output and error.
+// Fetch downloads the remote's commits into the repo at dir without changing
+// the branch. Failures carry ErrAuthFailed when recognised.
+func (g *GitService) Fetch(dir string) error {
+	_, _, err := runGit(g.ExecCommand(dir, "fetch", "--quiet"))
+	return err
+}
+
+// Rebase replays the local commits of the repo at dir onto onto, e.g.
+// "@{upstream}" after a Fetch. Uncommitted changes are stashed and restored
+// around it. Failures carry ErrRebaseConflict or ErrNoUpstream when
+// recognised.
+func (g *GitService) Rebase(dir, onto string) error {
+	_, _, err := runGit(g.ExecCommand(dir, "rebase", "--autostash", onto))
+	return err
+}
+
+// Push performs a git push in the repo at dir and returns its output. Failures
+// carry ErrNoUpstream, ErrAuthFailed or ErrPushRejected when recognised.
 func (g *GitService) Push(dir string) ([]byte, error) {
-	cmd := g.ExecCommand(dir, "push")
-	return cmd.CombinedOutput()
+	if g.readOnly {
+		return nil, ErrReadOnly
+	}
+	stdout, stderr, err := runGit(g.ExecCommand(dir, "push"))
+	return append(stdout, stderr...), err
+}
+
+// RebaseInProgress reports whether the repo at dir is in the middle of a
+// rebase, e.g. one a pull stopped on conflicts.
+func (g *GitService) RebaseInProgress(dir string) (bool, error) {
+	for _, name := range []string{"rebase-merge", "rebase-apply"} {
+		out, _, err := runGit(g.ExecCommand(dir, "rev-parse", "--git-path", name))
+		if err != nil {
+			return false, err
+		}
+		path := strings.TrimSpace(string(out))
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		if _, err := os.Stat(path); err == nil {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// ConflictedFiles lists the unmerged paths in the repo at dir, relative to
+// the repo root.
+func (g *GitService) ConflictedFiles(dir string) ([]string, error) {
+	out, _, err := runGit(g.ExecCommand(dir, "diff", "--name-only", "--diff-filter=U", "-z"))
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, f := range strings.Split(string(out), "\x00") {
+		if f != "" {
+			files = append(files, f)
+		}
+	}
+	return files, nil
+}
+
+// Merge stages of an unmerged path. During a rebase StageOurs is the branch
+// being rebased onto (the remote) and StageTheirs the local commit being
+// replayed.
+const (
+	StageBase   = 1
+	StageOurs   = 2
+	StageTheirs = 3
+)
+
+// ShowStage returns the content of path (relative to the repo root) at the
+// given merge stage, and false when that side deleted the file.
+func (g *GitService) ShowStage(dir string, stage int, path string) ([]byte, bool, error) {
+	return g.showBlob(dir, fmt.Sprintf(":%d:%s", stage, path))
+}
+
+// StageMode returns the permissions git records for path (relative to the
+// repo root) at the given merge stage: 0755 for executables, 0644 for other
+// files, and 0 when that side has no such file.
+func (g *GitService) StageMode(dir string, stage int, path string) (os.FileMode, error) {
+	out, _, err := runGit(g.ExecCommand(dir, "ls-files", "--stage", "-z", "--", path))
+	if err != nil {
+		return 0, err
+	}
+	want := strconv.Itoa(stage)
+	for _, record := range strings.Split(string(out), "\x00") {
+		// "<mode> <object> <stage>\t<path>"
+		meta, _, ok := strings.Cut(record, "\t")
+		fields := strings.Fields(meta)
+		if !ok || len(fields) != 3 || fields[2] != want {
+			continue
+		}
+		if fields[0] == "100755" {
+			return 0755, nil
+		}
+		return 0644, nil
+	}
+	return 0, nil
+}
+
+// ShowFile returns the content of path (relative to the repo root) at rev, and
+// false when the file does not exist there.
+func (g *GitService) ShowFile(dir, rev, path string) ([]byte, bool, error) {
+	return g.showBlob(dir, rev+":"+path)
+}
+
+// missingObjectPhrases are what git cat-file -e says when spec names no
+// object, as opposed to git itself failing. Output is matched lower-cased.
+var missingObjectPhrases = []string{"does not exist", "not at stage", "but not in", "invalid object name", "not a valid object name", "bad object"}
+
+func (g *GitService) showBlob(dir, spec string) ([]byte, bool, error) {
+	if _, _, err := runGit(g.ExecCommand(dir, "cat-file", "-e", spec)); objectMissing(err) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, fmt.Errorf("reading %s: %w", spec, err)
+	}
+	out, _, err := runGit(g.ExecCommand(dir, "cat-file", "blob", spec))
+	if err != nil {
+		return nil, false, err
+	}
+	if out == nil {
+		out = []byte{}
+	}
+	return out, true, nil
+}
+
+// objectMissing reports whether err is git cat-file -e finding no object: it
+// exits 1 for a missing object id, and 128 for a rev, path or stage that
+// names nothing.
+func objectMissing(err error) bool {
+	var gitErr *GitError
+	if !errors.As(err, &gitErr) {
+		return false
+	}
+	if gitErr.ExitCode == 1 {
+		return true
+	}
+	if gitErr.ExitCode != 128 || gitErr.Kind != nil {
+		return false
+	}
+	stderr := strings.ToLower(gitErr.Stderr)
+	for _, phrase := range missingObjectPhrases {
+		if strings.Contains(stderr, phrase) {
+			return true
+		}
+	}
+	return false
+}
+
+// ResolveRev returns the full hash of the commit rev names in the repo at dir.
+func (g *GitService) ResolveRev(dir, rev string) (string, error) {
+	out, _, err := runGit(g.ExecCommand(dir, "rev-parse", "--verify", "--quiet", rev+"^{commit}"))
+	var gitErr *GitError
+	if errors.As(err, &gitErr) && gitErr.ExitCode == 1 {
+		return "", fmt.Errorf("unknown revision %q", rev)
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// Commit describes one commit in a file's history.
+type Commit struct {
+	Hash    string
+	Short   string
+	Author  string
+	Date    time.Time
+	Subject string
+	// Files lists the given paths the commit touched, relative to the repo root.
+	Files []string
+}
+
+// Log returns the commits reachable from HEAD that touched any of paths
+// (relative to the repo root), newest first. limit caps the number of
+// commits; 0 means no limit.
+func (g *GitService) Log(dir string, paths []string, limit int) ([]Commit, error) {
+	// -z keeps paths unquoted: each commit is its header followed by its
+	// NUL-terminated file names.
+	args := []string{"log", "--format=%x1e%H%x1f%h%x1f%an%x1f%aI%x1f%s", "--name-only", "-z"}
+	if limit > 0 {
+		args = append(args, fmt.Sprintf("-n%d", limit))
+	}
+	args = append(append(args, "--"), paths...)
+	out, _, err := runGit(g.ExecCommand(dir, args...))
+	if err != nil {
+		return nil, err
+	}
+	var commits []Commit
+	for _, record := range strings.Split(string(out), "\x1e") {
+		parts := strings.Split(record, "\x00")
+		fields := strings.Split(parts[0], "\x1f")
+		if len(fields) != 5 {
+			continue
+		}
+		date, _ := time.Parse(time.RFC3339, fields[3])
+		c := Commit{Hash: fields[0], Short: fields[1], Author: fields[2], Date: date, Subject: fields[4]}
+		for _, f := range parts[1:] {
+			if f = strings.TrimPrefix(f, "\n"); f != "" {
+				c.Files = append(c.Files, f)
+			}
+		}
+		commits = append(commits, c)
+	}
+	return commits, nil
+}
+
+// RebaseContinue resumes the rebase in the repo at dir once the conflicts are
+// resolved and staged, keeping each commit's message. It fails with
+// ErrRebaseConflict when the next commit conflicts too, and with
+// ErrNothingToCommit when the resolution left the commit empty.
+func (g *GitService) RebaseContinue(dir string) error {
+	if g.readOnly {
+		return ErrReadOnly
+	}
+	cmd := g.ExecCommand(dir, "rebase", "--continue")
+	cmd.Env = append(os.Environ(), "GIT_EDITOR=true")
+	_, _, err := runGit(cmd)
+	return err
+}
+
+// RebaseSkip drops the commit the rebase in dir stopped on and resumes.
+func (g *GitService) RebaseSkip(dir string) error {
+	_, _, err := runGit(g.ExecCommand(dir, "rebase", "--skip"))
+	return err
+}
+
+// RebaseAbort stops the rebase in the repo at dir and restores the branch to
+// where it was before the pull.
+func (g *GitService) RebaseAbort(dir string) error {
+	_, _, err := runGit(g.ExecCommand(dir, "rebase", "--abort"))
+	return err
 }
 
 // CloneRepo clones a git repo to the target directory.
@@ -109,10 +548,8 @@ func (g *GitService) CloneRepo(repoURL, targetDir string, depth1, noCheckout boo
 		args = append(args, "--no-checkout")
 	}
 	args = append(args, repoURL, targetDir)
-	cmd := g.ExecCommand("", args...)
-	cmd.Stdout = nil
-	cmd.Stderr = nil
-	return cmd.Run()
+	_, _, err := runGit(g.ExecCommand("", args...))
+	return err
 }
 
 // IsRemoteGitRepo returns true if the given URL is a valid git repository.
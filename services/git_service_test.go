@@ -0,0 +1,274 @@
+package services
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGitService_ReadOnly(t *testing.T) {
+	t.Parallel()
+
+	git := NewGitService()
+	git.SetReadOnly(true)
+	dir := t.TempDir()
+	if err := git.Add(dir, []string{"home/.zshrc"}); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Add: %v", err)
+	}
+	if err := git.Remove(dir, []string{"home/.zshrc"}); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Remove: %v", err)
+	}
+	if err := git.Commit(dir, "msg"); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Commit: %v", err)
+	}
+	if _, err := git.Push(dir); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Push: %v", err)
+	}
+}
+
+func TestGitService_Errors(t *testing.T) {
+	t.Parallel()
+
+	git := NewGitService()
+	dir := t.TempDir()
+	_, err := git.Status(dir)
+	var gitErr *GitError
+	if !errors.Is(err, ErrNotARepo) || !errors.As(err, &gitErr) || gitErr.ExitCode == 0 || gitErr.Command() != "git status --porcelain=v2 -z" {
+		t.Fatalf("Status outside a repo: %#v", err)
+	}
+
+	writeFile(t, filepath.Join(dir, "README"), "dotfiles\n")
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"config", "user.name", "Test"},
+		{"config", "user.email", "test@example.com"},
+		{"add", "README"},
+		{"commit", "-q", "-m", "initial"},
+	} {
+		if _, _, err := runGit(git.ExecCommand(dir, args...)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := git.Commit(dir, "empty"); !errors.Is(err, ErrNothingToCommit) {
+		t.Errorf("Commit with nothing staged: %v", err)
+	}
+	if _, err := git.PullRebase(dir); !errors.Is(err, ErrNoUpstream) {
+		t.Errorf("PullRebase without upstream: %v", err)
+	}
+}
+
+func TestGitService_RebaseConflict(t *testing.T) {
+	t.Parallel()
+
+	git := NewGitService()
+	dir := t.TempDir()
+	remote := filepath.Join(dir, "remote.git")
+	local := filepath.Join(dir, "local")
+	other := filepath.Join(dir, "other")
+	mustGit := func(dir string, args ...string) {
+		t.Helper()
+		if _, _, err := runGit(git.ExecCommand(dir, args...)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	commit := func(repo, content string) {
+		t.Helper()
+		writeFile(t, filepath.Join(repo, ".zshrc"), content)
+		mustGit(repo, "add", ".zshrc")
+		mustGit(repo, "commit", "-q", "-m", content)
+	}
+	clone := func(repo string) {
+		t.Helper()
+		mustGit(dir, "clone", "-q", remote, repo)
+		mustGit(repo, "config", "user.name", "Test")
+		mustGit(repo, "config", "user.email", "test@example.com")
+	}
+	mustGit(dir, "init", "-q", "--bare", remote)
+	clone(local)
+	commit(local, "base\n")
+	mustGit(local, "push", "-q", "-u", "origin", "HEAD")
+	clone(other)
+	commit(other, "remote\n")
+	mustGit(other, "push", "-q")
+	// The local side also makes the file executable.
+	writeFile(t, filepath.Join(local, ".zshrc"), "local\n")
+	if err := os.Chmod(filepath.Join(local, ".zshrc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	mustGit(local, "commit", "-q", "-a", "-m", "local")
+
+	if _, err := git.Push(local); !errors.Is(err, ErrPushRejected) {
+		t.Fatalf("Push behind the remote: %v", err)
+	}
+	if _, err := git.PullRebase(local); !errors.Is(err, ErrRebaseConflict) {
+		t.Fatalf("PullRebase: %v", err)
+	}
+	if rebasing, err := git.RebaseInProgress(local); err != nil || !rebasing {
+		t.Fatalf("RebaseInProgress = %v, %v", rebasing, err)
+	}
+	files, err := git.ConflictedFiles(local)
+	if err != nil || len(files) != 1 || files[0] != ".zshrc" {
+		t.Fatalf("ConflictedFiles = %v, %v", files, err)
+	}
+	ours, _, _ := git.ShowStage(local, StageOurs, ".zshrc")
+	theirs, _, _ := git.ShowStage(local, StageTheirs, ".zshrc")
+	if string(ours) != "remote\n" || string(theirs) != "local\n" {
+		t.Errorf("stages = %q, %q", ours, theirs)
+	}
+	for _, tt := range []struct {
+		stage int
+		path  string
+		want  os.FileMode
+	}{
+		{StageOurs, ".zshrc", 0644},
+		{StageTheirs, ".zshrc", 0755},
+		{StageTheirs, ".missing", 0},
+	} {
+		if mode, err := git.StageMode(local, tt.stage, tt.path); err != nil || mode != tt.want {
+			t.Errorf("StageMode(%d, %s) = %v, %v, want %v", tt.stage, tt.path, mode, err, tt.want)
+		}
+	}
+	if err := git.RebaseAbort(local); err != nil {
+		t.Fatal(err)
+	}
+	if rebasing, _ := git.RebaseInProgress(local); rebasing {
+		t.Errorf("rebase still in progress after abort")
+	}
+}
+
+func TestGitService_History(t *testing.T) {
+	t.Parallel()
+
+	git := NewGitService()
+	dir := t.TempDir()
+	mustGit := func(args ...string) {
+		t.Helper()
+		if _, _, err := runGit(git.ExecCommand(dir, args...)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mustGit("init", "-q")
+	mustGit("config", "user.name", "Test")
+	mustGit("config", "user.email", "test@example.com")
+	if err := os.MkdirAll(filepath.Join(dir, "home"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, content := range []string{"one\n", "two\n"} {
+		writeFile(t, filepath.Join(dir, "home", ".zshrc"), content)
+		mustGit("add", "home")
+		mustGit("commit", "-q", "-m", "zshrc "+content)
+	}
+	writeFile(t, filepath.Join(dir, "home", ".vimrc"), "set nu\n")
+	mustGit("add", "home")
+	mustGit("commit", "-q", "-m", "vimrc")
+
+	commits, err := git.Log(dir, []string{"home/.zshrc"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(commits) != 2 || commits[0].Subject != "zshrc two" || len(commits[0].Files) != 1 || commits[0].Files[0] != "home/.zshrc" {
+		t.Fatalf("Log = %+v", commits)
+	}
+	if limited, _ := git.Log(dir, []string{"home/.zshrc"}, 1); len(limited) != 1 {
+		t.Errorf("Log with limit 1 returned %d commits", len(limited))
+	}
+
+	data, ok, err := git.ShowFile(dir, commits[1].Hash, "home/.zshrc")
+	if err != nil || !ok || string(data) != "one\n" {
+		t.Errorf("ShowFile = %q, %v, %v", data, ok, err)
+	}
+	if _, ok, err := git.ShowFile(dir, commits[1].Hash, "home/.vimrc"); err != nil || ok {
+		t.Errorf("ShowFile of a missing file = %v, %v", ok, err)
+	}
+	if _, ok, err := git.ShowFile(dir, commits[1].Hash+"^", "home/.zshrc"); err != nil || ok {
+		t.Errorf("ShowFile before the first commit = %v, %v", ok, err)
+	}
+	if _, ok, err := git.ShowFile(t.TempDir(), "HEAD", "home/.zshrc"); err == nil || ok {
+		t.Errorf("ShowFile outside a repo = %v, %v, want an error", ok, err)
+	}
+	if hash, err := git.ResolveRev(dir, "HEAD~2"); err != nil || hash != commits[1].Hash {
+		t.Errorf("ResolveRev(HEAD~2) = %q, %v", hash, err)
+	}
+	if _, err := git.ResolveRev(dir, "nope"); err == nil {
+		t.Errorf("ResolveRev of an unknown revision succeeded")
+	}
+	writeFile(t, filepath.Join(dir, "home", ".zshrc"), "two\nthree\n")
+	writeFile(t, filepath.Join(dir, "home", ".bashrc"), "bash\n")
+	mustGit("rm", "-q", "home/.vimrc")
+	mustGit("add", "home")
+	changes, err := git.StagedChanges(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []FileChange{
+		{Path: "home/.bashrc", Status: 'A', Added: 1},
+		{Path: "home/.vimrc", Status: 'D', Deleted: 1},
+		{Path: "home/.zshrc", Status: 'M', Added: 1},
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("StagedChanges = %+v, want %+v", changes, want)
+	}
+
+	// Paths git would quote come back as they are.
+	mustGit("commit", "-q", "-m", "more")
+	writeFile(t, filepath.Join(dir, "home", "café notes"), "notes\n")
+	mustGit("add", "home")
+	mustGit("commit", "-q", "-m", "notes")
+	commits, err = git.Log(dir, []string{"home"}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(commits) != 1 || len(commits[0].Files) != 1 || commits[0].Files[0] != "home/café notes" {
+		t.Fatalf("Log of a non-ASCII path = %+v", commits)
+	}
+}
+
+func TestParseStatus(t *testing.T) {
+	t.Parallel()
+
+	out := strings.Join([]string{
+		"# branch.oid 0123456789abcdef0123456789abcdef01234567",
+		"1 .M N... 100644 100644 100644 aaaaaaa aaaaaaa home/.zshrc",
+		"1 D. N... 100644 000000 000000 aaaaaaa 0000000 home/old file",
+		"2 R. N... 100644 100644 100644 aaaaaaa aaaaaaa R100 home/new name",
+		"home/old name",
+		"u UU N... 100644 100644 100644 100644 aaaaaaa bbbbbbb ccccccc home/.vimrc",
+		"? home/.config/new dir/",
+		"! home/.cache/",
+		"",
+	}, "\x00")
+	entries, err := parseStatus([]byte(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []StatusEntry{
+		{Path: "home/.zshrc", Staged: '.', Unstaged: 'M'},
+		{Path: "home/old file", Staged: 'D', Unstaged: '.'},
+		{Path: "home/new name", OrigPath: "home/old name", Staged: 'R', Unstaged: '.'},
+		{Path: "home/.vimrc", Staged: 'U', Unstaged: 'U', Conflict: true},
+		{Path: "home/.config/new dir/", Staged: '.', Unstaged: '.', Untracked: true},
+		{Path: "home/.cache/", Staged: '.', Unstaged: '.', Ignored: true},
+	}
+	if !reflect.DeepEqual(entries, want) {
+		t.Fatalf("parseStatus =\n%+v\nwant\n%+v", entries, want)
+	}
+	if !entries[1].Deleted() || entries[0].Deleted() || entries[3].Deleted() {
+		t.Errorf("Deleted() wrong for %+v", entries[:4])
+	}
+	if !entries[2].Renamed() || entries[0].Renamed() {
+		t.Errorf("Renamed() wrong for %+v", entries[:3])
+	}
+
+	for _, bad := range []string{
+		"1 .M N... 100644 home/.zshrc\x00",
+		"2 R. N... 100644 100644 100644 aaaaaaa aaaaaaa R100 home/new\x00",
+		"x what\x00",
+	} {
+		if _, err := parseStatus([]byte(bad)); err == nil {
+			t.Errorf("parseStatus(%q) succeeded", bad)
+		}
+	}
+}
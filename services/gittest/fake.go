@@ -0,0 +1,309 @@
+// Package gittest provides a scripted services.Git for command tests. It is
+// only imported from tests, so it stays out of the dotman binary.
+package gittest
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"dotman/services"
+)
+
+// Fake is a scripted services.Git. It records every call and never runs git:
+// results come from its fields, and failures are queued with Fail.
+type Fake struct {
+	// Calls lists the calls made, in order, as the method name followed by
+	// its arguments, e.g. "Add /repo home/.zshrc".
+	Calls []string
+
+	// Rebasing is what RebaseInProgress reports. A PullRebase or Rebase
+	// failing with services.ErrRebaseConflict sets it; RebaseAbort and a
+	// successful RebaseContinue or RebaseSkip clear it.
+	Rebasing bool
+	// Conflicts is what ConflictedFiles reports while Rebasing.
+	Conflicts []string
+	// Stages holds the content of conflicted paths by stage, e.g.
+	// Stages[services.StageOurs]["home/.zshrc"]; a missing path was deleted.
+	Stages map[int]map[string][]byte
+	// StageModes holds the modes StageMode reports, keyed like Stages.
+	StageModes map[int]map[string]os.FileMode
+	// Files holds file contents by "rev:path" for ShowFile.
+	Files map[string][]byte
+	// Revs maps revisions to the hashes ResolveRev returns; unknown ones fail.
+	Revs map[string]string
+	// Merged and MergeConflicts, when Merged is set, are what MergeFile
+	// returns.
+	Merged         []byte
+	MergeConflicts int
+
+	StatusEntries []services.StatusEntry
+	Commits       []services.Commit
+	Ahead, Behind int
+	// Staged is what StagedChanges reports.
+	Staged []services.FileChange
+	// Committed collects the messages of successful commits.
+	Committed []string
+
+	verbose  bool
+	readOnly bool
+	failures map[string][]error
+}
+
+// NewFake returns a Fake on which every call succeeds.
+func NewFake() *Fake {
+	return &Fake{failures: map[string][]error{}}
+}
+
+var _ services.Git = (*Fake)(nil)
+
+// Fail makes the next call of method fail with err. A sentinel such as
+// services.ErrPushRejected is wrapped in a *services.GitError the way
+// GitService reports it. Queued failures are used up in order, after which
+// calls succeed.
+func (f *Fake) Fail(method string, err error) *Fake {
+	f.failures[method] = append(f.failures[method], err)
+	return f
+}
+
+// Called reports how many times method was called.
+func (f *Fake) Called(method string) int {
+	n := 0
+	for _, c := range f.Calls {
+		if c == method || strings.HasPrefix(c, method+" ") {
+			n++
+		}
+	}
+	return n
+}
+
+func (f *Fake) call(method string, args ...string) error {
+	f.Calls = append(f.Calls, strings.TrimSpace(method+" "+strings.Join(args, " ")))
+	queue := f.failures[method]
+	if len(queue) == 0 {
+		return nil
+	}
+	err := queue[0]
+	f.failures[method] = queue[1:]
+	var gitErr *services.GitError
+	if errors.As(err, &gitErr) || errors.Is(err, services.ErrReadOnly) {
+		return err
+	}
+	return &services.GitError{
+		Args:     []string{strings.ToLower(method)},
+		ExitCode: 1,
+		Stderr:   err.Error(),
+		Kind:     err,
+		Err:      fmt.Errorf("exit status 1"),
+	}
+}
+
+func (f *Fake) SetVerbose(v bool) {
+	f.verbose = v
+}
+
+func (f *Fake) SetReadOnly(v bool) {
+	f.readOnly = v
+}
+
+func (f *Fake) Status(dir string) ([]services.StatusEntry, error) {
+	if err := f.call("Status", dir); err != nil {
+		return nil, err
+	}
+	return f.StatusEntries, nil
+}
+
+func (f *Fake) AheadBehind(dir string) (int, int, error) {
+	if err := f.call("AheadBehind", dir); err != nil {
+		return 0, 0, err
+	}
+	return f.Ahead, f.Behind, nil
+}
+
+func (f *Fake) Add(dir string, files []string) error {
+	if len(files) == 0 {
+		return nil
+	}
+	if f.readOnly {
+		return services.ErrReadOnly
+	}
+	return f.call("Add", append([]string{dir}, files...)...)
+}
+
+func (f *Fake) Remove(dir string, paths []string) error {
+	if len(paths) == 0 {
+		return nil
+	}
+	if f.readOnly {
+		return services.ErrReadOnly
+	}
+	return f.call("Remove", append([]string{dir}, paths...)...)
+}
+
+func (f *Fake) Commit(dir, message string) error {
+	if f.readOnly {
+		return services.ErrReadOnly
+	}
+	if err := f.call("Commit", dir); err != nil {
+		return err
+	}
+	f.Committed = append(f.Committed, message)
+	f.Ahead++
+	return nil
+}
+
+func (f *Fake) StagedChanges(dir string) ([]services.FileChange, error) {
+	if err := f.call("StagedChanges", dir); err != nil {
+		return nil, err
+	}
+	return f.Staged, nil
+}
+
+// MergeFile has no real merge behind it: it returns Merged when set, and
+// otherwise the content of current with no conflicts.
+func (f *Fake) MergeFile(current, base, other string, labels [3]string) ([]byte, int, error) {
+	if err := f.call("MergeFile", current, base, other); err != nil {
+		return nil, 0, err
+	}
+	if f.Merged != nil {
+		return f.Merged, f.MergeConflicts, nil
+	}
+	data, err := os.ReadFile(current)
+	return data, 0, err
+}
+
+func (f *Fake) PullRebase(dir string) ([]byte, error) {
+	if err := f.call("PullRebase", dir); err != nil {
+		if errors.Is(err, services.ErrRebaseConflict) {
+			f.Rebasing = true
+		}
+		return nil, err
+	}
+	f.Behind = 0
+	return nil, nil
+}
+
+func (f *Fake) Fetch(dir string) error {
+	return f.call("Fetch", dir)
+}
+
+// Rebase sets Rebasing when it fails with services.ErrRebaseConflict, like
+// PullRebase.
+func (f *Fake) Rebase(dir, onto string) error {
+	if err := f.call("Rebase", dir, onto); err != nil {
+		if errors.Is(err, services.ErrRebaseConflict) {
+			f.Rebasing = true
+		}
+		return err
+	}
+	f.Behind = 0
+	return nil
+}
+
+func (f *Fake) Push(dir string) ([]byte, error) {
+	if f.readOnly {
+		return nil, services.ErrReadOnly
+	}
+	if err := f.call("Push", dir); err != nil {
+		return nil, err
+	}
+	f.Ahead = 0
+	return nil, nil
+}
+
+func (f *Fake) RebaseInProgress(dir string) (bool, error) {
+	if err := f.call("RebaseInProgress", dir); err != nil {
+		return false, err
+	}
+	return f.Rebasing, nil
+}
+
+func (f *Fake) ConflictedFiles(dir string) ([]string, error) {
+	if err := f.call("ConflictedFiles", dir); err != nil {
+		return nil, err
+	}
+	if !f.Rebasing {
+		return nil, nil
+	}
+	return f.Conflicts, nil
+}
+
+func (f *Fake) ShowStage(dir string, stage int, path string) ([]byte, bool, error) {
+	if err := f.call("ShowStage", dir, fmt.Sprint(stage), path); err != nil {
+		return nil, false, err
+	}
+	data, ok := f.Stages[stage][path]
+	return data, ok, nil
+}
+
+func (f *Fake) StageMode(dir string, stage int, path string) (os.FileMode, error) {
+	if err := f.call("StageMode", dir, fmt.Sprint(stage), path); err != nil {
+		return 0, err
+	}
+	return f.StageModes[stage][path], nil
+}
+
+func (f *Fake) RebaseContinue(dir string) error {
+	if f.readOnly {
+		return services.ErrReadOnly
+	}
+	if err := f.call("RebaseContinue", dir); err != nil {
+		return err
+	}
+	f.Rebasing = false
+	return nil
+}
+
+func (f *Fake) RebaseSkip(dir string) error {
+	if err := f.call("RebaseSkip", dir); err != nil {
+		return err
+	}
+	f.Rebasing = false
+	return nil
+}
+
+func (f *Fake) RebaseAbort(dir string) error {
+	if err := f.call("RebaseAbort", dir); err != nil {
+		return err
+	}
+	f.Rebasing = false
+	return nil
+}
+
+func (f *Fake) ShowFile(dir, rev, path string) ([]byte, bool, error) {
+	if err := f.call("ShowFile", dir, rev, path); err != nil {
+		return nil, false, err
+	}
+	data, ok := f.Files[rev+":"+path]
+	return data, ok, nil
+}
+
+func (f *Fake) ResolveRev(dir, rev string) (string, error) {
+	if err := f.call("ResolveRev", dir, rev); err != nil {
+		return "", err
+	}
+	hash, ok := f.Revs[rev]
+	if !ok {
+		return "", fmt.Errorf("unknown revision %q", rev)
+	}
+	return hash, nil
+}
+
+func (f *Fake) Log(dir string, paths []string, limit int) ([]services.Commit, error) {
+	if err := f.call("Log", append([]string{dir}, paths...)...); err != nil {
+		return nil, err
+	}
+	if limit > 0 && limit < len(f.Commits) {
+		return f.Commits[:limit], nil
+	}
+	return f.Commits, nil
+}
+
+func (f *Fake) CloneRepo(repoURL, targetDir string, depth1, noCheckout bool) error {
+	return f.call("CloneRepo", repoURL, targetDir)
+}
+
+func (f *Fake) IsRemoteGitRepo(url string) bool {
+	return f.call("IsRemoteGitRepo", url) == nil
+}
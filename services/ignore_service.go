@@ -0,0 +1,190 @@
+package services
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// IgnoreFileName is the name of the ignore file kept at the root of the dotfiles repo.
+const IgnoreFileName = ".dotmanignore"
+
+// defaultIgnorePatterns are always applied before any ignore file.
+var defaultIgnorePatterns = []string{".git/", ".history/"}
+
+// IgnoreRule is a single gitignore-style pattern and where it was defined.
+type IgnoreRule struct {
+	Source  string
+	Line    int
+	Pattern string
+	Negate  bool
+	dirOnly bool
+	re      *regexp.Regexp
+}
+
+// String formats the rule the way `git check-ignore -v` does: source:line:pattern.
+func (r *IgnoreRule) String() string {
+	return fmt.Sprintf("%s:%d:%s", r.Source, r.Line, r.Pattern)
+}
+
+// IgnoreMatcher matches paths relative to the home tree against gitignore-style
+// rules. As with git, the last matching rule wins and a "!" rule re-includes a
+// path, but nothing inside an ignored directory can be re-included.
+type IgnoreMatcher struct {
+	rules []*IgnoreRule
+}
+
+// NewIgnoreMatcher returns a matcher holding only the built-in rules.
+func NewIgnoreMatcher() *IgnoreMatcher {
+	m := &IgnoreMatcher{}
+	m.AddPatterns("<built-in>", defaultIgnorePatterns)
+	return m
+}
+
+// AddFile loads rules from a gitignore-syntax file. A missing file is not an error.
+func (m *IgnoreMatcher) AddFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+	var lines []string
+	scan := bufio.NewScanner(f)
+	for scan.Scan() {
+		lines = append(lines, scan.Text())
+	}
+	if err := scan.Err(); err != nil {
+		return err
+	}
+	m.AddPatterns(path, lines)
+	return nil
+}
+
+// AddPatterns adds rules from lines, attributing them to source.
+func (m *IgnoreMatcher) AddPatterns(source string, lines []string) {
+	for i, line := range lines {
+		if rule := parseIgnoreRule(line); rule != nil {
+			rule.Source = source
+			rule.Line = i + 1
+			m.rules = append(m.rules, rule)
+		}
+	}
+}
+
+// Match returns the rule deciding whether rel is ignored, or nil if no rule
+// applies. The path is ignored when the returned rule is not a negation.
+func (m *IgnoreMatcher) Match(rel string, isDir bool) *IgnoreRule {
+	if m == nil {
+		return nil
+	}
+	rel = strings.Trim(filepath.ToSlash(NormalizeRelPath(rel)), "/")
+	if rel == "" || rel == "." {
+		return nil
+	}
+	parts := strings.Split(rel, "/")
+	for i := 1; i < len(parts); i++ {
+		if rule := m.matchOne(strings.Join(parts[:i], "/"), true); rule != nil && !rule.Negate {
+			return rule
+		}
+	}
+	return m.matchOne(rel, isDir)
+}
+
+// Ignored reports whether rel is excluded by the rules.
+func (m *IgnoreMatcher) Ignored(rel string, isDir bool) bool {
+	rule := m.Match(rel, isDir)
+	return rule != nil && !rule.Negate
+}
+
+func (m *IgnoreMatcher) matchOne(rel string, isDir bool) *IgnoreRule {
+	for i := len(m.rules) - 1; i >= 0; i-- {
+		rule := m.rules[i]
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		if rule.re.MatchString(rel) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// parseIgnoreRule compiles one line of gitignore syntax, returning nil for
+// blank lines and comments.
+func parseIgnoreRule(line string) *IgnoreRule {
+	pattern := strings.TrimRight(line, " \t")
+	if strings.HasSuffix(line, "\\ ") {
+		pattern += " "
+	}
+	if pattern == "" || strings.HasPrefix(pattern, "#") {
+		return nil
+	}
+	rule := &IgnoreRule{Pattern: pattern}
+	if strings.HasPrefix(pattern, "!") {
+		rule.Negate = true
+		pattern = pattern[1:]
+	} else if strings.HasPrefix(pattern, "\\!") || strings.HasPrefix(pattern, "\\#") {
+		pattern = pattern[1:]
+	}
+	if strings.HasSuffix(pattern, "/") {
+		rule.dirOnly = true
+		pattern = strings.TrimRight(pattern, "/")
+	}
+	anchored := strings.Contains(pattern, "/")
+	pattern = strings.TrimPrefix(pattern, "/")
+	if pattern == "" {
+		return nil
+	}
+
+	var b strings.Builder
+	if anchored {
+		b.WriteString("^")
+	} else {
+		b.WriteString("^(?:.*/)?")
+	}
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			b.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**") && i+2 == len(pattern):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				b.WriteString(regexp.QuoteMeta(string(c)))
+				continue
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + strings.ReplaceAll(class, "\\", "\\\\") + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(pattern):
+			i++
+			b.WriteString(regexp.QuoteMeta(string(pattern[i])))
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	re, err := regexp.Compile(b.String())
+	if err != nil {
+		return nil
+	}
+	rule.re = re
+	return rule
+}
@@ -0,0 +1,54 @@
+package services
+
+import (
+	"testing"
+)
+
+func TestIgnoreMatcher(t *testing.T) {
+	t.Parallel()
+
+	m := NewIgnoreMatcher()
+	m.AddPatterns(".dotmanignore", []string{
+		"# comment",
+		"",
+		"*.log",
+		"!keep.log",
+		"__pycache__/",
+		"/.config/nvim/plugin/",
+		"**/lazy-lock.json",
+		".cache/**",
+		"tmp[0-9]",
+	})
+
+	tests := []struct {
+		path    string
+		isDir   bool
+		ignored bool
+	}{
+		{path: ".git", isDir: true, ignored: true},
+		{path: ".config/nvim/.git/config", ignored: true},
+		{path: "debug.log", ignored: true},
+		{path: ".config/app/debug.log", ignored: true},
+		{path: "keep.log", ignored: false},
+		{path: ".config/fish/__pycache__", isDir: true, ignored: true},
+		{path: ".config/fish/__pycache__/a.pyc", ignored: true},
+		{path: "__pycache__", isDir: false, ignored: false},
+		{path: ".config/nvim/plugin/packer.lua", ignored: true},
+		{path: "other/.config/nvim/plugin/packer.lua", ignored: false},
+		{path: ".config/nvim/lazy-lock.json", ignored: true},
+		{path: ".cache/foo/bar", ignored: true},
+		{path: "tmp1", ignored: true},
+		{path: "tmpx", ignored: false},
+		{path: ".zshrc", ignored: false},
+	}
+	for _, tt := range tests {
+		if got := m.Ignored(tt.path, tt.isDir); got != tt.ignored {
+			t.Errorf("Ignored(%q, %v)=%v, want %v", tt.path, tt.isDir, got, tt.ignored)
+		}
+	}
+
+	rule := m.Match("debug.log", false)
+	if rule == nil || rule.String() != ".dotmanignore:3:*.log" {
+		t.Fatalf("expected rule .dotmanignore:3:*.log, got %v", rule)
+	}
+}
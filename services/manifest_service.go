@@ -0,0 +1,532 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ManifestFileName is the name of the manifest kept at the root of the dotfiles repo.
+const ManifestFileName = "dotman.json"
+
+// ManifestVersion is the manifest format written by this version of dotman.
+// Version 2 added entries.
+const ManifestVersion = 2
+
+// Entry describes a tracked file or directory, relative to the home tree.
+// Attributes of a directory entry apply to everything beneath it; the most
+// specific entry wins.
+type Entry struct {
+	Path string `json:"path"`
+	// Mode is the octal permission the home copy is written with, e.g. "0600".
+	Mode string `json:"mode,omitempty"`
+	// Target is where the entry lives when that differs from Path: relative
+	// to the entry's root ($HOME for home/), starting with "~/", or absolute.
+	Target string `json:"target,omitempty"`
+	// Targets overrides Target on some machines. Keys are "host=<name>",
+	// "os=<goos>" or "arch=<goarch>"; a host match beats an os match, which
+	// beats an arch match.
+	Targets map[string]string `json:"targets,omitempty"`
+	// OS, Arch and Host restrict the entry to matching machines; each list
+	// matches when empty or when it contains the machine's value.
+	OS   []string `json:"os,omitempty"`
+	Arch []string `json:"arch,omitempty"`
+	Host []string `json:"host,omitempty"`
+	// Template renders the entry as a Go text/template.
+	Template bool `json:"template,omitempty"`
+	// Encrypted stores the entry encrypted in the repo.
+	Encrypted bool     `json:"encrypted,omitempty"`
+	Tags      []string `json:"tags,omitempty"`
+}
+
+// Machine identifies the machine entries are matched against.
+type Machine struct {
+	OS   string
+	Arch string
+	Host string
+}
+
+// CurrentMachine describes this machine.
+func CurrentMachine() Machine {
+	return Machine{OS: runtime.GOOS, Arch: runtime.GOARCH, Host: Hostname()}
+}
+
+// Matches reports whether the entry's conditions hold on m.
+func (e *Entry) Matches(m Machine) bool {
+	return matchesAny(e.OS, m.OS) && matchesAny(e.Arch, m.Arch) && matchesAny(e.Host, m.Host)
+}
+
+// TargetFor returns the entry's target on m, or "" when it has none.
+func (e *Entry) TargetFor(m Machine) string {
+	for _, key := range []string{"host=" + m.Host, "os=" + m.OS, "arch=" + m.Arch} {
+		if t, ok := e.Targets[key]; ok {
+			return t
+		}
+	}
+	return e.Target
+}
+
+// FileMode returns the parsed Mode, or 0 when unset.
+func (e *Entry) FileMode() os.FileMode {
+	mode, _ := parseMode(e.Mode)
+	return mode
+}
+
+// Conditions summarises the entry's machine conditions, e.g. "os=linux".
+func (e *Entry) Conditions() string {
+	var parts []string
+	for _, c := range []struct {
+		key    string
+		values []string
+	}{{"os", e.OS}, {"arch", e.Arch}, {"host", e.Host}} {
+		if len(c.values) > 0 {
+			parts = append(parts, c.key+"="+strings.Join(c.values, ","))
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+func matchesAny(values []string, value string) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func parseMode(mode string) (os.FileMode, error) {
+	if mode == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || n > 0777 {
+		return 0, fmt.Errorf("invalid mode %q, expected octal permissions such as \"0600\"", mode)
+	}
+	return os.FileMode(n), nil
+}
+
+// Root maps a repo directory other than home/ onto a directory on the
+// machine. Files under it are identified as "<name>:<path within the root>".
+type Root struct {
+	Name string `json:"name"`
+	// Dir is the repo directory, relative to the repo root.
+	Dir string `json:"dir"`
+	// Path is where Dir is applied: absolute or starting with "~/". It can be
+	// changed per machine with the root.<name> config setting.
+	Path string `json:"path"`
+}
+
+// DefaultRoot maps the repo's root/ directory onto the filesystem root, so
+// root/etc/hosts is applied to /etc/hosts. A root named "root" in the manifest
+// replaces it.
+var DefaultRoot = Root{Name: "root", Dir: "root", Path: "/"}
+
+var rootNameRe = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+type Manifest struct {
+	Version int `json:"version"`
+	// Directories lists directories (relative to the home tree) that are tracked
+	// as a whole, so new files appearing under them in $HOME are picked up.
+	Directories []string `json:"directories,omitempty"`
+	// Templates lists files (relative to the home tree) rendered with Go
+	// text/template on apply, in addition to files carrying TemplateMarker.
+	Templates []string `json:"templates,omitempty"`
+	// Secrets lists rules that replace secret values with placeholders when
+	// files are submitted, in addition to lines marked with SecretMarker.
+	Secrets []PlaceholderRule `json:"secrets,omitempty"`
+	// Entries lists tracked paths with their attributes. Files without an
+	// entry are tracked with default attributes.
+	Entries []Entry `json:"entries,omitempty"`
+	// Roots lists repo directories applied outside $HOME.
+	Roots []Root `json:"roots,omitempty"`
+}
+
+// ManifestService loads and saves the repo manifest. A repo without a manifest
+// behaves as if it had an empty one.
+type ManifestService struct {
+	manifest  Manifest
+	path      string
+	machine   Machine
+	rootPaths map[string]string
+}
+
+func NewManifestService(repoDir string) *ManifestService {
+	return &ManifestService{
+		manifest: Manifest{Version: ManifestVersion},
+		path:     filepath.Join(repoDir, ManifestFileName),
+		machine:  CurrentMachine(),
+	}
+}
+
+// Load reads and validates the manifest.
+func (m *ManifestService) Load() error {
+	bytes, err := os.ReadFile(m.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			m.manifest = Manifest{Version: ManifestVersion}
+			return nil
+		}
+		return err
+	}
+	m.manifest = Manifest{}
+	if err := json.Unmarshal(bytes, &m.manifest); err != nil {
+		return err
+	}
+	return m.Validate()
+}
+
+// Validate checks the manifest version and entries.
+func (m *ManifestService) Validate() error {
+	if m.manifest.Version > ManifestVersion {
+		return fmt.Errorf("manifest version %d is newer than this dotman supports (%d); upgrade dotman", m.manifest.Version, ManifestVersion)
+	}
+	seen := make(map[string]bool, len(m.manifest.Entries))
+	for i, e := range m.manifest.Entries {
+		if err := e.Validate(); err != nil {
+			return fmt.Errorf("entries[%d] (%s): %v", i, e.Path, err)
+		}
+		if seen[e.Path] {
+			return fmt.Errorf("entries[%d] (%s): duplicate path", i, e.Path)
+		}
+		seen[e.Path] = true
+	}
+	names := map[string]bool{}
+	for i, r := range m.manifest.Roots {
+		switch {
+		case !rootNameRe.MatchString(r.Name):
+			return fmt.Errorf("roots[%d]: name must be letters, digits, '-' or '_'", i)
+		case names[r.Name]:
+			return fmt.Errorf("roots[%d] (%s): duplicate name", i, r.Name)
+		case !validRel(r.Dir) || r.Dir == "home" || strings.HasPrefix(r.Dir, "home/"):
+			return fmt.Errorf("roots[%d] (%s): dir must be a repo directory other than home/", i, r.Name)
+		case !filepath.IsAbs(r.Path) && !strings.HasPrefix(r.Path, "~/"):
+			return fmt.Errorf("roots[%d] (%s): path must be absolute or start with ~/", i, r.Name)
+		}
+		names[r.Name] = true
+	}
+	return nil
+}
+
+// Validate checks the entry's path and attributes.
+func (e *Entry) Validate() error {
+	if !validRel(e.Path) {
+		return fmt.Errorf("path must be a clean path relative to the home tree")
+	}
+	if _, err := parseMode(e.Mode); err != nil {
+		return err
+	}
+	if !validTarget(e.Target) {
+		return fmt.Errorf("invalid target %q", e.Target)
+	}
+	for key, target := range e.Targets {
+		cond, value, _ := strings.Cut(key, "=")
+		if (cond != "host" && cond != "os" && cond != "arch") || value == "" {
+			return fmt.Errorf("targets key %q must be host=<name>, os=<goos> or arch=<goarch>", key)
+		}
+		if target == "" || !validTarget(target) {
+			return fmt.Errorf("invalid target %q for %s", target, key)
+		}
+	}
+	if e.Template && e.Encrypted {
+		return fmt.Errorf("an entry cannot be both a template and encrypted")
+	}
+	for _, lists := range [][]string{e.OS, e.Arch, e.Host, e.Tags} {
+		for _, v := range lists {
+			if strings.TrimSpace(v) == "" {
+				return fmt.Errorf("conditions and tags cannot be empty")
+			}
+		}
+	}
+	return nil
+}
+
+// validTarget accepts "", absolute paths, and clean relative paths optionally
+// starting with "~/".
+func validTarget(t string) bool {
+	return t == "" || filepath.IsAbs(t) || validRel(strings.TrimPrefix(t, "~/"))
+}
+
+// validRel reports whether p is a clean, slash-separated relative path that
+// stays inside its root.
+func validRel(p string) bool {
+	return p != "" && p != "." && !path.IsAbs(p) && path.Clean(p) == p && p != ".." && !strings.HasPrefix(p, "../")
+}
+
+func (m *ManifestService) Save() error {
+	m.manifest.Version = ManifestVersion
+	bytes, err := json.MarshalIndent(m.manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return NewFileService().WriteFile(m.path, append(bytes, '\n'), 0644)
+}
+
+// Path returns the location of the manifest file.
+func (m *ManifestService) Path() string {
+	return m.path
+}
+
+// Directories returns the tracked directories, relative to the home tree.
+func (m *ManifestService) Directories() []string {
+	return m.manifest.Directories
+}
+
+// Templates returns the files and directories rendered as templates, relative
+// to the home tree: the templates list plus entries marked as templates.
+func (m *ManifestService) Templates() []string {
+	templates := append([]string(nil), m.manifest.Templates...)
+	for _, e := range m.manifest.Entries {
+		if e.Template {
+			templates = append(templates, e.Path)
+		}
+	}
+	return templates
+}
+
+// SetMachine overrides the machine entry conditions are matched against.
+func (m *ManifestService) SetMachine(machine Machine) {
+	m.machine = machine
+}
+
+// Entries returns the entries listed in the manifest.
+func (m *ManifestService) Entries() []Entry {
+	return m.manifest.Entries
+}
+
+// Entry returns the most specific entry covering rel: an entry for rel itself
+// or for the closest directory containing it. It returns nil when there is
+// none. A nil ManifestService has no entries.
+func (m *ManifestService) Entry(rel string) *Entry {
+	if m == nil {
+		return nil
+	}
+	rel = filepath.ToSlash(NormalizeRelPath(rel))
+	var best *Entry
+	for i := range m.manifest.Entries {
+		e := &m.manifest.Entries[i]
+		if e.Path != rel && !strings.HasPrefix(rel, e.Path+"/") {
+			continue
+		}
+		if best == nil || len(e.Path) > len(best.Path) {
+			best = e
+		}
+	}
+	return best
+}
+
+// Active reports whether rel is managed on this machine, i.e. the conditions
+// of its entry, if any, hold.
+func (m *ManifestService) Active(rel string) bool {
+	e := m.Entry(rel)
+	return e == nil || e.Matches(m.machine)
+}
+
+// HasTag reports whether the entry covering rel carries any of tags.
+func (m *ManifestService) HasTag(rel string, tags []string) bool {
+	e := m.Entry(rel)
+	if e == nil {
+		return false
+	}
+	for _, t := range tags {
+		for _, tag := range e.Tags {
+			if tag == t {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Encrypted reports whether rel must be stored encrypted.
+func (m *ManifestService) Encrypted(rel string) bool {
+	e := m.Entry(rel)
+	return e != nil && e.Encrypted
+}
+
+// Roots returns the extra roots: those in the manifest, plus DefaultRoot
+// unless the manifest defines a root of that name. Paths reflect SetRootPath.
+// A nil ManifestService has no roots.
+func (m *ManifestService) Roots() []Root {
+	if m == nil {
+		return nil
+	}
+	roots := append([]Root(nil), m.manifest.Roots...)
+	hasDefault := false
+	for _, r := range roots {
+		if r.Name == DefaultRoot.Name {
+			hasDefault = true
+		}
+	}
+	if !hasDefault {
+		roots = append(roots, DefaultRoot)
+	}
+	for i := range roots {
+		if p, ok := m.rootPaths[roots[i].Name]; ok {
+			roots[i].Path = p
+		}
+	}
+	return roots
+}
+
+// SetRootPath applies the root called name to path on this machine.
+func (m *ManifestService) SetRootPath(name, path string) {
+	if m.rootPaths == nil {
+		m.rootPaths = map[string]string{}
+	}
+	m.rootPaths[name] = path
+}
+
+// RootDir returns the repo directory of root.
+func (m *ManifestService) RootDir(root Root) string {
+	return filepath.Join(filepath.Dir(m.path), filepath.FromSlash(root.Dir))
+}
+
+// SplitRel splits a path such as "root:etc/hosts" into its root and the path
+// within it. Paths in the home tree return a nil root.
+func (m *ManifestService) SplitRel(rel string) (*Root, string) {
+	rel = filepath.ToSlash(rel)
+	name, within, ok := strings.Cut(rel, ":")
+	if !ok {
+		return nil, rel
+	}
+	for _, r := range m.Roots() {
+		if r.Name == name {
+			return &r, within
+		}
+	}
+	return nil, rel
+}
+
+// RepoPath returns the repo file for rel, given the repo's home tree.
+func (m *ManifestService) RepoPath(repoHome, rel string) string {
+	if root, within := m.SplitRel(rel); root != nil {
+		return filepath.Join(m.RootDir(*root), filepath.FromSlash(within))
+	}
+	return filepath.Join(repoHome, rel)
+}
+
+// HomePath returns where rel is applied on this machine: under userHome, or
+// under its root's path, unless its entry gives a target.
+func (m *ManifestService) HomePath(userHome, rel string) string {
+	base := userHome
+	root, within := m.SplitRel(rel)
+	if root != nil {
+		base = expandTilde(root.Path, userHome)
+	}
+	if e := m.Entry(rel); e != nil {
+		if target := e.TargetFor(m.machine); target != "" {
+			if !filepath.IsAbs(target) && !strings.HasPrefix(target, "~/") {
+				target = filepath.Join(base, filepath.FromSlash(target))
+			}
+			rest := strings.TrimPrefix(filepath.ToSlash(rel), e.Path)
+			return filepath.Join(expandTilde(target, userHome), filepath.FromSlash(rest))
+		}
+	}
+	return filepath.Join(base, filepath.FromSlash(within))
+}
+
+// RelFor maps an absolute path outside userHome onto the root containing it,
+// preferring the most specific root. It returns false when no root does.
+func (m *ManifestService) RelFor(userHome, path string) (string, bool) {
+	best, bestLen := "", -1
+	for _, r := range m.Roots() {
+		base := expandTilde(r.Path, userHome)
+		within, err := filepath.Rel(base, path)
+		if err != nil || within == "." || within == ".." || strings.HasPrefix(within, "../") {
+			continue
+		}
+		if len(base) > bestLen {
+			best, bestLen = r.Name+":"+filepath.ToSlash(within), len(base)
+		}
+	}
+	return best, bestLen >= 0
+}
+
+func expandTilde(p, userHome string) string {
+	if rest, ok := strings.CutPrefix(p, "~/"); ok {
+		return filepath.Join(userHome, rest)
+	}
+	return p
+}
+
+// SetEntry adds e, or replaces the entry with the same path. It validates e
+// first.
+func (m *ManifestService) SetEntry(e Entry) error {
+	e.Path = filepath.ToSlash(NormalizeRelPath(e.Path))
+	if err := e.Validate(); err != nil {
+		return err
+	}
+	for i := range m.manifest.Entries {
+		if m.manifest.Entries[i].Path == e.Path {
+			m.manifest.Entries[i] = e
+			return nil
+		}
+	}
+	m.manifest.Entries = append(m.manifest.Entries, e)
+	sort.Slice(m.manifest.Entries, func(i, j int) bool {
+		return m.manifest.Entries[i].Path < m.manifest.Entries[j].Path
+	})
+	return nil
+}
+
+// RemoveEntries removes the entries for rel and anything beneath it. It
+// reports whether the manifest changed.
+func (m *ManifestService) RemoveEntries(rel string) bool {
+	rel = filepath.ToSlash(NormalizeRelPath(rel))
+	kept := m.manifest.Entries[:0]
+	for _, e := range m.manifest.Entries {
+		if e.Path == rel || strings.HasPrefix(e.Path, rel+"/") {
+			continue
+		}
+		kept = append(kept, e)
+	}
+	changed := len(kept) != len(m.manifest.Entries)
+	m.manifest.Entries = kept
+	return changed
+}
+
+// Secrets returns the placeholder rules for secret values.
+func (m *ManifestService) Secrets() []PlaceholderRule {
+	return m.manifest.Secrets
+}
+
+// TrackDirectory adds rel to the tracked directories. It reports whether the
+// manifest changed.
+func (m *ManifestService) TrackDirectory(rel string) bool {
+	rel = filepath.ToSlash(NormalizeRelPath(rel))
+	for _, d := range m.manifest.Directories {
+		if d == rel {
+			return false
+		}
+	}
+	m.manifest.Directories = append(m.manifest.Directories, rel)
+	sort.Strings(m.manifest.Directories)
+	return true
+}
+
+// UntrackDirectory removes rel, and any tracked directory beneath it, from the
+// tracked directories. It reports whether the manifest changed.
+func (m *ManifestService) UntrackDirectory(rel string) bool {
+	rel = filepath.ToSlash(NormalizeRelPath(rel))
+	kept := m.manifest.Directories[:0]
+	for _, d := range m.manifest.Directories {
+		if d == rel || strings.HasPrefix(d, rel+"/") {
+			continue
+		}
+		kept = append(kept, d)
+	}
+	changed := len(kept) != len(m.manifest.Directories)
+	m.manifest.Directories = kept
+	return changed
+}
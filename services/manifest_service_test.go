@@ -0,0 +1,179 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestManifestService_Validate(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		json    string
+		wantErr string
+	}{
+		{name: "v1 without entries", json: `{"version": 1, "directories": [".config/nvim"]}`},
+		{name: "valid entries", json: `{"version": 2, "entries": [{"path": ".ssh/config", "mode": "0600", "os": ["linux"]}, {"path": ".config/Code", "target": "~/.vscode-server/data"}]}`},
+		{name: "newer version", json: `{"version": 99}`, wantErr: "newer"},
+		{name: "bad mode", json: `{"version": 2, "entries": [{"path": ".x", "mode": "rw"}]}`, wantErr: "invalid mode"},
+		{name: "escaping path", json: `{"version": 2, "entries": [{"path": "../etc/passwd"}]}`, wantErr: "path must be"},
+		{name: "escaping target", json: `{"version": 2, "entries": [{"path": ".x", "target": "../x"}]}`, wantErr: "invalid target"},
+		{name: "bad targets key", json: `{"version": 2, "entries": [{"path": ".x", "targets": {"user=me": "/x"}}]}`, wantErr: "targets key"},
+		{name: "root over home", json: `{"version": 2, "roots": [{"name": "h", "dir": "home/x", "path": "/x"}]}`, wantErr: "other than home/"},
+		{name: "relative root path", json: `{"version": 2, "roots": [{"name": "srv", "dir": "srv", "path": "srv"}]}`, wantErr: "path must be absolute"},
+		{name: "duplicate", json: `{"version": 2, "entries": [{"path": ".x"}, {"path": ".x"}]}`, wantErr: "duplicate"},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		writeFile(t, filepath.Join(dir, ManifestFileName), tt.json)
+		err := NewManifestService(dir).Load()
+		if tt.wantErr == "" && err != nil {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+		}
+		if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("%s: error %v, want %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestManifestService_Entries(t *testing.T) {
+	t.Parallel()
+
+	m := NewManifestService(t.TempDir())
+	m.SetMachine(Machine{OS: "linux", Arch: "amd64", Host: "laptop"})
+	for _, e := range []Entry{
+		{Path: ".config/Code/User", Target: "~/.vscode/user", Tags: []string{"editor"}},
+		{Path: ".config/Code/User/settings.json", Mode: "0600"},
+		{Path: "Library", OS: []string{"darwin"}},
+	} {
+		if err := m.SetEntry(e); err != nil {
+			t.Fatalf("SetEntry(%s): %v", e.Path, err)
+		}
+	}
+
+	if e := m.Entry(".config/Code/User/settings.json"); e == nil || e.FileMode() != 0600 {
+		t.Fatalf("most specific entry not chosen: %+v", e)
+	}
+	if got := m.HomePath("/home/me", ".config/Code/User/keybindings.json"); got != filepath.FromSlash("/home/me/.vscode/user/keybindings.json") {
+		t.Errorf("HomePath = %q", got)
+	}
+	if got := m.HomePath("/home/me", ".zshrc"); got != filepath.FromSlash("/home/me/.zshrc") {
+		t.Errorf("HomePath without entry = %q", got)
+	}
+	if m.Active("Library/Preferences/x.plist") || !m.Active(".zshrc") {
+		t.Errorf("Active does not follow the os condition")
+	}
+	if !m.HasTag(".config/Code/User/keybindings.json", []string{"editor"}) || m.HasTag(".zshrc", []string{"editor"}) {
+		t.Errorf("HasTag mismatch")
+	}
+	if !m.RemoveEntries(".config/Code") || len(m.Entries()) != 1 {
+		t.Errorf("RemoveEntries left %+v", m.Entries())
+	}
+}
+
+func TestManifestService_Roots(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, ManifestFileName), `{"version": 2,
+		"roots": [{"name": "srv", "dir": "srv", "path": "/srv/app"}],
+		"entries": [{"path": ".config/Code/User/settings.json", "targets": {"os=darwin": "~/Library/Application Support/Code/User/settings.json", "host=box": "/opt/code/settings.json"}}]}`)
+	m := NewManifestService(dir)
+	if err := m.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	m.SetMachine(Machine{OS: "darwin", Host: "mac"})
+	if got := m.HomePath("/home/me", ".config/Code/User/settings.json"); got != filepath.FromSlash("/home/me/Library/Application Support/Code/User/settings.json") {
+		t.Errorf("os target = %q", got)
+	}
+	m.SetMachine(Machine{OS: "darwin", Host: "box"})
+	if got := m.HomePath("/home/me", ".config/Code/User/settings.json"); got != filepath.FromSlash("/opt/code/settings.json") {
+		t.Errorf("host target should win, got %q", got)
+	}
+
+	if got := m.HomePath("/home/me", "root:etc/hosts"); got != filepath.FromSlash("/etc/hosts") {
+		t.Errorf("default root = %q", got)
+	}
+	if got := m.RepoPath(filepath.Join(dir, "home"), "srv:conf.d/a.conf"); got != filepath.Join(dir, "srv", "conf.d", "a.conf") {
+		t.Errorf("RepoPath = %q", got)
+	}
+	m.SetRootPath("srv", "~/srv")
+	if got := m.HomePath("/home/me", "srv:conf.d/a.conf"); got != filepath.FromSlash("/home/me/srv/conf.d/a.conf") {
+		t.Errorf("overridden root = %q", got)
+	}
+	if rel, ok := m.RelFor("/home/me", "/etc/ssh/sshd_config"); !ok || rel != "root:etc/ssh/sshd_config" {
+		t.Errorf("RelFor = %q, %v", rel, ok)
+	}
+}
+
+func TestCompareFilesWith_Manifest(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	repoHome := filepath.Join(dir, "repo")
+	userHome := filepath.Join(dir, "home")
+	for _, d := range []string{repoHome, filepath.Join(userHome, ".vscode")} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile(t, filepath.Join(repoHome, "settings.json"), "{}\n")
+	writeFile(t, filepath.Join(repoHome, ".mac-only"), "x\n")
+	writeFile(t, filepath.Join(repoHome, ".zshrc"), "export A=1\n")
+	writeFile(t, filepath.Join(userHome, ".vscode", "settings.json"), "{}\n")
+
+	m := NewManifestService(dir)
+	m.SetMachine(Machine{OS: "linux"})
+	for _, e := range []Entry{
+		{Path: "settings.json", Target: ".vscode/settings.json"},
+		{Path: ".mac-only", OS: []string{"darwin"}},
+		{Path: ".zshrc", Mode: "0640"},
+	} {
+		if err := m.SetEntry(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	changed, created, _, err := NewFileService().CompareFilesWith(repoHome, userHome, CompareOptions{Manifest: m})
+	if err != nil {
+		t.Fatalf("CompareFilesWith: %v", err)
+	}
+	if len(changed) != 0 {
+		t.Errorf("expected the remapped file to be in sync, got %+v", changed)
+	}
+	if len(created) != 1 || created[0].RelPath != ".zshrc" {
+		t.Fatalf("expected only .zshrc to be created, got %+v", created)
+	}
+	if created[0].HomePath != filepath.Join(userHome, ".zshrc") || created[0].Mode != 0640 {
+		t.Errorf("unexpected home path or mode: %+v", created[0])
+	}
+}
+
+func TestCompareFilesWith_Roots(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	repoHome := filepath.Join(dir, "home")
+	userHome := filepath.Join(dir, "user")
+	etc := filepath.Join(dir, "etc")
+	for _, d := range []string{repoHome, userHome, filepath.Join(dir, "root")} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile(t, filepath.Join(dir, "root", "hosts"), "127.0.0.1 localhost\n")
+
+	m := NewManifestService(dir)
+	m.SetRootPath("root", etc)
+	_, created, _, err := NewFileService().CompareFilesWith(repoHome, userHome, CompareOptions{Manifest: m})
+	if err != nil {
+		t.Fatalf("CompareFilesWith: %v", err)
+	}
+	if len(created) != 1 || created[0].RelPath != "root:hosts" || created[0].HomePath != filepath.Join(etc, "hosts") {
+		t.Fatalf("expected root:hosts to be created in %s, got %+v", etc, created)
+	}
+}
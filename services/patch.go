@@ -0,0 +1,114 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// Hunk is one group of changes turning lines of a into lines of b, with
+// surrounding context, as shown by `git add -p`.
+type Hunk struct {
+	AStart, ALen int // range of lines replaced in a (0-based)
+	BStart, BLen int // range of lines taken from b (0-based)
+	Lines        []string
+	// Edited, when non-nil, is used in place of the hunk's lines from b when
+	// the hunk is applied.
+	Edited []string
+}
+
+// Header returns the unified diff hunk header, e.g. "@@ -1,3 +1,4 @@".
+func (h Hunk) Header() string {
+	return fmt.Sprintf("@@ -%s +%s @@", hunkRange(h.AStart, h.ALen), hunkRange(h.BStart, h.BLen))
+}
+
+func hunkRange(start, length int) string {
+	if length == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if length == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, length)
+}
+
+// SplitLines splits text into lines that keep their line endings, so joining
+// them reproduces the input exactly.
+func SplitLines(text string) []string {
+	var lines []string
+	for len(text) > 0 {
+		i := strings.IndexByte(text, '\n')
+		if i < 0 {
+			lines = append(lines, text)
+			break
+		}
+		lines = append(lines, text[:i+1])
+		text = text[i+1:]
+	}
+	return lines
+}
+
+// ComputeHunks returns the hunks turning a into b, each with up to context
+// unchanged lines around it. Lines are formatted with " ", "-" and "+" prefixes.
+func ComputeHunks(a, b []string, context int) []Hunk {
+	matcher := difflib.NewMatcher(a, b)
+	var hunks []Hunk
+	for _, group := range matcher.GetGroupedOpCodes(context) {
+		first, last := group[0], group[len(group)-1]
+		h := Hunk{
+			AStart: first.I1,
+			ALen:   last.I2 - first.I1,
+			BStart: first.J1,
+			BLen:   last.J2 - first.J1,
+		}
+		for _, op := range group {
+			switch op.Tag {
+			case 'e':
+				h.Lines = append(h.Lines, prefixLines(" ", a[op.I1:op.I2])...)
+			case 'd':
+				h.Lines = append(h.Lines, prefixLines("-", a[op.I1:op.I2])...)
+			case 'i':
+				h.Lines = append(h.Lines, prefixLines("+", b[op.J1:op.J2])...)
+			case 'r':
+				h.Lines = append(h.Lines, prefixLines("-", a[op.I1:op.I2])...)
+				h.Lines = append(h.Lines, prefixLines("+", b[op.J1:op.J2])...)
+			}
+		}
+		hunks = append(hunks, h)
+	}
+	return hunks
+}
+
+func prefixLines(prefix string, lines []string) []string {
+	out := make([]string, 0, len(lines))
+	for _, l := range lines {
+		if strings.HasSuffix(l, "\n") {
+			out = append(out, prefix+strings.TrimSuffix(l, "\n"))
+		} else {
+			out = append(out, prefix+l, `\ No newline at end of file`)
+		}
+	}
+	return out
+}
+
+// ApplyHunks rebuilds a with the accepted hunks replaced by their lines from b.
+// hunks must come from ComputeHunks(a, b, ...) and accept must have one entry
+// per hunk.
+func ApplyHunks(a, b []string, hunks []Hunk, accept []bool) []string {
+	var out []string
+	cursor := 0
+	for i, h := range hunks {
+		out = append(out, a[cursor:h.AStart]...)
+		switch {
+		case accept[i] && h.Edited != nil:
+			out = append(out, h.Edited...)
+		case accept[i]:
+			out = append(out, b[h.BStart:h.BStart+h.BLen]...)
+		default:
+			out = append(out, a[h.AStart:h.AStart+h.ALen]...)
+		}
+		cursor = h.AStart + h.ALen
+	}
+	return append(out, a[cursor:]...)
+}
@@ -0,0 +1,43 @@
+package services
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestApplyHunks(t *testing.T) {
+	t.Parallel()
+
+	a := SplitLines("one\ntwo\nthree\nfour\nfive\nsix\nseven\neight\nnine\nten\n")
+	b := SplitLines("ONE\ntwo\nthree\nfour\nfive\nsix\nseven\neight\nnine\nten\neleven")
+
+	hunks := ComputeHunks(a, b, 1)
+	if len(hunks) != 2 {
+		t.Fatalf("expected 2 hunks, got %d", len(hunks))
+	}
+	if hunks[0].Header() != "@@ -1,2 +1,2 @@" {
+		t.Fatalf("unexpected header %q", hunks[0].Header())
+	}
+
+	tests := []struct {
+		name   string
+		accept []bool
+		want   string
+	}{
+		{name: "none", accept: []bool{false, false}, want: strings.Join(a, "")},
+		{name: "all", accept: []bool{true, true}, want: strings.Join(b, "")},
+		{name: "first", accept: []bool{true, false}, want: "ONE\ntwo\nthree\nfour\nfive\nsix\nseven\neight\nnine\nten\n"},
+		{name: "second", accept: []bool{false, true}, want: "one\ntwo\nthree\nfour\nfive\nsix\nseven\neight\nnine\nten\neleven"},
+	}
+	for _, tt := range tests {
+		if got := strings.Join(ApplyHunks(a, b, hunks, tt.accept), ""); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	hunks[1].Edited = []string{"ten\n", "TEN\n"}
+	want := "one\ntwo\nthree\nfour\nfive\nsix\nseven\neight\nnine\nten\nTEN\n"
+	if got := strings.Join(ApplyHunks(a, b, hunks, []bool{false, true}), ""); got != want {
+		t.Errorf("edited: got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,398 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// SecretMarker marks the following line as holding a secret, e.g.
+// "# dotman:secret" above a token line. An optional word after the marker
+// names the placeholder: "# dotman:secret npm-token".
+const SecretMarker = "dotman:secret"
+
+var (
+	placeholderRe = regexp.MustCompile(`<dotman-secret:([A-Za-z0-9_.\-]+)>`)
+	markerRe      = regexp.MustCompile(regexp.QuoteMeta(SecretMarker) + `(?:\s+([A-Za-z0-9_.\-]+))?`)
+	// assignmentRe splits a marked line into key, value and trailer: the value
+	// follows the first "=" or, failing that, the first ": ".
+	assignmentRe = regexp.MustCompile(`^(\s*[^=]*?=\s*["']?|\s*[^:]*?:\s+["']?)(.*?)(["']?\s*)$`)
+	nameRe       = regexp.MustCompile(`[A-Za-z0-9_.\-]+`)
+)
+
+// Placeholder returns the text stored in the repo in place of the secret name.
+func Placeholder(name string) string {
+	return "<dotman-secret:" + name + ">"
+}
+
+// PlaceholderRule replaces secret values in matching files with placeholders.
+type PlaceholderRule struct {
+	// Files are globs relative to the home tree; empty matches every file.
+	Files []string `json:"files,omitempty"`
+	// Pattern is a regular expression whose first group, or whole match if it
+	// has none, is the secret value.
+	Pattern string `json:"pattern"`
+	// Name of the placeholder; defaults to the key preceding the value.
+	Name string `json:"name,omitempty"`
+}
+
+type placeholderRule struct {
+	PlaceholderRule
+	re *regexp.Regexp
+}
+
+func (r placeholderRule) matchesFile(rel string) bool {
+	if len(r.Files) == 0 {
+		return true
+	}
+	for _, glob := range r.Files {
+		if ok, _ := filepath.Match(glob, rel); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// SecretStorePath returns the per-machine store for placeholder values:
+// $XDG_CONFIG_HOME/dotman/secrets.json, or ~/.config/dotman/secrets.json.
+func SecretStorePath() string {
+	return filepath.Join(ConfigDir(), "secrets.json")
+}
+
+// SecretStore holds the real values of placeholders, per file. It lives
+// outside the repo and is never committed.
+type SecretStore struct {
+	path   string
+	values map[string]map[string]string
+}
+
+func NewSecretStore(path string) *SecretStore {
+	return &SecretStore{path: path, values: map[string]map[string]string{}}
+}
+
+// Load reads the store. A missing store is empty.
+func (s *SecretStore) Load() error {
+	bytes, err := os.ReadFile(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return json.Unmarshal(bytes, &s.values)
+}
+
+// Save writes the store with 0600 permissions.
+func (s *SecretStore) Save() error {
+	bytes, err := json.MarshalIndent(s.values, "", "  ")
+	if err != nil {
+		return err
+	}
+	fs := NewFileService()
+	if err := fs.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+	return fs.WriteFile(s.path, append(bytes, '\n'), 0600)
+}
+
+// Get returns the value of placeholder name in rel.
+func (s *SecretStore) Get(rel, name string) (string, bool) {
+	v, ok := s.values[rel][name]
+	return v, ok
+}
+
+// Set records the value of placeholder name in rel. It reports whether the
+// store changed.
+func (s *SecretStore) Set(rel, name, value string) bool {
+	if old, ok := s.values[rel][name]; ok && old == value {
+		return false
+	}
+	if s.values[rel] == nil {
+		s.values[rel] = map[string]string{}
+	}
+	s.values[rel][name] = value
+	return true
+}
+
+// PlaceholderService keeps secret values out of the repo. On submit, values on
+// marked lines, lines matching a rule, and lines that already hold a
+// placeholder in the repo are replaced by placeholders and remembered in the
+// SecretStore; on apply the placeholders are filled back in. Values are only
+// remembered in memory until Persist writes the store, so comparing files
+// never changes it.
+type PlaceholderService struct {
+	store    *SecretStore
+	rules    []placeholderRule
+	homeDir  string
+	manifest *ManifestService
+	// dirty is set when the store holds values Persist has not written yet.
+	dirty bool
+}
+
+// NewPlaceholderService compiles rules. Values missing from the store are
+// picked up from the files already in homeDir, found where manifest applies
+// them; manifest may be nil.
+func NewPlaceholderService(store *SecretStore, rules []PlaceholderRule, homeDir string, manifest *ManifestService) (*PlaceholderService, error) {
+	p := &PlaceholderService{store: store, homeDir: homeDir, manifest: manifest}
+	for _, r := range rules {
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid secret rule %q: %v", r.Pattern, err)
+		}
+		p.rules = append(p.rules, placeholderRule{PlaceholderRule: r, re: re})
+	}
+	return p, nil
+}
+
+func (p *PlaceholderService) rulesFor(rel string) []placeholderRule {
+	rel = filepath.ToSlash(NormalizeRelPath(rel))
+	var rules []placeholderRule
+	for _, r := range p.rules {
+		if r.matchesFile(rel) {
+			rules = append(rules, r)
+		}
+	}
+	return rules
+}
+
+// Applies reports whether the repo content holds placeholders or secrets that
+// should be replaced by them.
+func (p *PlaceholderService) Applies(rel string, repo []byte) bool {
+	return placeholderRe.Match(repo) || p.AppliesHome(rel, repo)
+}
+
+// AppliesHome reports whether home content has marked lines or lines matching
+// a rule, so secrets are replaced before the file first reaches the repo.
+func (p *PlaceholderService) AppliesHome(rel string, home []byte) bool {
+	if markerRe.Match(home) {
+		return true
+	}
+	for _, r := range p.rulesFor(rel) {
+		if r.re.Match(home) {
+			return true
+		}
+	}
+	return false
+}
+
+// Smudge fills in placeholders from the store. Values missing from the store
+// are taken from the file in $HOME when its line still matches; otherwise the
+// placeholder is left for the user to replace.
+func (p *PlaceholderService) Smudge(rel string, repo []byte) ([]byte, error) {
+	key := filepath.ToSlash(NormalizeRelPath(rel))
+	if p.learn(key, string(repo)) {
+		p.dirty = true
+	}
+	out := placeholderRe.ReplaceAllStringFunc(string(repo), func(ph string) string {
+		name := placeholderRe.FindStringSubmatch(ph)[1]
+		if v, ok := p.store.Get(key, name); ok {
+			return v
+		}
+		return ph
+	})
+	return []byte(out), nil
+}
+
+// learn records values for placeholders in repo that are missing from the
+// store, using the home copy of key. It reports whether the store changed.
+func (p *PlaceholderService) learn(key, repo string) bool {
+	missing := false
+	for _, m := range placeholderRe.FindAllStringSubmatch(repo, -1) {
+		if _, ok := p.store.Get(key, m[1]); !ok {
+			missing = true
+			break
+		}
+	}
+	if !missing || p.homeDir == "" {
+		return false
+	}
+	home, err := os.ReadFile(p.manifest.HomePath(p.homeDir, key))
+	if err != nil {
+		return false
+	}
+	changed := false
+	patterns := linePatterns(repo)
+	for _, line := range strings.Split(string(home), "\n") {
+		for _, lp := range patterns {
+			values, ok := lp.match(line)
+			if !ok {
+				continue
+			}
+			for i, name := range lp.names {
+				if _, ok := p.store.Get(key, name); !ok && values[i] != Placeholder(name) {
+					changed = p.store.Set(key, name, values[i]) || changed
+				}
+			}
+			break
+		}
+	}
+	return changed
+}
+
+// Clean replaces secret values in home content with placeholders and records
+// them in the store. Lines are recognised, in order, by matching a line of
+// current that already holds a placeholder, by a SecretMarker on the line
+// before, or by a rule for rel.
+func (p *PlaceholderService) Clean(rel string, home, current []byte) ([]byte, error) {
+	key := filepath.ToSlash(NormalizeRelPath(rel))
+	patterns := linePatterns(string(current))
+	used := map[string]bool{}
+	for _, m := range placeholderRe.FindAllStringSubmatch(string(current), -1) {
+		used[m[1]] = true
+	}
+	rules := p.rulesFor(rel)
+	changed := false
+	record := func(name, value string) string {
+		if value != Placeholder(name) {
+			changed = p.store.Set(key, name, value) || changed
+		}
+		return Placeholder(name)
+	}
+
+	lines := strings.Split(string(home), "\n")
+	for i, line := range lines {
+		if replaced, ok := replaceByPattern(line, patterns, record); ok {
+			lines[i] = replaced
+			continue
+		}
+		if i > 0 {
+			if m := markerRe.FindStringSubmatch(lines[i-1]); m != nil && !markerRe.MatchString(line) {
+				if a := assignmentRe.FindStringSubmatchIndex(line); a != nil && a[5] > a[4] {
+					name := uniqueName(firstNonEmpty(m[1], keyName(line[:a[3]])), used)
+					lines[i] = line[:a[4]] + record(name, line[a[4]:a[5]]) + line[a[5]:]
+					continue
+				}
+			}
+		}
+		for _, r := range rules {
+			loc := r.re.FindStringSubmatchIndex(line)
+			if loc == nil {
+				continue
+			}
+			start, end := loc[0], loc[1]
+			if len(loc) >= 4 && loc[2] >= 0 {
+				start, end = loc[2], loc[3]
+			}
+			if start == end || placeholderRe.MatchString(line[start:end]) {
+				continue
+			}
+			name := uniqueName(firstNonEmpty(r.Name, keyName(line[:start])), used)
+			lines[i] = line[:start] + record(name, line[start:end]) + line[end:]
+			break
+		}
+	}
+	if changed {
+		p.dirty = true
+	}
+	return []byte(strings.Join(lines, "\n")), nil
+}
+
+// Persist writes the values learned or recorded since the last Persist to the
+// store.
+func (p *PlaceholderService) Persist() error {
+	if !p.dirty {
+		return nil
+	}
+	if err := p.store.Save(); err != nil {
+		return err
+	}
+	p.dirty = false
+	return nil
+}
+
+// Sensitive marks files with filled-in secrets as private.
+func (p *PlaceholderService) Sensitive() bool {
+	return true
+}
+
+// linePattern matches a home line against a repo line holding placeholders.
+type linePattern struct {
+	re    *regexp.Regexp
+	names []string
+}
+
+func (lp linePattern) match(line string) ([]string, bool) {
+	m := lp.re.FindStringSubmatch(line)
+	if m == nil {
+		return nil, false
+	}
+	return m[1:], true
+}
+
+// linePatterns turns each line of repo holding placeholders into a pattern in
+// which the placeholders match any value and the rest of the line is literal.
+func linePatterns(repo string) []linePattern {
+	var patterns []linePattern
+	for _, line := range strings.Split(repo, "\n") {
+		locs := placeholderRe.FindAllStringSubmatchIndex(line, -1)
+		if locs == nil {
+			continue
+		}
+		var expr strings.Builder
+		var names []string
+		last := 0
+		expr.WriteString("^")
+		for _, loc := range locs {
+			expr.WriteString(regexp.QuoteMeta(line[last:loc[0]]))
+			expr.WriteString("(.*?)")
+			names = append(names, line[loc[2]:loc[3]])
+			last = loc[1]
+		}
+		expr.WriteString(regexp.QuoteMeta(line[last:]) + "$")
+		patterns = append(patterns, linePattern{re: regexp.MustCompile(expr.String()), names: names})
+	}
+	return patterns
+}
+
+func replaceByPattern(line string, patterns []linePattern, record func(name, value string) string) (string, bool) {
+	for _, lp := range patterns {
+		m := lp.re.FindStringSubmatchIndex(line)
+		if m == nil {
+			continue
+		}
+		var out strings.Builder
+		last := 0
+		for i, name := range lp.names {
+			start, end := m[2+2*i], m[3+2*i]
+			out.WriteString(line[last:start])
+			out.WriteString(record(name, line[start:end]))
+			last = end
+		}
+		out.WriteString(line[last:])
+		return out.String(), true
+	}
+	return "", false
+}
+
+// keyName derives a placeholder name from the text before a value, e.g.
+// "_authToken" from "//registry.npmjs.org/:_authToken=".
+func keyName(prefix string) string {
+	names := nameRe.FindAllString(prefix, -1)
+	if len(names) == 0 {
+		return "secret"
+	}
+	return names[len(names)-1]
+}
+
+// uniqueName returns name, or name with a numeric suffix when it is already
+// used in the file, and marks the result as used.
+func uniqueName(name string, used map[string]bool) string {
+	candidate := name
+	for n := 2; used[candidate]; n++ {
+		candidate = fmt.Sprintf("%s-%d", name, n)
+	}
+	used[candidate] = true
+	return candidate
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,151 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPlaceholderService_RoundTrip(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	repoHome := filepath.Join(dir, "repo")
+	userHome := filepath.Join(dir, "home")
+	storePath := filepath.Join(dir, "secrets.json")
+	if err := os.MkdirAll(filepath.Join(repoHome, ".config", "gh"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	placeholders, err := NewPlaceholderService(NewSecretStore(storePath), []PlaceholderRule{
+		{Files: []string{".config/gh/hosts.yml"}, Pattern: `oauth_token: (\S+)`},
+	}, userHome, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := NewContentService(placeholders)
+
+	npmrc := "registry=https://registry.npmjs.org/\n# dotman:secret\n//registry.npmjs.org/:_authToken=npm_abc123\n"
+	hosts := "github.com:\n  user: me\n  oauth_token: gho_secret\n"
+
+	// The first submit replaces the values, whether marked or matched by a rule.
+	repoNpmrc, err := content.RepoContent(".npmrc", []byte(npmrc), filepath.Join(repoHome, ".npmrc"))
+	if err != nil {
+		t.Fatalf("RepoContent: %v", err)
+	}
+	wantNpmrc := "registry=https://registry.npmjs.org/\n# dotman:secret\n//registry.npmjs.org/:_authToken=<dotman-secret:_authToken>\n"
+	if string(repoNpmrc) != wantNpmrc {
+		t.Fatalf("repo .npmrc = %q, want %q", repoNpmrc, wantNpmrc)
+	}
+	repoHosts, err := content.RepoContent(".config/gh/hosts.yml", []byte(hosts), filepath.Join(repoHome, ".config/gh/hosts.yml"))
+	if err != nil {
+		t.Fatalf("RepoContent: %v", err)
+	}
+	if strings.Contains(string(repoHosts), "gho_secret") || !strings.Contains(string(repoHosts), "oauth_token: <dotman-secret:oauth_token>") {
+		t.Fatalf("repo hosts.yml = %q", repoHosts)
+	}
+	writeFile(t, filepath.Join(repoHome, ".npmrc"), string(repoNpmrc))
+	writeFile(t, filepath.Join(repoHome, ".config/gh/hosts.yml"), string(repoHosts))
+
+	// Applying fills the values back in from the store.
+	home, filtered, err := content.HomeContent(".npmrc", filepath.Join(repoHome, ".npmrc"))
+	if err != nil || !filtered || string(home) != npmrc {
+		t.Fatalf("HomeContent = %q, %v, %v", home, filtered, err)
+	}
+
+	// A changed token on an existing placeholder line is picked up without a
+	// marker and stored, leaving the repo content unchanged.
+	hosts2 := strings.Replace(hosts, "gho_secret", "gho_rotated", 1)
+	out, err := content.RepoContent(".config/gh/hosts.yml", []byte(hosts2), filepath.Join(repoHome, ".config/gh/hosts.yml"))
+	if err != nil || string(out) != string(repoHosts) {
+		t.Fatalf("RepoContent after rotation = %q, %v", out, err)
+	}
+	if v, _ := placeholders.store.Get(".config/gh/hosts.yml", "oauth_token"); v != "gho_rotated" {
+		t.Fatalf("stored value = %q", v)
+	}
+	if _, err := os.Stat(storePath); !os.IsNotExist(err) {
+		t.Fatalf("expected the store to be written only by Persist, got %v", err)
+	}
+	if err := content.Persist(); err != nil {
+		t.Fatalf("Persist: %v", err)
+	}
+	if info, err := os.Stat(storePath); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("store permissions: %v, %v", info, err)
+	}
+}
+
+func TestCompareFilesWith_Placeholders(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	repoHome := filepath.Join(dir, "repo")
+	userHome := filepath.Join(dir, "home")
+	for _, d := range []string{repoHome, userHome} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile(t, filepath.Join(repoHome, ".npmrc"), "//registry.npmjs.org/:_authToken=<dotman-secret:_authToken>\n")
+	writeFile(t, filepath.Join(userHome, ".npmrc"), "//registry.npmjs.org/:_authToken=npm_abc123\n")
+
+	// The store is empty, as on a fresh machine: the value already in $HOME is
+	// learned, so the file is not reported as drifted.
+	store := NewSecretStore(filepath.Join(dir, "secrets.json"))
+	placeholders, err := NewPlaceholderService(store, nil, userHome, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := &StateService{path: filepath.Join(dir, "state", "state.json")}
+	changed, created, _, err := NewFileService().CompareFilesWith(repoHome, userHome, CompareOptions{State: state, Content: NewContentService(placeholders)})
+	if err != nil {
+		t.Fatalf("CompareFilesWith: %v", err)
+	}
+	if len(changed) != 0 || len(created) != 0 {
+		t.Fatalf("expected placeholder to equal its value, got changed=%+v created=%+v", changed, created)
+	}
+	if v, ok := store.Get(".npmrc", "_authToken"); !ok || v != "npm_abc123" {
+		t.Fatalf("learned value = %q, %v", v, ok)
+	}
+	// Comparing is read-only: the learned value is not saved yet.
+	if _, err := os.Stat(filepath.Join(dir, "secrets.json")); !os.IsNotExist(err) {
+		t.Fatalf("expected the scan to leave the store alone, got %v", err)
+	}
+}
+
+func TestCompareFilesWith_PlaceholdersRemapped(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	repoHome := filepath.Join(dir, "repo")
+	userHome := filepath.Join(dir, "home")
+	for _, d := range []string{repoHome, filepath.Join(userHome, ".config", "npm")} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The repo's npmrc is applied to .config/npm/npmrc, so that is where the
+	// missing value has to be learned from.
+	writeFile(t, filepath.Join(repoHome, "npmrc"), "//registry.npmjs.org/:_authToken=<dotman-secret:_authToken>\n")
+	writeFile(t, filepath.Join(userHome, ".config", "npm", "npmrc"), "//registry.npmjs.org/:_authToken=npm_abc123\n")
+
+	m := NewManifestService(dir)
+	if err := m.SetEntry(Entry{Path: "npmrc", Target: ".config/npm/npmrc"}); err != nil {
+		t.Fatal(err)
+	}
+	store := NewSecretStore(filepath.Join(dir, "secrets.json"))
+	placeholders, err := NewPlaceholderService(store, nil, userHome, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changed, created, _, err := NewFileService().CompareFilesWith(repoHome, userHome, CompareOptions{Content: NewContentService(placeholders), Manifest: m})
+	if err != nil {
+		t.Fatalf("CompareFilesWith: %v", err)
+	}
+	if len(changed) != 0 || len(created) != 0 {
+		t.Fatalf("expected placeholder to equal its value, got changed=%+v created=%+v", changed, created)
+	}
+	if v, ok := store.Get("npmrc", "_authToken"); !ok || v != "npm_abc123" {
+		t.Fatalf("learned value = %q, %v", v, ok)
+	}
+}
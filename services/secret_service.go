@@ -0,0 +1,213 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// SecretAllowFileName is the allowlist for the secret scanner, kept at the
+// root of the dotfiles repo. Each line is a repo-relative path (globs allowed)
+// optionally followed by ":<line>"; a bare path allows the whole file.
+const SecretAllowFileName = ".dotmanallowsecrets"
+
+// minSecretEntropy is the Shannon entropy, in bits per character, above which
+// a value assigned to a secret-looking name is reported.
+const minSecretEntropy = 3.5
+
+// SecretRule is a pattern that indicates a secret.
+type SecretRule struct {
+	Name string
+	re   *regexp.Regexp
+	// entropy makes the rule match only when its first submatch looks random.
+	entropy bool
+}
+
+var builtinSecretRules = []SecretRule{
+	{Name: "private-key", re: regexp.MustCompile(`-----BEGIN (?:[A-Z0-9]+ )*PRIVATE KEY(?: BLOCK)?-----`)},
+	{Name: "aws-access-key-id", re: regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{Name: "github-token", re: regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,})\b`)},
+	{Name: "gitlab-token", re: regexp.MustCompile(`\bglpat-[A-Za-z0-9_\-]{20,}\b`)},
+	{Name: "slack-token", re: regexp.MustCompile(`\bxox[abposr]-[A-Za-z0-9-]{10,}`)},
+	{Name: "google-api-key", re: regexp.MustCompile(`\bAIza[0-9A-Za-z_\-]{35}\b`)},
+	{Name: "stripe-key", re: regexp.MustCompile(`\b[rs]k_live_[0-9A-Za-z]{20,}\b`)},
+	{Name: "npm-token", re: regexp.MustCompile(`\bnpm_[A-Za-z0-9]{36}\b`)},
+	{
+		Name:    "high-entropy-assignment",
+		re:      regexp.MustCompile(`(?i)[\w.-]*(?:secret|token|passw(?:or)?d|api[_-]?key|access[_-]?key|auth)[\w.-]*["']?\s*[:=]\s*["']?([A-Za-z0-9+/=_\-.~]{16,})`),
+		entropy: true,
+	},
+}
+
+// SecretFinding is a line that matched a secret rule.
+type SecretFinding struct {
+	Path string
+	Line int
+	Rule string
+	// Match is the matched text, shortened so the secret itself is not shown.
+	Match string
+}
+
+// Location formats the finding as path:line, the form accepted by Allow.
+func (f SecretFinding) Location() string {
+	return fmt.Sprintf("%s:%d", f.Path, f.Line)
+}
+
+type secretAllow struct {
+	pattern string
+	line    int // 0 allows the whole file
+}
+
+// SecretScanner looks for credentials in content about to be committed.
+type SecretScanner struct {
+	rules []SecretRule
+	allow []secretAllow
+}
+
+// NewSecretScanner returns a scanner holding only the built-in rules.
+func NewSecretScanner() *SecretScanner {
+	return &SecretScanner{rules: append([]SecretRule(nil), builtinSecretRules...)}
+}
+
+// AddPatterns adds user-defined regular expressions. Each is reported under
+// its own text as the rule name.
+func (s *SecretScanner) AddPatterns(patterns []string) error {
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return fmt.Errorf("invalid secret pattern %q: %v", p, err)
+		}
+		s.rules = append(s.rules, SecretRule{Name: p, re: re})
+	}
+	return nil
+}
+
+// Allow accepts findings at the given locations, each "path" or "path:line".
+func (s *SecretScanner) Allow(entries ...string) error {
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		allow := secretAllow{pattern: entry}
+		if i := strings.LastIndex(entry, ":"); i >= 0 {
+			n, err := strconv.Atoi(entry[i+1:])
+			if err != nil || n <= 0 {
+				return fmt.Errorf("invalid allowlist entry %q: expected path or path:line", entry)
+			}
+			allow = secretAllow{pattern: entry[:i], line: n}
+		}
+		allow.pattern = filepath.ToSlash(strings.TrimPrefix(allow.pattern, "./"))
+		s.allow = append(s.allow, allow)
+	}
+	return nil
+}
+
+// AddAllowFile loads allowlist entries from path, skipping blank lines and
+// "#" comments. A missing file is not an error.
+func (s *SecretScanner) AddAllowFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+	scan := bufio.NewScanner(f)
+	lineNo := 0
+	for scan.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scan.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := s.Allow(line); err != nil {
+			return fmt.Errorf("%s:%d: %v", path, lineNo, err)
+		}
+	}
+	return scan.Err()
+}
+
+func (s *SecretScanner) allowed(path string, line int) bool {
+	for _, a := range s.allow {
+		if a.line != 0 && a.line != line {
+			continue
+		}
+		if a.pattern == path {
+			return true
+		}
+		if ok, _ := filepath.Match(a.pattern, path); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Scan reports the secrets in data, which is the content to be committed at
+// path (relative to the repo root). Binary files and encrypted blobs are
+// skipped, as are allowed locations. At most one finding is reported per line.
+func (s *SecretScanner) Scan(path string, data []byte) []SecretFinding {
+	if IsEncrypted(data) || bytes.IndexByte(data, 0) >= 0 {
+		return nil
+	}
+	path = filepath.ToSlash(path)
+	var findings []SecretFinding
+	for i, line := range strings.Split(string(data), "\n") {
+		lineNo := i + 1
+		for _, rule := range s.rules {
+			match, ok := rule.match(line)
+			if !ok {
+				continue
+			}
+			if !s.allowed(path, lineNo) {
+				findings = append(findings, SecretFinding{Path: path, Line: lineNo, Rule: rule.Name, Match: redact(match)})
+			}
+			break
+		}
+	}
+	return findings
+}
+
+func (r SecretRule) match(line string) (string, bool) {
+	if !r.entropy {
+		m := r.re.FindString(line)
+		return m, m != ""
+	}
+	for _, m := range r.re.FindAllStringSubmatch(line, -1) {
+		if shannonEntropy(m[1]) >= minSecretEntropy {
+			return m[0], true
+		}
+	}
+	return "", false
+}
+
+// redact keeps the start of a match so it can be recognised without
+// printing the secret.
+func redact(s string) string {
+	const keep = 12
+	if len(s) <= keep {
+		return s
+	}
+	return s[:keep] + "..."
+}
+
+func shannonEntropy(s string) float64 {
+	counts := make(map[rune]int)
+	for _, r := range s {
+		counts[r]++
+	}
+	n := float64(len([]rune(s)))
+	var h float64
+	for _, c := range counts {
+		p := float64(c) / n
+		h -= p * math.Log2(p)
+	}
+	return h
+}
@@ -0,0 +1,173 @@
+package services
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"dotman/types"
+)
+
+// SyncRecord is what dotman remembers about a file after it last applied or
+// submitted it: the content hash both sides agreed on at that moment.
+type SyncRecord struct {
+	Hash string `json:"hash"`
+	Time string `json:"time"`
+}
+
+type SyncState struct {
+	Files map[string]SyncRecord `json:"files"`
+}
+
+// StateService persists per-machine sync records outside the dotfiles repo,
+// so that the repo and home copies can be compared against a common base.
+type StateService struct {
+	state SyncState
+	path  string
+}
+
+// StateDir returns the directory dotman keeps per-machine state in,
+// honouring $XDG_STATE_HOME and falling back to ~/.local/state/dotman.
+func StateDir() string {
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return filepath.Join(dir, "dotman")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil || home == "" {
+		return ".dotman-state"
+	}
+	return filepath.Join(home, ".local", "state", "dotman")
+}
+
+func NewStateService() *StateService {
+	return &StateService{
+		state: SyncState{Files: map[string]SyncRecord{}},
+		path:  filepath.Join(StateDir(), "state.json"),
+	}
+}
+
+func (s *StateService) Load() error {
+	bytes, err := os.ReadFile(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			s.state = SyncState{Files: map[string]SyncRecord{}}
+			return nil
+		}
+		return err
+	}
+	if err := json.Unmarshal(bytes, &s.state); err != nil {
+		return err
+	}
+	if s.state.Files == nil {
+		s.state.Files = map[string]SyncRecord{}
+	}
+	return nil
+}
+
+func (s *StateService) Save() error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+	bytes, err := json.MarshalIndent(s.state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return NewFileService().WriteFile(s.path, bytes, 0600)
+}
+
+// Get returns the sync record for a path relative to the home tree.
+func (s *StateService) Get(rel string) (SyncRecord, bool) {
+	rec, ok := s.state.Files[NormalizeRelPath(rel)]
+	return rec, ok
+}
+
+// Record stores hash as the agreed content of rel.
+func (s *StateService) Record(rel, hash string) {
+	if s.state.Files == nil {
+		s.state.Files = map[string]SyncRecord{}
+	}
+	s.state.Files[NormalizeRelPath(rel)] = SyncRecord{
+		Hash: hash,
+		Time: time.Now().Format(time.RFC3339),
+	}
+}
+
+// RecordFile hashes the file at path and stores it as the agreed content of
+// rel, keeping a snapshot of the content as the base for three-way merges.
+func (s *StateService) RecordFile(rel, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return s.RecordContent(rel, data)
+}
+
+// RecordInSync records files a comparison found identical on both sides, so
+// the baseline exists from the first run on. Files already recorded with their
+// current hash are left alone.
+func (s *StateService) RecordInSync(files []types.FileDiff) error {
+	for _, info := range files {
+		if rec, ok := s.Get(info.RelPath); ok && rec.Hash == info.RepoHash {
+			continue
+		}
+		// Both sides hold the same content, so the home copy is its home form.
+		if err := s.RecordFile(info.RelPath, info.HomePath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RecordContent behaves like RecordFile for content that is not on disk in
+// this form, such as a rendered template.
+func (s *StateService) RecordContent(rel string, data []byte) error {
+	fs := NewFileService()
+	base := s.basePath(rel)
+	if err := fs.MkdirAll(filepath.Dir(base), 0700); err != nil {
+		return err
+	}
+	if err := fs.WriteFile(base, data, 0600); err != nil {
+		return err
+	}
+	s.Record(rel, ContentHash(data))
+	return nil
+}
+
+// BasePath returns the snapshot of rel taken at the last sync, if one exists
+// and still matches the recorded hash.
+func (s *StateService) BasePath(rel string) (string, bool) {
+	rec, ok := s.Get(rel)
+	if !ok {
+		return "", false
+	}
+	base := s.basePath(rel)
+	hash, err := NewFileService().FileHash(base)
+	if err != nil || hash != rec.Hash {
+		return "", false
+	}
+	return base, true
+}
+
+// Forget drops the sync record and base snapshot for rel.
+func (s *StateService) Forget(rel string) {
+	delete(s.state.Files, NormalizeRelPath(rel))
+	_ = os.Remove(s.basePath(rel))
+}
+
+// ForgetTree drops the sync records and base snapshots for rel and everything
+// beneath it.
+func (s *StateService) ForgetTree(rel string) {
+	rel = NormalizeRelPath(rel)
+	for key := range s.state.Files {
+		if key == rel || strings.HasPrefix(key, rel+"/") {
+			delete(s.state.Files, key)
+		}
+	}
+	_ = os.RemoveAll(s.basePath(rel))
+}
+
+func (s *StateService) basePath(rel string) string {
+	return filepath.Join(filepath.Dir(s.path), "base", NormalizeRelPath(rel))
+}
@@ -0,0 +1,45 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStateService_RecordFileKeepsBase(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, ".zshrc")
+	writeFile(t, src, "export FOO=bar")
+
+	state := &StateService{path: filepath.Join(dir, "state", "state.json")}
+	if err := state.RecordFile(".zshrc", src); err != nil {
+		t.Fatalf("RecordFile: %v", err)
+	}
+	base, ok := state.BasePath(".zshrc")
+	if !ok {
+		t.Fatalf("expected a base snapshot for .zshrc")
+	}
+	content, err := os.ReadFile(base)
+	if err != nil || string(content) != "export FOO=bar" {
+		t.Fatalf("unexpected base content %q (%v)", content, err)
+	}
+
+	// A record whose hash no longer matches the snapshot has no usable base.
+	state.Record(".zshrc", "deadbeef")
+	if _, ok := state.BasePath(".zshrc"); ok {
+		t.Fatalf("expected stale base snapshot to be rejected")
+	}
+
+	if err := state.Save(); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	reloaded := &StateService{path: state.path}
+	if err := reloaded.Load(); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if rec, ok := reloaded.Get(".zshrc"); !ok || rec.Hash != "deadbeef" {
+		t.Fatalf("expected record to survive reload, got %+v", rec)
+	}
+}
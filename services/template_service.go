@@ -0,0 +1,116 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"text/template"
+)
+
+// TemplateMarker opts a file into template rendering when it appears on the
+// file's first line, e.g. "# dotman:template". The marker line is not rendered.
+const TemplateMarker = "dotman:template"
+
+// TemplateData is what templates see as "." when rendered.
+type TemplateData struct {
+	Hostname string
+	OS       string
+	Arch     string
+	Username string
+	Home     string
+	// Data holds the per-machine values from the template data file.
+	Data map[string]interface{}
+}
+
+// TemplateService renders tracked files that are marked as Go text/template
+// sources, either in the manifest or with TemplateMarker.
+type TemplateService struct {
+	data      TemplateData
+	templates map[string]struct{}
+}
+
+// TemplateDataPath returns the per-machine template data file:
+// $XDG_CONFIG_HOME/dotman/data.json, or ~/.config/dotman/data.json.
+func TemplateDataPath() string {
+	return filepath.Join(ConfigDir(), "data.json")
+}
+
+// NewTemplateService builds the template variables for this machine. templates
+// lists files and directories, relative to the home tree, whose files are
+// rendered without a marker.
+func NewTemplateService(templates []string) *TemplateService {
+	home, _ := os.UserHomeDir()
+	data := TemplateData{
+		Hostname: Hostname(),
+		OS:       runtime.GOOS,
+		Arch:     runtime.GOARCH,
+		Home:     home,
+		Data:     map[string]interface{}{},
+	}
+	if usr, err := user.Current(); err == nil {
+		data.Username = usr.Username
+	}
+	set := make(map[string]struct{}, len(templates))
+	for _, t := range templates {
+		set[filepath.ToSlash(NormalizeRelPath(t))] = struct{}{}
+	}
+	return &TemplateService{data: data, templates: set}
+}
+
+// LoadData reads the per-machine data file at path. A missing file leaves the
+// data empty.
+func (t *TemplateService) LoadData(path string) error {
+	bytes, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return json.Unmarshal(bytes, &t.data.Data)
+}
+
+// Data returns the variables templates are rendered with.
+func (t *TemplateService) Data() TemplateData {
+	return t.data
+}
+
+// Applies reports whether rel is listed as a template, or lies in a listed
+// directory, or starts with the marker.
+func (t *TemplateService) Applies(rel string, repo []byte) bool {
+	for dir := filepath.ToSlash(NormalizeRelPath(rel)); dir != "."; dir = path.Dir(dir) {
+		if _, ok := t.templates[dir]; ok {
+			return true
+		}
+	}
+	firstLine, _, _ := strings.Cut(string(repo), "\n")
+	return strings.Contains(firstLine, TemplateMarker)
+}
+
+// Smudge renders the template source. Referencing a missing key is an error, so
+// a machine lacking a value is noticed instead of getting an empty string.
+func (t *TemplateService) Smudge(rel string, repo []byte) ([]byte, error) {
+	src := string(repo)
+	if firstLine, rest, _ := strings.Cut(src, "\n"); strings.Contains(firstLine, TemplateMarker) {
+		src = rest
+	}
+	tmpl, err := template.New(rel).Option("missingkey=error").Parse(src)
+	if err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, t.data); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// Clean always fails: rendered output cannot be turned back into its template.
+func (t *TemplateService) Clean(rel string, home, current []byte) ([]byte, error) {
+	return nil, ErrNotReversible
+}
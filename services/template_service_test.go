@@ -0,0 +1,108 @@
+package services
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestTemplateService_Render(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	dataPath := filepath.Join(dir, "data.json")
+	writeFile(t, dataPath, `{"email": "me@example.com"}`)
+
+	templates := NewTemplateService([]string{".gitconfig"})
+	if err := templates.LoadData(dataPath); err != nil {
+		t.Fatalf("LoadData: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		rel     string
+		src     string
+		applies bool
+		want    string
+		wantErr bool
+	}{
+		{name: "manifest entry", rel: ".gitconfig", src: "email = {{ .Data.email }}\n", applies: true, want: "email = me@example.com\n"},
+		{name: "marker", rel: ".profile", src: "# dotman:template\nos={{ .OS }}\n", applies: true, want: "os=" + runtime.GOOS + "\n"},
+		{name: "plain file", rel: ".vimrc", src: "set nu {{ not a template }}\n", applies: false},
+		{name: "missing key", rel: ".gitconfig", src: "{{ .Data.missing }}", applies: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		if got := templates.Applies(tt.rel, []byte(tt.src)); got != tt.applies {
+			t.Errorf("%s: Applies = %v, want %v", tt.name, got, tt.applies)
+		}
+		if !tt.applies {
+			continue
+		}
+		out, err := templates.Smudge(tt.rel, []byte(tt.src))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: Smudge error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && string(out) != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, out, tt.want)
+		}
+	}
+}
+
+func TestCompareFilesWith_RendersTemplates(t *testing.T) {
+	t.Parallel()
+
+	repoHome := t.TempDir()
+	userHome := t.TempDir()
+	writeFile(t, filepath.Join(repoHome, ".profile"), "# dotman:template\narch={{ .Arch }}\n")
+	writeFile(t, filepath.Join(userHome, ".profile"), "arch="+runtime.GOARCH+"\n")
+
+	content := NewContentService(NewTemplateService(nil))
+	state := &StateService{path: filepath.Join(t.TempDir(), "state.json")}
+	fs := NewFileService()
+	changed, created, inSync, err := fs.CompareFilesWith(repoHome, userHome, CompareOptions{State: state, Content: content})
+	if err != nil {
+		t.Fatalf("CompareFilesWith: %v", err)
+	}
+	if len(changed) != 0 || len(created) != 0 {
+		t.Fatalf("expected rendered template to match home, got changed=%+v created=%+v", changed, created)
+	}
+
+	// Edits to the rendered file cannot be written back into the template.
+	_, err = content.RepoContent(".profile", []byte("arch=other\n"), filepath.Join(repoHome, ".profile"))
+	if !errors.Is(err, ErrNotReversible) {
+		t.Fatalf("expected ErrNotReversible, got %v", err)
+	}
+	if content.Reversible(".profile", filepath.Join(repoHome, ".profile")) {
+		t.Fatalf("expected template not to be reversible")
+	}
+	if err := state.RecordInSync(inSync); err != nil {
+		t.Fatalf("RecordInSync: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(state.path), "base", ".profile")); err != nil {
+		t.Fatalf("expected rendered base snapshot: %v", err)
+	}
+}
+
+func TestCompareFilesWith_ReportsUnrenderable(t *testing.T) {
+	t.Parallel()
+
+	repoHome := t.TempDir()
+	userHome := t.TempDir()
+	writeFile(t, filepath.Join(repoHome, ".profile"), "# dotman:template\nemail={{ .Data.missing }}\n")
+	writeFile(t, filepath.Join(repoHome, ".vimrc"), "set nu\n")
+
+	content := NewContentService(NewTemplateService(nil))
+	changed, created, _, err := NewFileService().CompareFilesWith(repoHome, userHome, CompareOptions{Content: content})
+	if err != nil {
+		t.Fatalf("CompareFilesWith: %v", err)
+	}
+	if len(changed) != 1 || changed[0].RelPath != ".profile" || changed[0].Err == nil {
+		t.Fatalf("expected .profile reported with an error, got %+v", changed)
+	}
+	if len(created) != 1 || created[0].RelPath != ".vimrc" {
+		t.Fatalf("expected the scan to go on to .vimrc, got created=%+v", created)
+	}
+}
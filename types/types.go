@@ -1,9 +1,60 @@
 package types
 
+import "os"
+
+// SyncDirection describes which side of a tracked file moved since the last
+// time dotman synchronised it.
+type SyncDirection int
+
+const (
+	// InSync means the repo and home copies are identical.
+	InSync SyncDirection = iota
+	// RepoChanged means only the repo copy moved since the last sync.
+	RepoChanged
+	// HomeChanged means only the home copy moved since the last sync.
+	HomeChanged
+	// BothChanged means both copies moved, or there is no sync record to tell.
+	BothChanged
+)
+
+func (d SyncDirection) String() string {
+	switch d {
+	case InSync:
+		return "in-sync"
+	case RepoChanged:
+		return "repo-changed"
+	case HomeChanged:
+		return "home-changed"
+	case BothChanged:
+		return "both-changed"
+	default:
+		return "unknown"
+	}
+}
+
 type FileDiff struct {
-	RelPath  string
-	RepoHash string
-	UserHash string
-	RepoDate string
-	UserDate string
+	RelPath string
+	// RepoPath is the repo copy the home file is compared against: the file in
+	// the base home/ tree or in the overlay that overrides it.
+	RepoPath string
+	// HomePath is the file in $HOME, which is not always $HOME/RelPath when
+	// the manifest gives the entry a target.
+	HomePath  string
+	RepoHash  string
+	UserHash  string
+	RepoDate  string
+	UserDate  string
+	Direction SyncDirection
+	// Filtered is set when the repo file is converted on its way to $HOME
+	// (e.g. a rendered template), so it cannot be copied verbatim.
+	Filtered bool
+	// Sensitive is set when the home content is private (e.g. a decrypted
+	// secret): it is written with 0600 and not displayed unless asked for.
+	Sensitive bool
+	// Mode is the permission the manifest asks for, or 0 when unset.
+	Mode os.FileMode
+	// Err is set when the repo file could not be converted to its home form,
+	// e.g. a template failed to render or no decryption key is available.
+	// Such files carry no hashes or direction and are left alone.
+	Err error
 }